@@ -0,0 +1,226 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/yunfeiyang1916/cloud-docker/container"
+)
+
+// attach协议，monitor与客户端之间的每一帧数据都带有8字节的头部，
+// 第1个字节是流的类型，最后4个字节是大端序的数据长度，长度为0的输入帧表示输入结束
+const (
+	streamStdin  byte = 0
+	streamStdout byte = 1
+	streamStderr byte = 2
+	// 客户端终端的窗口大小，数据为大端序的行数和列数
+	streamResize byte = 3
+)
+
+// DefaultDetachKeys 默认的detach按键序列
+const DefaultDetachKeys = "ctrl-p,ctrl-q"
+
+// 输入了detach按键序列，断开连接但容器继续运行
+var errDetached = errors.New("detached from container")
+
+func writeFrame(w io.Writer, stream byte, data []byte) error {
+	frame := make([]byte, 8+len(data))
+	frame[0] = stream
+	binary.BigEndian.PutUint32(frame[4:8], uint32(len(data)))
+	copy(frame[8:], data)
+	_, err := w.Write(frame)
+	return err
+}
+
+func readFrame(r io.Reader) (byte, []byte, error) {
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, nil, err
+	}
+	data := make([]byte, binary.BigEndian.Uint32(header[4:8]))
+	if _, err := io.ReadFull(r, data); err != nil {
+		return 0, nil, err
+	}
+	return header[0], data, nil
+}
+
+// 解析detach按键序列，格式为逗号分隔的单个字符或ctrl-<字符>，比如ctrl-p,ctrl-q
+func parseDetachKeys(keys string) ([]byte, error) {
+	var seq []byte
+	for _, key := range strings.Split(keys, ",") {
+		switch {
+		case len(key) == 1:
+			seq = append(seq, key[0])
+		case len(key) == 6 && strings.HasPrefix(key, "ctrl-"):
+			c := key[5]
+			switch {
+			case c >= 'a' && c <= 'z':
+				seq = append(seq, c-'a'+1)
+			case c >= '@' && c <= '_':
+				// ctrl-@、ctrl-A到ctrl-Z、ctrl-[、ctrl-\、ctrl-]、ctrl-^、ctrl-_
+				seq = append(seq, c-'@')
+			default:
+				return nil, fmt.Errorf("invalid detach key %s", key)
+			}
+		default:
+			return nil, fmt.Errorf("invalid detach key %s", key)
+		}
+	}
+	return seq, nil
+}
+
+// 从输入中识别detach按键序列，匹配了一部分的按键先暂存，之后不匹配时再原样转发
+type detachReader struct {
+	r    io.Reader
+	keys []byte
+	// 已经匹配的按键个数
+	matched int
+	// 过滤后待返回的输入
+	pending []byte
+	err     error
+}
+
+func (d *detachReader) Read(p []byte) (int, error) {
+	for len(d.pending) == 0 && d.err == nil {
+		buf := make([]byte, len(p))
+		n, err := d.r.Read(buf)
+		d.filter(buf[:n])
+		if err != nil && d.err == nil {
+			d.err = err
+		}
+	}
+	if len(d.pending) > 0 {
+		n := copy(p, d.pending)
+		d.pending = d.pending[n:]
+		return n, nil
+	}
+	return 0, d.err
+}
+
+func (d *detachReader) filter(data []byte) {
+	for _, b := range data {
+		// 已经detach，丢弃之后的输入
+		if d.err != nil {
+			return
+		}
+		if b == d.keys[d.matched] {
+			d.matched++
+			if d.matched == len(d.keys) {
+				d.err = errDetached
+			}
+			continue
+		}
+		d.pending = append(d.pending, d.keys[:d.matched]...)
+		d.matched = 0
+		if b == d.keys[0] {
+			d.matched = 1
+		} else {
+			d.pending = append(d.pending, b)
+		}
+	}
+}
+
+// 连接容器monitor进程的attach socket
+func dialContainer(containerID string) (net.Conn, error) {
+	socketPath := fmt.Sprintf(container.DefaultInfoLocation, containerID) + container.AttachSocket
+	conn, err := net.Dial("unix", socketPath)
+	if err != nil {
+		return nil, fmt.Errorf("connect to container %s error %v", container.ShortID(containerID), err)
+	}
+	return conn, nil
+}
+
+// 把当前进程的标准输入输出连接到容器上，直到容器退出或者输入了detach按键序列
+// 容器退出时返回nil，detach时返回errDetached
+func attachStreams(conn net.Conn, tty bool, detachKeys []byte) error {
+	defer conn.Close()
+	// 输入和窗口大小在不同的goroutine中发送，需要保证每一帧完整
+	var mu sync.Mutex
+	send := func(stream byte, data []byte) error {
+		mu.Lock()
+		defer mu.Unlock()
+		return writeFrame(conn, stream, data)
+	}
+	if tty && container.IsTerminal(os.Stdin) {
+		// raw模式下按键原样交给容器内的进程处理，detach按键序列也在这里识别
+		if restore, err := container.SetRawTerminal(os.Stdin); err == nil {
+			defer restore()
+		}
+		sendSize := func() {
+			rows, cols, err := container.TerminalSize(os.Stdin)
+			if err != nil {
+				return
+			}
+			data := make([]byte, 4)
+			binary.BigEndian.PutUint16(data[0:2], rows)
+			binary.BigEndian.PutUint16(data[2:4], cols)
+			send(streamResize, data)
+		}
+		sendSize()
+		sigCh := make(chan os.Signal, 1)
+		signal.Notify(sigCh, syscall.SIGWINCH)
+		defer func() {
+			signal.Stop(sigCh)
+			close(sigCh)
+		}()
+		go func() {
+			for range sigCh {
+				sendSize()
+			}
+		}()
+	}
+	detached := make(chan error, 1)
+	go func() {
+		stdin := &detachReader{r: os.Stdin, keys: detachKeys}
+		buf := make([]byte, 32*1024)
+		for {
+			n, err := stdin.Read(buf)
+			if n > 0 && send(streamStdin, buf[:n]) != nil {
+				return
+			}
+			if err == errDetached {
+				detached <- err
+			}
+			// 标准输入结束时发送空的输入帧，monitor关闭容器的标准输入
+			if err == io.EOF {
+				send(streamStdin, nil)
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	exited := make(chan error, 1)
+	go func() {
+		for {
+			stream, data, err := readFrame(conn)
+			if err != nil {
+				// monitor在容器退出后关闭连接
+				if err == io.EOF {
+					err = nil
+				}
+				exited <- err
+				return
+			}
+			if stream == streamStderr {
+				os.Stderr.Write(data)
+			} else {
+				os.Stdout.Write(data)
+			}
+		}
+	}()
+	select {
+	case err := <-detached:
+		return err
+	case err := <-exited:
+		return err
+	}
+}
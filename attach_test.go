@@ -0,0 +1,40 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestParseDetachKeys(t *testing.T) {
+	keys, err := parseDetachKeys(DefaultDetachKeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(keys, []byte{16, 17}) {
+		t.Fatalf("unexpected detach keys %v", keys)
+	}
+	if keys, _ = parseDetachKeys("ctrl-[,x"); !bytes.Equal(keys, []byte{27, 'x'}) {
+		t.Fatalf("unexpected detach keys %v", keys)
+	}
+	for _, invalid := range []string{"", "ctrl-", "ctrl-1", "alt-a"} {
+		if _, err = parseDetachKeys(invalid); err == nil {
+			t.Fatalf("expect error for detach keys %q", invalid)
+		}
+	}
+}
+
+func TestDetachReader(t *testing.T) {
+	keys := []byte{16, 17}
+	// 只匹配了一部分的按键原样转发
+	out, err := ioutil.ReadAll(&detachReader{r: strings.NewReader("a\x10b\x10\x10c"), keys: keys})
+	if err != nil || string(out) != "a\x10b\x10\x10c" {
+		t.Fatalf("unexpected output %q %v", out, err)
+	}
+	// 匹配完整序列后返回errDetached，之后的输入被丢弃
+	out, err = ioutil.ReadAll(&detachReader{r: strings.NewReader("ls\x10\x11rm"), keys: keys})
+	if err != errDetached || string(out) != "ls" {
+		t.Fatalf("unexpected output %q %v", out, err)
+	}
+}
@@ -1,6 +1,10 @@
 package cgroups
 
 import (
+	"io/ioutil"
+	"sort"
+	"strings"
+
 	"github.com/sirupsen/logrus"
 	"github.com/yunfeiyang1916/cloud-docker/cgroups/subsystems"
 )
@@ -19,26 +23,55 @@ func NewCgroupManager(path string) *CgroupManager {
 
 // Apply 将进程pid加入到这个cgroup中
 func (c *CgroupManager) Apply(pid int) error {
-	for _, subSysIns := range subsystems.SubSystemsIns {
-		subSysIns.Apply(c.Path, pid)
+	for _, subSysIns := range subsystems.Instances() {
+		if err := subSysIns.Apply(c.Path, pid); err != nil {
+			logrus.Warnf("apply cgroup %s fail %v", subSysIns.Name(), err)
+		}
 	}
 	return nil
 }
 
 // Set 设置cgroup资源限制
 func (c *CgroupManager) Set(res *subsystems.ResourceConfig) error {
-	for _, subSysIns := range subsystems.SubSystemsIns {
-		subSysIns.Set(c.Path, res)
+	for _, subSysIns := range subsystems.Instances() {
+		if err := subSysIns.Set(c.Path, res); err != nil {
+			logrus.Warnf("set cgroup %s fail %v", subSysIns.Name(), err)
+		}
 	}
 	return nil
 }
 
 // Destroy 释放cgroup
 func (c *CgroupManager) Destroy() error {
-	for _, subSysIns := range subsystems.SubSystemsIns {
+	for _, subSysIns := range subsystems.Instances() {
 		if err := subSysIns.Remove(c.Path); err != nil {
 			logrus.Warnf("remove cgroup fail %v", err)
 		}
 	}
 	return nil
 }
+
+// List 列出各个子系统中名字以prefix开头的cgroup，同名的cgroup只返回一次
+func List(prefix string) []string {
+	seen := map[string]bool{}
+	var names []string
+	for _, subSysIns := range subsystems.Instances() {
+		cgroupRoot, err := subsystems.CgroupRoot(subSysIns)
+		if err != nil {
+			continue
+		}
+		files, err := ioutil.ReadDir(cgroupRoot)
+		if err != nil {
+			continue
+		}
+		for _, file := range files {
+			if !file.IsDir() || !strings.HasPrefix(file.Name(), prefix) || seen[file.Name()] {
+				continue
+			}
+			seen[file.Name()] = true
+			names = append(names, file.Name())
+		}
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,37 @@
+package cgroups
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/yunfeiyang1916/cloud-docker/cgroups/subsystems"
+)
+
+// ProcsFiles 进程pid所在的各个cgroup的cgroup.procs文件，把其他进程的pid写入这些文件即可加入相同的cgroup
+func ProcsFiles(pid string) ([]string, error) {
+	f, err := os.Open(fmt.Sprintf("/proc/%s/cgroup", pid))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var files []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// 格式为 hierarchy-id:subsystem,...:cgroup路径，v2统一层级的subsystem部分为空
+		fields := strings.SplitN(scanner.Text(), ":", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		subsystem := strings.Split(fields[1], ",")[0]
+		mountpoint, root := subsystems.FindCgroupMount(subsystem)
+		if mountpoint == "" {
+			continue
+		}
+		cgroupPath := strings.TrimPrefix(fields[2], root)
+		files = append(files, path.Join(mountpoint, cgroupPath, "cgroup.procs"))
+	}
+	return files, scanner.Err()
+}
@@ -0,0 +1,140 @@
+package subsystems
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// UnifiedMountpoint cgroup v2统一层级的挂载点
+const UnifiedMountpoint = "/sys/fs/cgroup"
+
+// IsCgroup2 宿主机是否只挂载了cgroup v2统一层级
+func IsCgroup2() bool {
+	_, err := os.Stat(path.Join(UnifiedMountpoint, "cgroup.controllers"))
+	return err == nil
+}
+
+// CgroupV2SubSystem cgroup v2下所有资源限制都在同一个目录中，因此用一个子系统处理
+type CgroupV2SubSystem struct {
+}
+
+// Name 名称
+func (s *CgroupV2SubSystem) Name() string {
+	return "unified"
+}
+
+// Set 设置cgroupPath对应的cgroup的资源限制
+func (s *CgroupV2SubSystem) Set(cgroupPath string, res *ResourceConfig) error {
+	cgroupRoot, err := cgroupV2Root()
+	if err != nil {
+		return err
+	}
+	// 子cgroup只能使用父cgroup在cgroup.subtree_control中开启了的控制器
+	enableControllers(cgroupRoot)
+	fullPath := path.Join(cgroupRoot, cgroupPath)
+	if err := os.MkdirAll(fullPath, 0755); err != nil {
+		return fmt.Errorf("error create cgroup %v", err)
+	}
+	if res.MemoryLimit != "" {
+		if err := ioutil.WriteFile(path.Join(fullPath, "memory.max"), []byte(res.MemoryLimit), 0644); err != nil {
+			return fmt.Errorf("set cgroup memory fail %v", err)
+		}
+	}
+	if res.CpuShare != "" {
+		// v2中使用cpu.weight代替cpu.shares，取值范围[1, 10000]
+		shares, err := strconv.ParseUint(res.CpuShare, 10, 64)
+		if err != nil {
+			return fmt.Errorf("parse cpushare %s fail %v", res.CpuShare, err)
+		}
+		weight := 1 + ((shares-2)*9999)/262142
+		if shares < 2 {
+			weight = 1
+		}
+		if err := ioutil.WriteFile(path.Join(fullPath, "cpu.weight"), []byte(strconv.FormatUint(weight, 10)), 0644); err != nil {
+			return fmt.Errorf("set cgroup cpu fail %v", err)
+		}
+	}
+	if res.CpuSet != "" {
+		if err := ioutil.WriteFile(path.Join(fullPath, "cpuset.cpus"), []byte(res.CpuSet), 0644); err != nil {
+			return fmt.Errorf("set cgroup cpuset fail %v", err)
+		}
+	}
+	return nil
+}
+
+// Apply 将一个进程加入到cgroupPath对应的cgroup中
+func (s *CgroupV2SubSystem) Apply(cgroupPath string, pid int) error {
+	cgroupRoot, err := cgroupV2Root()
+	if err != nil {
+		return err
+	}
+	// v2中通过cgroup.procs加入进程
+	procsFile := path.Join(cgroupRoot, cgroupPath, "cgroup.procs")
+	if err = ioutil.WriteFile(procsFile, []byte(strconv.Itoa(pid)), 0644); err != nil {
+		return fmt.Errorf("set cgroup proc fail %v", err)
+	}
+	return nil
+}
+
+// Remove 删除cgroupPath对应的cgroup
+func (s *CgroupV2SubSystem) Remove(cgroupPath string) error {
+	cgroupRoot, err := cgroupV2Root()
+	if err != nil {
+		return err
+	}
+	// cgroup目录中的接口文件不能删除，只能rmdir整个目录
+	return os.Remove(path.Join(cgroupRoot, cgroupPath))
+}
+
+// 在父cgroup中开启所有可用的控制器
+func enableControllers(cgroupRoot string) {
+	content, err := ioutil.ReadFile(path.Join(cgroupRoot, "cgroup.controllers"))
+	if err != nil {
+		return
+	}
+	for _, controller := range strings.Fields(string(content)) {
+		if controller != "cpu" && controller != "cpuset" && controller != "memory" {
+			continue
+		}
+		ioutil.WriteFile(path.Join(cgroupRoot, "cgroup.subtree_control"), []byte("+"+controller), 0644)
+	}
+}
+
+// 容器cgroup的父目录，root用户直接使用根cgroup
+// 非特权用户只能使用systemd委派给它的user@<uid>.service子树
+func cgroupV2Root() (string, error) {
+	if os.Geteuid() == 0 {
+		return UnifiedMountpoint, nil
+	}
+	f, err := os.Open("/proc/self/cgroup")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	delegate := fmt.Sprintf("user@%d.service", os.Geteuid())
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// v2的记录格式为 0::/user.slice/user-1000.slice/user@1000.service/...
+		txt := scanner.Text()
+		if !strings.HasPrefix(txt, "0::") {
+			continue
+		}
+		idx := strings.Index(txt, delegate)
+		if idx < 0 {
+			break
+		}
+		cgroupRoot := path.Join(UnifiedMountpoint, txt[3:idx+len(delegate)])
+		// 2即W_OK，检查是否有写权限
+		if err = syscall.Access(cgroupRoot, 2); err != nil {
+			return "", fmt.Errorf("cgroup %s is not delegated to current user: %v", cgroupRoot, err)
+		}
+		return cgroupRoot, nil
+	}
+	return "", fmt.Errorf("no delegated cgroup v2 subtree for current user")
+}
@@ -26,7 +26,7 @@ func (s *CpuSubSystem) Set(cgroupPath string, res *ResourceConfig) error {
 	}
 	if res.CpuShare != "" {
 		// 设置这个cgroup的内存限制，即将限制写入到cgroup对应目录的cpu.shares文件中
-		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "cpu.shares"), []byte(res.MemoryLimit), 0644); err != nil {
+		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "cpu.shares"), []byte(res.CpuShare), 0644); err != nil {
 			return fmt.Errorf("set cgroup memory fail %v", err)
 		}
 	}
@@ -26,7 +26,7 @@ func (s *CpusetSubSystem) Set(cgroupPath string, res *ResourceConfig) error {
 	}
 	if res.CpuSet != "" {
 		// 设置这个cgroup的内存限制，即将限制写入到cgroup对应目录的cpuset.cpus文件中
-		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "cpuset.cpus"), []byte(res.MemoryLimit), 0644); err != nil {
+		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "cpuset.cpus"), []byte(res.CpuSet), 0644); err != nil {
 			return fmt.Errorf("set cgroup memory fail %v", err)
 		}
 	}
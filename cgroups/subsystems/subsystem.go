@@ -3,11 +3,11 @@ package subsystems
 // ResourceConfig 用于传递资源限制的结构体
 type ResourceConfig struct {
 	// 内存限制
-	MemoryLimit string
+	MemoryLimit string `json:"memory,omitempty"`
 	// cpu时间片权重
-	CpuShare string
+	CpuShare string `json:"cpuShares,omitempty"`
 	// cpu核心数
-	CpuSet string
+	CpuSet string `json:"cpusetCpus,omitempty"`
 }
 
 // SubSystem 接口，这里将cgroup抽象成了path，原因是cgroup在hierarchy路径，便是虚拟文件中的虚拟路径
@@ -24,3 +24,11 @@ type SubSystem interface {
 
 // SubSystemsIns 通过不同的subsystem初始化实例创建资源限制处理链数组
 var SubSystemsIns = []SubSystem{&CpuSubSystem{}, &CpusetSubSystem{}, &MemorySubSystem{}}
+
+// Instances 当前宿主机可用的资源限制处理链，cgroup v2下只有一个统一的子系统
+func Instances() []SubSystem {
+	if IsCgroup2() {
+		return []SubSystem{&CgroupV2SubSystem{}}
+	}
+	return SubSystemsIns
+}
@@ -3,6 +3,7 @@ package subsystems
 import (
 	"bufio"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path"
 	"strings"
@@ -10,25 +11,36 @@ import (
 
 // FindCgroupMountpoint 通过/proc/self/mountinfo找出挂载了某个subsystem的hierarchy cgroup根节点所在的目录
 func FindCgroupMountpoint(subsystem string) string {
+	mountpoint, _ := FindCgroupMount(subsystem)
+	return mountpoint
+}
+
+// FindCgroupMount 找出挂载了某个subsystem的hierarchy的挂载点，以及挂载的hierarchy中的目录
+// subsystem为空时查找cgroup v2统一层级的挂载点
+func FindCgroupMount(subsystem string) (string, string) {
 	f, err := os.Open("/proc/self/mountinfo")
 	if err != nil {
-		return ""
+		return "", ""
 	}
 	defer f.Close()
 	scanner := bufio.NewScanner(f)
 	for scanner.Scan() {
 		txt := scanner.Text()
 		fields := strings.Split(txt, " ")
+		// 格式为 id parent major:minor root mountpoint options ... - fstype source superoptions
+		if subsystem == "" {
+			if len(fields) > 3 && fields[len(fields)-3] == "cgroup2" {
+				return fields[4], fields[3]
+			}
+			continue
+		}
 		for _, opt := range strings.Split(fields[len(fields)-1], ",") {
 			if opt == subsystem {
-				return fields[4]
+				return fields[4], fields[3]
 			}
 		}
 	}
-	if err := scanner.Err(); err != nil {
-		return ""
-	}
-	return ""
+	return "", ""
 }
 
 // GetCgroupPath 得到cgroup在文件系统中的绝对路径
@@ -45,3 +57,45 @@ func GetCgroupPath(subsystem string, cgroupPath string, autoCreate bool) (string
 		return "", fmt.Errorf("cgroup path error %v", err)
 	}
 }
+
+// CgroupRoot 子系统的根cgroup目录，在它下面创建的目录就是各个容器的cgroup
+func CgroupRoot(subsystem SubSystem) (string, error) {
+	if _, ok := subsystem.(*CgroupV2SubSystem); ok {
+		return cgroupV2Root()
+	}
+	cgroupRoot := FindCgroupMountpoint(subsystem.Name())
+	if cgroupRoot == "" {
+		return "", fmt.Errorf("subsystem %s is not mounted", subsystem.Name())
+	}
+	return cgroupRoot, nil
+}
+
+// AvailableControllers 宿主机上可以使用的cgroup控制器
+// cgroup v2读取根cgroup的cgroup.controllers，v1中只有挂载了hierarchy的子系统才可用
+func AvailableControllers() []string {
+	if IsCgroup2() {
+		content, err := ioutil.ReadFile(path.Join(UnifiedMountpoint, "cgroup.controllers"))
+		if err != nil {
+			return nil
+		}
+		return strings.Fields(string(content))
+	}
+	f, err := os.Open("/proc/cgroups")
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	var controllers []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// 格式为 subsys_name hierarchy num_cgroups enabled，第一行是表头
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 4 || strings.HasPrefix(fields[0], "#") || fields[3] != "1" {
+			continue
+		}
+		if FindCgroupMountpoint(fields[0]) != "" {
+			controllers = append(controllers, fields[0])
+		}
+	}
+	return controllers
+}
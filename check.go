@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/yunfeiyang1916/cloud-docker/container"
+)
+
+const (
+	checkOK   = "ok"
+	checkWarn = "warn"
+	checkFail = "fail"
+)
+
+// system check中的一项检查结果，fail表示容器无法正常运行，warn表示部分功能不可用
+type checkResult struct {
+	Name   string
+	Status string
+	Detail string
+}
+
+// 容器必须使用的namespace，其余的只在使用对应功能时需要
+var requiredNamespaces = []string{"mnt", "uts", "ipc", "pid", "net"}
+
+// 资源限制用到的cgroup控制器
+var requiredControllers = []string{"cpu", "cpuset", "memory"}
+
+// 逐项检查运行容器需要的内核特性和外部命令
+func systemChecks(info *systemInfo) []checkResult {
+	var results []checkResult
+	supported := map[string]bool{}
+	for _, nsType := range info.Namespaces {
+		supported[nsType] = true
+	}
+	for _, nsType := range container.AllNamespaces {
+		status := checkOK
+		detail := "supported"
+		if !supported[nsType] {
+			status, detail = checkWarn, "not supported by the kernel"
+			for _, required := range requiredNamespaces {
+				if nsType == required {
+					status = checkFail
+				}
+			}
+		}
+		results = append(results, checkResult{Name: nsType + " namespace", Status: status, Detail: detail})
+	}
+
+	controllers := map[string]bool{}
+	for _, c := range info.CgroupControllers {
+		controllers[c] = true
+	}
+	for _, c := range requiredControllers {
+		result := checkResult{Name: c + " cgroup", Status: checkOK, Detail: "cgroup v" + info.CgroupVersion}
+		if !controllers[c] {
+			result.Status, result.Detail = checkWarn, "controller is not mounted or enabled, resource limits are ignored"
+		}
+		results = append(results, result)
+	}
+
+	result := checkResult{Name: "storage driver", Status: checkOK, Detail: info.StorageDriver}
+	if !container.StorageDriverSupported(info.StorageDriver) {
+		result.Status = checkFail
+		result.Detail = fmt.Sprintf("%s is not supported, available drivers: %s", info.StorageDriver, orNone(strings.Join(info.StorageDrivers, ", ")))
+	}
+	results = append(results, result)
+
+	result = checkResult{Name: "iptables", Status: checkOK, Detail: fmt.Sprintf("%s (%s)", info.Iptables, orNone(info.IptablesBackend))}
+	if info.Iptables == "" {
+		result.Status, result.Detail = checkWarn, "not found, bridge networks and port mapping are unavailable"
+	}
+	results = append(results, result)
+
+	for _, tool := range []struct{ name, usage string }{
+		{"tar", "images can not be unpacked or committed"},
+		{"slirp4netns", "slirp networks are unavailable"},
+	} {
+		result = checkResult{Name: tool.name, Status: checkOK}
+		if path, err := exec.LookPath(tool.name); err != nil {
+			result.Status, result.Detail = checkWarn, "not found, "+tool.usage
+		} else {
+			result.Detail = path
+		}
+		results = append(results, result)
+	}
+
+	for _, root := range []struct{ name, dir string }{
+		{"data root", info.DataRoot},
+		{"exec root", info.ExecRoot},
+	} {
+		result = checkResult{Name: root.name, Status: checkOK, Detail: root.dir}
+		if err := checkWritable(root.dir); err != nil {
+			result.Status, result.Detail = checkFail, err.Error()
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// 目录不存在时需要能够创建，存在时需要能够写入
+func checkWritable(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(dir, ".check-")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// system check命令的执行函数，有检查失败时返回错误
+func systemCheck(configFile string) error {
+	results := systemChecks(collectInfo(configFile))
+	w := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
+	fmt.Fprint(w, "CHECK\tSTATUS\tDETAIL\n")
+	failed := 0
+	for _, r := range results {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", r.Name, r.Status, r.Detail)
+		if r.Status == checkFail {
+			failed++
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d checks failed", failed, len(results))
+	}
+	return nil
+}
@@ -4,12 +4,15 @@ import (
 	"fmt"
 	"github.com/yunfeiyang1916/cloud-docker/network"
 	"os"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
 	"github.com/yunfeiyang1916/cloud-docker/cgroups/subsystems"
 	"github.com/yunfeiyang1916/cloud-docker/container"
+	"github.com/yunfeiyang1916/cloud-docker/logger"
 )
 
 // 内部初始化命令，不能从外部调用
@@ -23,6 +26,25 @@ var initCommand = cli.Command{
 	},
 }
 
+// 内部命令，作为pod的infra进程持有pod共享的namespace，不能从外部调用
+var pauseCommand = cli.Command{
+	Name:  "pause",
+	Usage: "Pod infra process holding shared namespaces. Do not call it outside",
+	Action: func(ctx *cli.Context) error {
+		return runPause()
+	},
+}
+
+// 内部命令，持有容器的标准输入输出并提供attach socket，不能从外部调用
+var monitorCommand = cli.Command{
+	Name:   "monitor",
+	Usage:  "Container monitor process starting the container and holding its stdio. Do not call it outside",
+	Hidden: true,
+	Action: func(ctx *cli.Context) error {
+		return runMonitor()
+	},
+}
+
 // run命令执行函数,其作用类似于运行命令时使用--来指定参数
 var runCommand = cli.Command{
 	Name: "run",
@@ -30,6 +52,7 @@ var runCommand = cli.Command{
 			cloud-docker run -ti [command]`,
 	Flags: []cli.Flag{
 		cli.BoolFlag{Name: "ti", Usage: "enable tty"},
+		cli.BoolFlag{Name: "i", Usage: "keep stdin open for attach, even without a tty"},
 		cli.StringFlag{Name: "v", Usage: "volume"},
 		cli.BoolFlag{Name: "d", Usage: "detach container"},
 		cli.StringFlag{Name: "m", Usage: "memory limit"},
@@ -37,8 +60,24 @@ var runCommand = cli.Command{
 		cli.StringFlag{Name: "cpuset", Usage: "cpuset limit"},
 		cli.StringFlag{Name: "name", Usage: "container name"}, // 容器名字
 		cli.StringSliceFlag{Name: "e", Usage: "set environment"},
-		cli.StringFlag{Name: "net", Usage: "container network"},
+		cli.StringFlag{Name: "net", Usage: "container network, host or container:<name> to share an existing network stack"},
+		cli.StringFlag{Name: "pid", Usage: "pid namespace to use, host or container:<name>"},
+		cli.StringFlag{Name: "ipc", Usage: "ipc namespace to use, host or container:<name>"},
+		cli.StringFlag{Name: "uts", Usage: "uts namespace to use, host or container:<name>"},
+		cli.StringFlag{Name: "pod", Usage: "run container in an existing pod"},
+		cli.StringFlag{Name: "cgroupns", Usage: "cgroup namespace to use, private (default) or host"},
+		cli.StringSliceFlag{Name: "time-offset", Usage: "run in a new time namespace with clock offset, monotonic=<duration> or boottime=<duration>"},
 		cli.StringSliceFlag{Name: "p", Usage: "port mapping"},
+		cli.StringFlag{Name: "userns-remap", Usage: "remap container root to subordinate ids, user[:group] or default"},
+		cli.StringSliceFlag{Name: "security-opt", Usage: "security options: no-new-privileges, apparmor=<profile>, label=<type:value>"},
+		cli.StringSliceFlag{Name: "ulimit", Usage: "ulimit options, name=soft:hard"},
+		cli.StringFlag{Name: "detach-keys", Value: DefaultDetachKeys, Usage: "key sequence for detaching a tty container"},
+		cli.StringFlag{Name: "log-driver", Value: logger.DefaultDriver, Usage: "logging driver: json-file, local, syslog or none"},
+		cli.StringSliceFlag{Name: "log-opt", Usage: "log driver options, e.g. max-size=10m, max-file=3"},
+		cli.StringSliceFlag{Name: "label, l", Usage: "set metadata on the container, key=value"},
+		cli.StringSliceFlag{Name: "label-file", Usage: "read in a line delimited file of labels"},
+		cli.StringSliceFlag{Name: "dns", Usage: "set custom dns servers"},
+		cli.StringFlag{Name: "storage-driver", Usage: "storage driver for the container filesystem: aufs, overlay or fuse-overlayfs"},
 	},
 	Action: func(ctx *cli.Context) error {
 		// 判断参数是否包含command
@@ -72,8 +111,121 @@ var runCommand = cli.Command{
 		envSlice := ctx.StringSlice("e")
 
 		network := ctx.String("net")
+		// 未指定--net时连接配置文件中的默认网络，加入pod的容器使用pod的网络
+		if !ctx.IsSet("net") && ctx.String("pod") == "" {
+			network = daemonCfg.DefaultNetwork
+		}
 		portmapping := ctx.StringSlice("p")
-		Run(tty, cmdArray, resConf, containerName, volume, imageName, envSlice, network, portmapping)
+		// 与宿主机共享或加入其他容器的namespace
+		namespaces := map[string]string{}
+		// --net=host和--net=container:<name>直接使用已有的网络栈，其余的值是要连接的网络名
+		if network == container.NamespaceHost || strings.HasPrefix(network, container.NamespaceContainerPrefix) {
+			if len(portmapping) > 0 {
+				return fmt.Errorf("port mapping can not be used with --net=%s", network)
+			}
+			namespaces["net"] = network
+			network = ""
+		}
+		for _, nsType := range []string{"pid", "ipc", "uts"} {
+			if mode := ctx.String(nsType); mode != "" {
+				namespaces[nsType] = mode
+			}
+		}
+		// 加入pod时共享pod的网络、ipc和uts，端口映射也由pod统一配置
+		pod := ctx.String("pod")
+		if pod != "" {
+			if network != "" || len(portmapping) > 0 || len(namespaces) > 0 {
+				return fmt.Errorf("--pod can not be used with --net, -p, --ipc or --uts")
+			}
+			for _, nsType := range container.PodNamespaces {
+				namespaces[nsType] = container.NamespacePodPrefix + pod
+			}
+		}
+		for nsType, mode := range namespaces {
+			if err := container.ParseNamespaceMode(nsType, mode); err != nil {
+				return err
+			}
+		}
+		// 解析uid/gid映射
+		userns, err := container.NewUsernsRemap(ctx.String("userns-remap"))
+		if err != nil {
+			return fmt.Errorf("userns remap error: %v", err)
+		}
+		// rootless模式下总是创建user namespace，把当前用户映射为容器内的root
+		if container.IsRootless() {
+			if userns != nil {
+				return fmt.Errorf("userns-remap can not be used in rootless mode")
+			}
+			userns = container.RootlessUserns()
+		}
+		security, err := container.ParseSecurityOpts(ctx.StringSlice("security-opt"))
+		if err != nil {
+			return err
+		}
+		rlimits, err := container.ParseUlimits(daemonCfg.ulimits(ctx.StringSlice("ulimit")))
+		if err != nil {
+			return err
+		}
+		cgroupns, err := container.ParseCgroupns(ctx.String("cgroupns"))
+		if err != nil {
+			return err
+		}
+		timeOffsets, err := container.ParseTimeOffsets(ctx.StringSlice("time-offset"))
+		if err != nil {
+			return err
+		}
+		detachKeys, err := parseDetachKeys(ctx.String("detach-keys"))
+		if err != nil {
+			return err
+		}
+		logConfig, err := daemonCfg.logConfig(ctx.String("log-driver"), ctx.IsSet("log-driver"), ctx.StringSlice("log-opt"))
+		if err != nil {
+			return err
+		}
+		labels, err := parseLabels(ctx.StringSlice("label"), ctx.StringSlice("label-file"))
+		if err != nil {
+			return err
+		}
+		dns := ctx.StringSlice("dns")
+		if len(dns) == 0 {
+			dns = daemonCfg.DNS
+		}
+		if dns, err = container.ParseDNS(dns); err != nil {
+			return err
+		}
+		storageDriver := ctx.String("storage-driver")
+		if storageDriver == "" {
+			storageDriver = daemonCfg.StorageDriver
+		}
+		if storageDriver, err = container.ParseStorageDriver(storageDriver); err != nil {
+			return err
+		}
+		info := &container.ContainerInfo{
+			Name:          containerName,
+			Image:         imageName,
+			Volume:        volume,
+			PortMapping:   portmapping,
+			Network:       network,
+			Security:      security,
+			Rlimits:       rlimits,
+			Namespaces:    namespaces,
+			Pod:           pod,
+			Cgroupns:      cgroupns,
+			TimeOffsets:   timeOffsets,
+			Tty:           tty,
+			OpenStdin:     ctx.Bool("i"),
+			LogConfig:     logConfig,
+			Env:           envSlice,
+			Resources:     resConf,
+			Labels:        labels,
+			Dns:           dns,
+			StorageDriver: storageDriver,
+		}
+		if userns != nil {
+			info.UidMappings = userns.UidMappings
+			info.GidMappings = userns.GidMappings
+		}
+		Run(info, cmdArray, detachKeys)
 		return nil
 	},
 }
@@ -95,74 +247,275 @@ var commitCommand = cli.Command{
 
 var listCommand = cli.Command{
 	Name:  "ps",
-	Usage: "list all the containers",
+	Usage: "list containers",
+	// 支持-aq这样的组合短参数
+	UseShortOptionHandling: true,
+	Flags: []cli.Flag{
+		cli.BoolFlag{Name: "all, a", Usage: "show all containers (default shows just running)"},
+		cli.BoolFlag{Name: "quiet, q", Usage: "only display container IDs"},
+		cli.BoolFlag{Name: "no-trunc", Usage: "don't truncate output"},
+		cli.StringSliceFlag{Name: "filter", Usage: "filter output based on conditions: id, name, status, label, network, ancestor"},
+		cli.StringFlag{Name: "format", Usage: "pretty-print containers using a Go template, 'table TEMPLATE' or 'json'"},
+	},
 	Action: func(ctx *cli.Context) error {
-		ListContainers()
-		return nil
+		return ListContainers(PsOptions{
+			All:     ctx.Bool("all"),
+			Quiet:   ctx.Bool("quiet"),
+			NoTrunc: ctx.Bool("no-trunc"),
+			Filters: ctx.StringSlice("filter"),
+			Format:  ctx.String("format"),
+		})
 	},
 }
 
 var logCommand = cli.Command{
 	Name:  "logs",
 	Usage: "print logs of a container",
+	Flags: []cli.Flag{
+		cli.BoolFlag{Name: "follow, f", Usage: "follow log output"},
+		cli.StringFlag{Name: "tail", Value: "all", Usage: "number of lines to show from the end of the logs"},
+		cli.StringFlag{Name: "since", Usage: "show logs since timestamp (e.g. 2013-01-02T13:23:37Z) or relative (e.g. 42m)"},
+		cli.StringFlag{Name: "until", Usage: "show logs before timestamp (e.g. 2013-01-02T13:23:37Z) or relative (e.g. 42m)"},
+		cli.BoolFlag{Name: "timestamps, t", Usage: "show timestamps"},
+	},
 	Action: func(ctx *cli.Context) error {
 		if len(ctx.Args()) < 1 {
 			return fmt.Errorf("Please input your container name")
 		}
 		containerName := ctx.Args().Get(0)
-		logContainer(containerName)
-		return nil
+		cfg := logger.ReadConfig{Follow: ctx.Bool("follow")}
+		var err error
+		if cfg.Tail, err = parseLogTail(ctx.String("tail")); err != nil {
+			return err
+		}
+		now := time.Now()
+		if cfg.Since, err = parseLogTime(ctx.String("since"), now); err != nil {
+			return err
+		}
+		if cfg.Until, err = parseLogTime(ctx.String("until"), now); err != nil {
+			return err
+		}
+		return logContainer(containerName, cfg, ctx.Bool("timestamps"))
 	},
 }
 
 var execCommand = cli.Command{
 	Name:  "exec",
 	Usage: "exec a command into container",
+	// 支持 -it 这样的组合写法
+	UseShortOptionHandling: true,
+	Flags: []cli.Flag{
+		cli.BoolFlag{Name: "i", Usage: "keep stdin open"},
+		cli.BoolFlag{Name: "t", Usage: "allocate a pseudo-terminal"},
+		cli.BoolFlag{Name: "d", Usage: "run command in the background"},
+		cli.StringSliceFlag{Name: "e", Usage: "set environment"},
+		cli.StringFlag{Name: "w", Usage: "working directory inside the container"},
+		cli.StringFlag{Name: "u", Usage: "username or uid, format: user[:group]"},
+	},
 	Action: func(ctx *cli.Context) error {
-		// 判断是否是执行exec fork回调回来的
+		// 判断是否是执行exec fork回调回来的，此时已经在容器的namespace中了
 		if os.Getenv(EnvExecPID) != "" {
-			logrus.Infof("pid callback pid %v", os.Getgid())
-			return nil
+			return container.RunContainerExecProcess()
 		}
 		// 我们希望命令格式是cloud_docker exec 容器名 命令
 		if len(ctx.Args()) < 2 {
 			return fmt.Errorf("missing container name or command")
 		}
+		opts := ExecOptions{
+			Interactive: ctx.Bool("i"),
+			Tty:         ctx.Bool("t"),
+			Detach:      ctx.Bool("d"),
+		}
+		// 后台执行时没有终端可以连接
+		if opts.Detach && (opts.Interactive || opts.Tty) {
+			return fmt.Errorf("-d can not be used with -i or -t")
+		}
 		containerName := ctx.Args().Get(0)
-		var cmdArray []string
-		// 将除了容器名之外的参数当作需要执行的命令处理
-		for _, arg := range ctx.Args().Tail() {
-			cmdArray = append(cmdArray, arg)
+		// 将除了容器名之外的参数当作需要执行的命令处理，参数原样传递，不再拼接成字符串
+		req := &container.ExecRequest{
+			Args: ctx.Args().Tail(),
+			Env:  ctx.StringSlice("e"),
+			Cwd:  ctx.String("w"),
+			User: ctx.String("u"),
 		}
 		// 执行命令
-		ExecContainer(containerName, cmdArray)
+		exitCode, err := ExecContainer(containerName, req, opts)
+		if err != nil {
+			return err
+		}
+		if exitCode != 0 {
+			return cli.NewExitError("", exitCode)
+		}
 		return nil
 	},
 }
 
-var stopCommand = cli.Command{
-	Name:  "stop",
-	Usage: "stop a container",
+var attachCommand = cli.Command{
+	Name:  "attach",
+	Usage: "attach local standard input, output, and error streams to a running container",
+	Flags: []cli.Flag{
+		cli.StringFlag{Name: "detach-keys", Value: DefaultDetachKeys, Usage: "key sequence for detaching from the container"},
+	},
 	Action: func(ctx *cli.Context) error {
 		if len(ctx.Args()) < 1 {
 			return fmt.Errorf("missing container name")
 		}
 		containerName := ctx.Args().Get(0)
-		stopContainer(containerName)
+		detachKeys, err := parseDetachKeys(ctx.String("detach-keys"))
+		if err != nil {
+			return err
+		}
+		info, err := getContainerInfo(containerName)
+		if err != nil {
+			return fmt.Errorf("get container info %s error %v", containerName, err)
+		}
+		if info.Status != container.Running {
+			return fmt.Errorf("container %s is not running", containerName)
+		}
+		conn, err := dialContainer(info.Id)
+		if err != nil {
+			return err
+		}
+		if err = attachStreams(conn, info.Tty, detachKeys); err == errDetached {
+			logrus.Infof("detached from container %s", containerName)
+			return nil
+		}
+		return err
+	},
+}
+
+var inspectCommand = cli.Command{
+	Name:  "inspect",
+	Usage: "display detailed information on containers, networks, images or volumes",
+	Flags: []cli.Flag{
+		cli.StringFlag{Name: "format, f", Usage: "format the output using the given Go template"},
+		cli.StringFlag{Name: "type", Usage: "only inspect objects of the given type: container, network, image or volume"},
+	},
+	Action: func(ctx *cli.Context) error {
+		if len(ctx.Args()) < 1 {
+			return fmt.Errorf("missing object name")
+		}
+		return inspect(ctx.Args(), ctx.String("type"), ctx.String("format"))
+	},
+}
+
+var infoCommand = cli.Command{
+	Name:  "info",
+	Usage: "display the effective configuration and detected kernel features",
+	Flags: []cli.Flag{
+		cli.StringFlag{Name: "format, f", Usage: "format the output using the given Go template"},
+	},
+	Action: func(ctx *cli.Context) error {
+		return showInfo(ctx.GlobalString("config"), ctx.String("format"))
+	},
+}
+
+var stopCommand = cli.Command{
+	Name:  "stop",
+	Usage: "stop one or more containers",
+	Flags: []cli.Flag{
+		cli.StringSliceFlag{Name: "filter", Usage: "stop all containers matching the filter, e.g. label=team=web"},
+	},
+	Action: func(ctx *cli.Context) error {
+		ids, err := selectContainers(ctx.Args(), ctx.StringSlice("filter"))
+		if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			stopContainer(id)
+		}
 		return nil
 	},
 }
 
 var removeCommand = cli.Command{
 	Name:  "rm",
-	Usage: "remove unused containers",
+	Usage: "remove one or more containers",
+	// 支持-fv这样的组合短参数
+	UseShortOptionHandling: true,
+	Flags: []cli.Flag{
+		cli.BoolFlag{Name: "force, f", Usage: "force the removal of a running container (uses SIGKILL)"},
+		cli.BoolFlag{Name: "volumes, v", Usage: "remove anonymous volumes associated with the container"},
+		cli.StringSliceFlag{Name: "filter", Usage: "remove all containers matching the filter, e.g. label=team=web"},
+	},
 	Action: func(ctx *cli.Context) error {
-		if len(ctx.Args()) < 1 {
+		refs := []string(ctx.Args())
+		if len(ctx.StringSlice("filter")) > 0 {
+			ids, err := selectContainers(refs, ctx.StringSlice("filter"))
+			if err != nil {
+				return err
+			}
+			refs = ids
+		} else if len(refs) == 0 {
 			return fmt.Errorf("missing container name")
 		}
-		containerName := ctx.Args().Get(0)
-		removeContainer(containerName)
-		return nil
+		return removeContainers(refs, RemoveOptions{Force: ctx.Bool("force"), Volumes: ctx.Bool("volumes")})
+	},
+}
+
+var containerCommand = cli.Command{
+	Name:  "container",
+	Usage: "manage containers",
+	Subcommands: []cli.Command{
+		{
+			Name:  "prune",
+			Usage: "remove all stopped containers",
+			Flags: []cli.Flag{
+				cli.StringSliceFlag{Name: "filter", Usage: "provide filter values, until=<timestamp> or label=<key>[=<value>]"},
+				cli.BoolFlag{Name: "force, f", Usage: "do not prompt for confirmation"},
+			},
+			Action: func(ctx *cli.Context) error {
+				return pruneContainers(ctx.StringSlice("filter"), ctx.Bool("force"))
+			},
+		},
+	},
+}
+
+var systemCommand = cli.Command{
+	Name:  "system",
+	Usage: "manage cloud-docker",
+	Subcommands: []cli.Command{
+		{
+			Name:  "reconcile",
+			Usage: "repair container state and remove resources left behind by crashed commands",
+			Flags: []cli.Flag{
+				cli.BoolFlag{Name: "dry-run", Usage: "only report what would be repaired or removed"},
+			},
+			Action: func(ctx *cli.Context) error {
+				return reconcile(ctx.Bool("dry-run"))
+			},
+		},
+		{
+			Name:  "df",
+			Usage: "show disk usage of images, containers, logs and volumes",
+			Flags: []cli.Flag{
+				cli.BoolFlag{Name: "verbose, v", Usage: "show detailed information on space usage"},
+			},
+			Action: func(ctx *cli.Context) error {
+				return systemDf(ctx.Bool("verbose"))
+			},
+		},
+		{
+			Name:  "prune",
+			Usage: "remove stopped containers, unused image layers and optionally unused images and volumes",
+			Flags: []cli.Flag{
+				cli.BoolFlag{Name: "all, a", Usage: "remove all unused images, not just their unpacked layers"},
+				cli.BoolFlag{Name: "volumes", Usage: "prune anonymous volumes"},
+				cli.BoolFlag{Name: "force, f", Usage: "do not prompt for confirmation"},
+				cli.StringSliceFlag{Name: "filter", Usage: "provide filter values for containers, until=<timestamp> or label=<key>[=<value>]"},
+			},
+			UseShortOptionHandling: true,
+			Action: func(ctx *cli.Context) error {
+				return systemPrune(ctx.Bool("all"), ctx.Bool("volumes"), ctx.Bool("force"), ctx.StringSlice("filter"))
+			},
+		},
+		{
+			Name:  "check",
+			Usage: "check that the kernel and host provide what containers need",
+			Action: func(ctx *cli.Context) error {
+				return systemCheck(ctx.GlobalString("config"))
+			},
+		},
 	},
 }
 
@@ -182,13 +535,19 @@ var networkCommand = cli.Command{
 					Name:  "subnet",
 					Usage: "subnet cidr",
 				},
+				cli.StringSliceFlag{Name: "label", Usage: "set metadata on the network, key=value"},
+				cli.StringSliceFlag{Name: "label-file", Usage: "read in a line delimited file of labels"},
 			},
 			Action: func(ctx *cli.Context) error {
 				if len(ctx.Args()) < 1 {
 					return fmt.Errorf("Missing network name")
 				}
+				labels, err := parseLabels(ctx.StringSlice("label"), ctx.StringSlice("label-file"))
+				if err != nil {
+					return err
+				}
 				network.Init()
-				err := network.CreateNetwork(ctx.String("driver"), ctx.String("subnet"), ctx.Args()[0])
+				err = network.CreateNetwork(ctx.String("driver"), ctx.String("subnet"), ctx.Args()[0], labels)
 				if err != nil {
 					return fmt.Errorf("create network error: %+v", err)
 				}
@@ -198,9 +557,18 @@ var networkCommand = cli.Command{
 		{
 			Name:  "list",
 			Usage: "list container network",
+			Flags: []cli.Flag{
+				cli.StringSliceFlag{Name: "filter", Usage: "filter output by label, label=<key> or label=<key>=<value>"},
+			},
 			Action: func(ctx *cli.Context) error {
+				filters, err := parseLabelFilters(ctx.StringSlice("filter"))
+				if err != nil {
+					return err
+				}
 				network.Init()
-				network.ListNetwork()
+				network.ListNetwork(func(nw *network.Network) bool {
+					return matchLabels(nw.Labels, filters)
+				})
 				return nil
 			},
 		},
@@ -221,3 +589,114 @@ var networkCommand = cli.Command{
 		},
 	},
 }
+
+var volumeCommand = cli.Command{
+	Name:  "volume",
+	Usage: "manage volumes, host directories mounted into containers with -v",
+	Subcommands: []cli.Command{
+		{
+			Name:  "create",
+			Usage: "create a volume directory and record its metadata",
+			Flags: []cli.Flag{
+				cli.StringSliceFlag{Name: "label", Usage: "set metadata on the volume, key=value"},
+				cli.StringSliceFlag{Name: "label-file", Usage: "read in a line delimited file of labels"},
+			},
+			Action: func(ctx *cli.Context) error {
+				if len(ctx.Args()) < 1 {
+					return fmt.Errorf("missing volume path")
+				}
+				labels, err := parseLabels(ctx.StringSlice("label"), ctx.StringSlice("label-file"))
+				if err != nil {
+					return err
+				}
+				return createVolume(ctx.Args().Get(0), labels)
+			},
+		},
+		{
+			Name:  "ls",
+			Usage: "list volumes",
+			Flags: []cli.Flag{
+				cli.StringSliceFlag{Name: "filter", Usage: "filter output by label, label=<key> or label=<key>=<value>"},
+			},
+			Action: func(ctx *cli.Context) error {
+				filters, err := parseLabelFilters(ctx.StringSlice("filter"))
+				if err != nil {
+					return err
+				}
+				return listVolumes(filters)
+			},
+		},
+		{
+			Name:  "rm",
+			Usage: "remove the metadata of a volume, data in the directory is kept",
+			Action: func(ctx *cli.Context) error {
+				if len(ctx.Args()) < 1 {
+					return fmt.Errorf("missing volume path")
+				}
+				return removeVolume(ctx.Args().Get(0))
+			},
+		},
+	},
+}
+
+var podCommand = cli.Command{
+	Name:  "pod",
+	Usage: "manage pods, groups of containers sharing network, ipc and uts namespaces",
+	Subcommands: []cli.Command{
+		{
+			Name:  "create",
+			Usage: "create a pod",
+			Flags: []cli.Flag{
+				cli.StringFlag{Name: "net", Usage: "pod network"},
+				cli.StringSliceFlag{Name: "p", Usage: "port mapping"},
+			},
+			Action: func(ctx *cli.Context) error {
+				if len(ctx.Args()) < 1 {
+					return fmt.Errorf("missing pod name")
+				}
+				return createPod(ctx.Args().Get(0), ctx.String("net"), ctx.StringSlice("p"))
+			},
+		},
+		{
+			Name:  "ls",
+			Usage: "list pods",
+			Action: func(ctx *cli.Context) error {
+				listPods()
+				return nil
+			},
+		},
+		{
+			Name:  "start",
+			Usage: "start the infra process of a pod",
+			Action: func(ctx *cli.Context) error {
+				if len(ctx.Args()) < 1 {
+					return fmt.Errorf("missing pod name")
+				}
+				return startPod(ctx.Args().Get(0))
+			},
+		},
+		{
+			Name:  "stop",
+			Usage: "stop all containers in a pod and its infra process",
+			Action: func(ctx *cli.Context) error {
+				if len(ctx.Args()) < 1 {
+					return fmt.Errorf("missing pod name")
+				}
+				return stopPod(ctx.Args().Get(0))
+			},
+		},
+		{
+			Name:  "rm",
+			Usage: "remove a pod and all its containers",
+			Flags: []cli.Flag{
+				cli.BoolFlag{Name: "f", Usage: "stop the pod before removing"},
+			},
+			Action: func(ctx *cli.Context) error {
+				if len(ctx.Args()) < 1 {
+					return fmt.Errorf("missing pod name")
+				}
+				return removePod(ctx.Args().Get(0), ctx.Bool("f"))
+			},
+		},
+	},
+}
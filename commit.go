@@ -8,7 +8,12 @@ import (
 )
 
 func commitContainer(containerName, imageName string) {
-	mntUrl := fmt.Sprintf(container.MntUrl, containerName) + "/"
+	info, err := getContainerInfo(containerName)
+	if err != nil {
+		logrus.Errorf("Get container info %s error %v", containerName, err)
+		return
+	}
+	mntUrl := fmt.Sprintf(container.MntUrl, info.Id) + "/"
 	imagTar := container.RootUrl + "/" + imageName + ".tar"
 	fmt.Printf("%s \n", imagTar)
 	if _, err := exec.Command("tar", "-czf", imagTar, "-C", mntUrl, ".").CombinedOutput(); err != nil {
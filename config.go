@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+	"github.com/yunfeiyang1916/cloud-docker/container"
+	"github.com/yunfeiyang1916/cloud-docker/logger"
+)
+
+// 默认的配置文件位置，可以通过--config指定其他文件
+const defaultConfigFile = "/etc/cloud-docker/config.json"
+
+// daemonConfig 配置文件的内容，命令行参数优先于配置文件
+type daemonConfig struct {
+	// 镜像、容器层和数据卷的根目录
+	DataRoot string `json:"data-root,omitempty"`
+	// 运行时状态的根目录
+	ExecRoot string `json:"exec-root,omitempty"`
+	// 日志级别，debug、info、warn或error
+	LogLevel string `json:"log-level,omitempty"`
+	// 日志格式，json或text
+	LogFormat string `json:"log-format,omitempty"`
+	// run命令未指定--net时连接的网络
+	DefaultNetwork string `json:"default-network,omitempty"`
+	// run命令未指定--log-driver时使用的日志驱动
+	LogDriver string `json:"log-driver,omitempty"`
+	// 日志驱动的参数，只在容器使用配置文件中的日志驱动时生效
+	LogOpts map[string]string `json:"log-opts,omitempty"`
+	// 所有容器的资源限制，格式与--ulimit相同，--ulimit指定的同名限制优先
+	DefaultUlimits []string `json:"default-ulimits,omitempty"`
+	// run命令未指定--dns时使用的域名服务器
+	DNS []string `json:"dns,omitempty"`
+	// run命令未指定--storage-driver时使用的存储驱动
+	StorageDriver string `json:"storage-driver,omitempty"`
+}
+
+// 当前生效的配置，在app.Before中加载
+var daemonCfg = &daemonConfig{}
+
+// 读取配置文件，文件不存在时使用默认配置，未知的配置项视为错误，避免拼写错误的配置被静默忽略
+func loadConfig(file string) (*daemonConfig, error) {
+	cfg := &daemonConfig{}
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return cfg, nil
+		}
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.DisallowUnknownFields()
+	if err = decoder.Decode(cfg); err != nil {
+		return nil, fmt.Errorf("parse config file %s error %v", file, err)
+	}
+	if err = cfg.validate(); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %v", file, err)
+	}
+	return cfg, nil
+}
+
+// 提前校验各项默认值，避免等到run时才报错
+func (cfg *daemonConfig) validate() error {
+	if cfg.LogLevel != "" {
+		if _, err := logrus.ParseLevel(cfg.LogLevel); err != nil {
+			return err
+		}
+	}
+	if _, err := logFormatter(cfg.LogFormat); err != nil {
+		return err
+	}
+	if _, err := cfg.logConfig("", false, nil); err != nil {
+		return err
+	}
+	if _, err := container.ParseUlimits(cfg.DefaultUlimits); err != nil {
+		return err
+	}
+	if _, err := container.ParseDNS(cfg.DNS); err != nil {
+		return err
+	}
+	_, err := container.ParseStorageDriver(cfg.StorageDriver)
+	return err
+}
+
+// 按照生效的配置设置日志级别和格式
+func setupLogging(level, format string) error {
+	formatter, err := logFormatter(format)
+	if err != nil {
+		return err
+	}
+	logrus.SetFormatter(formatter)
+	if level != "" {
+		l, err := logrus.ParseLevel(level)
+		if err != nil {
+			return err
+		}
+		logrus.SetLevel(l)
+	}
+	return nil
+}
+
+// 当前是否是在容器的namespace中运行的内部命令，即容器的init进程或exec命令fork出的子进程
+func inContainerNamespace(ctx *cli.Context) bool {
+	return os.Getenv(EnvExecPID) != "" || ctx.Args().First() == initCommand.Name
+}
+
+// 启动内部命令时传递的全局参数，子进程使用相同的配置文件和日志设置
+func (cfg *daemonConfig) selfArgs(configFile string) []string {
+	args := []string{"--config", configFile}
+	if cfg.LogLevel != "" {
+		args = append(args, "--log-level", cfg.LogLevel)
+	}
+	if cfg.LogFormat != "" {
+		args = append(args, "--log-format", cfg.LogFormat)
+	}
+	return args
+}
+
+// 日志格式对应的logrus formatter，默认为json
+func logFormatter(format string) (logrus.Formatter, error) {
+	switch format {
+	case "", "json":
+		// Log as JSON instead of the default ASCII formatter.
+		return &logrus.JSONFormatter{}, nil
+	case "text":
+		return &logrus.TextFormatter{}, nil
+	}
+	return nil, fmt.Errorf("invalid log format %s, expect json or text", format)
+}
+
+// 合并容器的日志配置，driverSet表示命令行中指定了--log-driver
+// 容器使用的日志驱动与配置文件中的相同时，配置文件中的log-opts作为默认值，--log-opt中的同名参数优先
+func (cfg *daemonConfig) logConfig(driver string, driverSet bool, opts []string) (logger.Config, error) {
+	cfgDriver := cfg.LogDriver
+	if cfgDriver == "" {
+		cfgDriver = logger.DefaultDriver
+	}
+	if !driverSet {
+		driver = cfgDriver
+	}
+	if driver == cfgDriver {
+		var defaults []string
+		for key, value := range cfg.LogOpts {
+			defaults = append(defaults, key+"="+value)
+		}
+		sort.Strings(defaults)
+		opts = append(defaults, opts...)
+	}
+	return logger.ParseConfig(driver, opts)
+}
+
+// 合并容器的资源限制，--ulimit中的同名限制覆盖配置文件中的默认值
+func (cfg *daemonConfig) ulimits(opts []string) []string {
+	set := map[string]bool{}
+	for _, opt := range opts {
+		set[strings.SplitN(opt, "=", 2)[0]] = true
+	}
+	var merged []string
+	for _, opt := range cfg.DefaultUlimits {
+		if !set[strings.SplitN(opt, "=", 2)[0]] {
+			merged = append(merged, opt)
+		}
+	}
+	return append(merged, opts...)
+}
+
+// 按照命令行参数、配置文件、默认值的顺序确定运行时状态目录和数据目录
+// 内部命令的工作目录可能是容器的挂载点，因此都转换为绝对路径
+func resolveRoots(cfg *daemonConfig, execRoot, dataRoot string) (string, string, error) {
+	defaultExecRoot, defaultDataRoot := container.DefaultExecRoot, container.DefaultDataRoot
+	// 非特权用户运行时，状态和数据默认放到当前用户自己的目录中
+	if container.IsRootless() {
+		defaultExecRoot, defaultDataRoot = container.RootlessRunRoot(), container.RootlessDataRoot()
+	}
+	var err error
+	if execRoot, err = rootPath(execRoot, cfg.ExecRoot, defaultExecRoot); err != nil {
+		return "", "", err
+	}
+	if dataRoot, err = rootPath(dataRoot, cfg.DataRoot, defaultDataRoot); err != nil {
+		return "", "", err
+	}
+	return execRoot, dataRoot, nil
+}
+
+// 返回第一个非空的路径
+func rootPath(paths ...string) (string, error) {
+	for _, p := range paths {
+		if p != "" {
+			return filepath.Abs(p)
+		}
+	}
+	return "", nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cloud-docker-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cfg, err := loadConfig(filepath.Join(dir, "missing.json"))
+	if err != nil || cfg.DataRoot != "" || cfg.ExecRoot != "" {
+		t.Fatalf("missing config file should use defaults, got %+v %v", cfg, err)
+	}
+
+	file := filepath.Join(dir, "config.json")
+	ioutil.WriteFile(file, []byte(`{"data-root": "/data/cloud-docker", "exec-root": "/run/cd-test"}`), 0644)
+	if cfg, err = loadConfig(file); err != nil {
+		t.Fatal(err)
+	}
+	execRoot, dataRoot, err := resolveRoots(cfg, "", "relative")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if execRoot != "/run/cd-test" {
+		t.Errorf("exec root should come from config file, got %s", execRoot)
+	}
+	if wd, _ := os.Getwd(); dataRoot != filepath.Join(wd, "relative") {
+		t.Errorf("data root flag should override config file and be absolute, got %s", dataRoot)
+	}
+
+	ioutil.WriteFile(file, []byte(`{"data_root": "/data"}`), 0644)
+	if _, err = loadConfig(file); err == nil {
+		t.Errorf("unknown config key should be rejected")
+	}
+}
+
+func TestConfigRunDefaults(t *testing.T) {
+	cfg := &daemonConfig{
+		LogDriver:      "json-file",
+		LogOpts:        map[string]string{"max-size": "10m", "max-file": "3"},
+		DefaultUlimits: []string{"nofile=1024:2048", "nproc=512"},
+	}
+	logConfig, err := cfg.logConfig("json-file", false, []string{"max-file=5"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if logConfig.Config["max-size"] != "10m" || logConfig.Config["max-file"] != "5" {
+		t.Errorf("--log-opt should override config log-opts, got %v", logConfig.Config)
+	}
+	// 命令行指定了其他日志驱动时不使用配置文件中的参数
+	if logConfig, err = cfg.logConfig("none", true, nil); err != nil || len(logConfig.Config) != 0 {
+		t.Errorf("log-opts should only apply to the configured driver, got %v %v", logConfig, err)
+	}
+	ulimits := cfg.ulimits([]string{"nofile=4096"})
+	if strings.Join(ulimits, ",") != "nproc=512,nofile=4096" {
+		t.Errorf("--ulimit should override default ulimits of the same name, got %v", ulimits)
+	}
+}
+
+func TestSelfArgs(t *testing.T) {
+	cfg := &daemonConfig{LogLevel: "debug"}
+	args := cfg.selfArgs("/etc/other.json")
+	if strings.Join(args, " ") != "--config /etc/other.json --log-level debug" {
+		t.Errorf("internal commands should get the config file and effective log settings, got %v", args)
+	}
+}
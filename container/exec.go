@@ -0,0 +1,79 @@
+//go:build linux
+// +build linux
+
+package container
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+)
+
+// ExecRequest exec命令通过管道传给容器内进程的请求
+type ExecRequest struct {
+	// 要执行的命令及参数
+	Args []string `json:"args"`
+	// 环境变量
+	Env []string `json:"env"`
+	// 工作目录，为空时使用容器的根目录
+	Cwd string `json:"cwd"`
+	// 执行命令的用户，格式为 user[:group]，可以是名字或id
+	User string `json:"user"`
+}
+
+// RunContainerExecProcess 在nsenter进入容器的namespace之后执行，此时Go运行时已经在容器内启动
+// 从第4个文件描述符读取请求，切换用户和工作目录后直接execve用户命令，不经过容器内的shell
+func RunContainerExecProcess() error {
+	pipe := os.NewFile(uintptr(3), "pipe")
+	var req ExecRequest
+	if err := json.NewDecoder(pipe).Decode(&req); err != nil {
+		return fmt.Errorf("read exec request error %v", err)
+	}
+	pipe.Close()
+	if len(req.Args) == 0 {
+		return fmt.Errorf("exec request has no command")
+	}
+	if req.Cwd != "" {
+		if err := os.Chdir(req.Cwd); err != nil {
+			return fmt.Errorf("chdir %s error %v", req.Cwd, err)
+		}
+	}
+	// exec.LookPath使用当前进程的PATH，这里要按照请求中的环境变量查找
+	os.Clearenv()
+	for _, env := range req.Env {
+		if kv := strings.SplitN(env, "=", 2); len(kv) == 2 {
+			os.Setenv(kv[0], kv[1])
+		}
+	}
+	path, err := exec.LookPath(req.Args[0])
+	if err != nil {
+		return err
+	}
+	if req.User != "" {
+		if err = switchUser(req.User); err != nil {
+			return err
+		}
+	}
+	return syscall.Exec(path, req.Args, req.Env)
+}
+
+// 按照容器内的/etc/passwd和/etc/group切换用户
+func switchUser(spec string) error {
+	u, err := LookupUser(spec)
+	if err != nil {
+		return err
+	}
+	if err = syscall.Setgroups(u.Groups); err != nil {
+		return fmt.Errorf("setgroups error %v", err)
+	}
+	if err = syscall.Setgid(u.Gid); err != nil {
+		return fmt.Errorf("setgid %d error %v", u.Gid, err)
+	}
+	if err = syscall.Setuid(u.Uid); err != nil {
+		return fmt.Errorf("setuid %d error %v", u.Uid, err)
+	}
+	return nil
+}
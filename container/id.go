@@ -0,0 +1,57 @@
+package container
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+)
+
+const (
+	// IDLength 完整容器id的长度，32字节随机数的十六进制表示
+	IDLength = 64
+	// ShortIDLength 展示时截断的id长度
+	ShortIDLength = 12
+	// NamesDir 容器名到id的索引目录，与容器的状态目录放在一起
+	NamesDir = "names"
+)
+
+var (
+	idPattern   = regexp.MustCompile(`^[0-9a-f]{64}$`)
+	namePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+)
+
+// GenerateID 生成64位十六进制的随机id，使用crypto/rand避免同一时刻创建的容器id冲突
+func GenerateID() string {
+	b := make([]byte, IDLength/2)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("read random bytes error %v", err))
+	}
+	return hex.EncodeToString(b)
+}
+
+// IsValidID 判断是否为完整的容器id
+func IsValidID(id string) bool {
+	return idPattern.MatchString(id)
+}
+
+// ShortID 截断后便于展示的id
+func ShortID(id string) string {
+	if len(id) > ShortIDLength {
+		return id[:ShortIDLength]
+	}
+	return id
+}
+
+// ValidateName 校验容器名和pod名，名字会作为索引文件名或目录名，不能包含路径分隔符
+func ValidateName(name string) error {
+	if !namePattern.MatchString(name) {
+		return fmt.Errorf("invalid name %s, only [a-zA-Z0-9][a-zA-Z0-9_.-] are allowed", name)
+	}
+	return nil
+}
+
+// NameIndexLocation 容器名索引文件，内容为容器id
+func NameIndexLocation(name string) string {
+	return fmt.Sprintf(DefaultInfoLocation, NamesDir) + name
+}
@@ -0,0 +1,29 @@
+package container
+
+import "testing"
+
+func TestGenerateID(t *testing.T) {
+	a, b := GenerateID(), GenerateID()
+	if !IsValidID(a) || !IsValidID(b) {
+		t.Fatalf("invalid id %s %s", a, b)
+	}
+	if a == b {
+		t.Fatalf("duplicate id %s", a)
+	}
+	if ShortID(a) != a[:ShortIDLength] {
+		t.Fatalf("short id %s of %s", ShortID(a), a)
+	}
+}
+
+func TestValidateName(t *testing.T) {
+	for _, name := range []string{"web", "web-1", "a.b_c", "0abc"} {
+		if err := ValidateName(name); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+	for _, name := range []string{"", "-web", "a/b", "..", "a b"} {
+		if ValidateName(name) == nil {
+			t.Errorf("%s should be invalid", name)
+		}
+	}
+}
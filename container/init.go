@@ -9,6 +9,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"runtime"
 	"strings"
 	"syscall"
 
@@ -22,6 +23,8 @@ func RunContainerInitProcess() error {
 	if cmdArray == nil || len(cmdArray) == 0 {
 		return fmt.Errorf("Run container get user command error, cmdArray is nil")
 	}
+	security := readSecurityFromEnv()
+	clearJoinNamespacesEnv()
 
 	setUpMount()
 
@@ -47,6 +50,20 @@ func RunContainerInitProcess() error {
 	//	logrus.Error(err.Error())
 	//	return err
 	//}
+	if err = applyRlimitsFromEnv(); err != nil {
+		logrus.Errorf("apply ulimits error %v", err)
+		return err
+	}
+	// namespace和安全配置是线程级别的，锁定线程保证设置和execve在同一个线程上
+	runtime.LockOSThread()
+	if err = unshareCgroupNamespace(); err != nil {
+		logrus.Errorf("unshare cgroup namespace error %v", err)
+		return err
+	}
+	if err = applySecurity(security); err != nil {
+		logrus.Errorf("apply security opts error %v", err)
+		return err
+	}
 	// 使用下面的系统调用可以使用户进程覆盖掉容器进程，从而使得用户进程的id可以为1
 	if err = syscall.Exec(path, cmdArray[0:], os.Environ()); err != nil {
 		logrus.Error(err.Error())
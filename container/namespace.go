@@ -0,0 +1,205 @@
+//go:build linux
+// +build linux
+
+package container
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	// EnvJoinNamespaces 需要init进程加入的已有namespace，格式为 类型:路径,...，由nsenter在Go运行时启动前setns
+	EnvJoinNamespaces = "cloud_docker_join_ns"
+	// NamespaceHost 与宿主机共享namespace
+	NamespaceHost = "host"
+	// NamespaceContainerPrefix 加入其他容器的namespace，格式为 container:<name>
+	NamespaceContainerPrefix = "container:"
+	// NamespacePodPrefix 加入pod的infra进程持有的namespace，格式为 pod:<name>
+	NamespacePodPrefix = "pod:"
+	// CgroupnsPrivate 容器使用独立的cgroup namespace，这是默认值
+	CgroupnsPrivate = "private"
+	// EnvCgroupNamespace 通知init进程创建cgroup namespace
+	EnvCgroupNamespace = "cloud_docker_cgroupns"
+	// EnvTimeOffset time namespace的时钟偏移，内容即/proc/self/timens_offsets的格式，由nsenter写入
+	EnvTimeOffset = "cloud_docker_time_offset"
+)
+
+// 可以在time namespace中设置偏移的时钟
+var timeOffsetClocks = []string{"monotonic", "boottime"}
+
+// AllNamespaces 容器可能用到的所有namespace类型
+var AllNamespaces = []string{"mnt", "uts", "ipc", "pid", "net", "user", "cgroup", "time"}
+
+// NamespaceSupported 内核是否支持某种namespace
+func NamespaceSupported(nsType string) bool {
+	_, err := os.Stat("/proc/self/ns/" + nsType)
+	return err == nil
+}
+
+// PodNamespaces pod的infra进程持有、由pod内所有容器共享的namespace
+var PodNamespaces = []string{"net", "ipc", "uts"}
+
+// 可以共享的namespace类型和创建时对应的clone标志，mnt namespace每个容器总是独立的
+var namespaceCloneFlags = map[string]uintptr{
+	"net": syscall.CLONE_NEWNET,
+	"pid": syscall.CLONE_NEWPID,
+	"ipc": syscall.CLONE_NEWIPC,
+	"uts": syscall.CLONE_NEWUTS,
+}
+
+// ParseNamespaceMode 校验--net/--pid/--ipc/--uts参数，合法的值为host、container:<name>和pod:<name>
+func ParseNamespaceMode(nsType, mode string) error {
+	if _, ok := namespaceCloneFlags[nsType]; !ok {
+		return fmt.Errorf("namespace %s can not be shared", nsType)
+	}
+	if mode == NamespaceHost {
+		return nil
+	}
+	if strings.HasPrefix(mode, NamespaceContainerPrefix) && len(mode) > len(NamespaceContainerPrefix) {
+		return nil
+	}
+	if strings.HasPrefix(mode, NamespacePodPrefix) && len(mode) > len(NamespacePodPrefix) {
+		// pod的infra进程没有独立的pid namespace
+		if nsType == "pid" {
+			return fmt.Errorf("pod does not hold a pid namespace")
+		}
+		return nil
+	}
+	return fmt.Errorf("invalid %s namespace mode %s, expect host or container:<name>", nsType, mode)
+}
+
+// NamespacePath 进程某个namespace的文件路径
+func NamespacePath(pid, nsType string) string {
+	return fmt.Sprintf("/proc/%s/ns/%s", pid, nsType)
+}
+
+// 计算创建init进程时的clone标志，共享或加入已有namespace的类型不再新建
+func cloneFlags(namespaces map[string]string) uintptr {
+	var flags uintptr = syscall.CLONE_NEWNS
+	for nsType, flag := range namespaceCloneFlags {
+		if _, shared := namespaces[nsType]; !shared {
+			flags |= flag
+		}
+	}
+	return flags
+}
+
+// 把需要加入的namespace转换为传给nsenter的环境变量
+func joinNamespacesEnv(nsPaths map[string]string) []string {
+	if len(nsPaths) == 0 {
+		return nil
+	}
+	var items []string
+	for nsType, path := range nsPaths {
+		items = append(items, nsType+":"+path)
+	}
+	sort.Strings(items)
+	return []string{EnvJoinNamespaces + "=" + strings.Join(items, ",")}
+}
+
+// ParseCgroupns 校验--cgroupns参数，未指定时使用独立的cgroup namespace
+func ParseCgroupns(mode string) (string, error) {
+	switch mode {
+	case "":
+		return CgroupnsPrivate, nil
+	case CgroupnsPrivate, NamespaceHost:
+		return mode, nil
+	}
+	return "", fmt.Errorf("invalid cgroupns mode %s, expect host or private", mode)
+}
+
+// ParseTimeOffsets 解析--time-offset参数，格式为 monotonic=<duration> 或 boottime=<duration>
+func ParseTimeOffsets(opts []string) (map[string]string, error) {
+	if len(opts) == 0 {
+		return nil, nil
+	}
+	offsets := map[string]string{}
+	for _, opt := range opts {
+		kv := strings.SplitN(opt, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid time offset %s, expect clock=duration", opt)
+		}
+		valid := false
+		for _, clock := range timeOffsetClocks {
+			valid = valid || kv[0] == clock
+		}
+		if !valid {
+			return nil, fmt.Errorf("invalid time offset %s, clock must be monotonic or boottime", opt)
+		}
+		if _, err := time.ParseDuration(kv[1]); err != nil {
+			return nil, fmt.Errorf("invalid time offset %s, %v", opt, err)
+		}
+		offsets[kv[0]] = kv[1]
+	}
+	return offsets, nil
+}
+
+// 转换为timens_offsets的格式，每行为 时钟 秒 纳秒，纳秒部分必须在[0, 1e9)之间
+func timeOffsetsEnv(offsets map[string]string) []string {
+	if len(offsets) == 0 {
+		return nil
+	}
+	var lines []string
+	for _, clock := range timeOffsetClocks {
+		value, ok := offsets[clock]
+		if !ok {
+			continue
+		}
+		d, _ := time.ParseDuration(value)
+		secs := int64(d / time.Second)
+		nsecs := int64(d % time.Second)
+		if nsecs < 0 {
+			secs--
+			nsecs += int64(time.Second)
+		}
+		lines = append(lines, fmt.Sprintf("%s %d %d", clock, secs, nsecs))
+	}
+	return []string{EnvTimeOffset + "=" + strings.Join(lines, "\n")}
+}
+
+// InitPid 容器init进程在宿主机上的pid
+// setns进入pid namespace和创建time namespace都只对子进程生效，这两种情况下nsenter会再fork一次，
+// 真正的init进程是cmd启动的进程的子进程
+func InitPid(cmd *exec.Cmd, info *ContainerInfo, nsPaths map[string]string) (int, error) {
+	pid := cmd.Process.Pid
+	if _, ok := nsPaths["pid"]; !ok && len(info.TimeOffsets) == 0 {
+		return pid, nil
+	}
+	childrenFile := fmt.Sprintf("/proc/%d/task/%d/children", pid, pid)
+	for i := 0; i < 100; i++ {
+		content, err := ioutil.ReadFile(childrenFile)
+		if err != nil {
+			return 0, err
+		}
+		if fields := strings.Fields(string(content)); len(fields) > 0 {
+			return strconv.Atoi(fields[0])
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return 0, fmt.Errorf("wait for container init process of %d timeout", pid)
+}
+
+// init进程启动后不再需要这些环境变量，避免泄露到用户进程中
+func clearJoinNamespacesEnv() {
+	os.Unsetenv(EnvJoinNamespaces)
+	os.Unsetenv(EnvTimeOffset)
+}
+
+// 创建cgroup namespace，以当前所在的cgroup作为容器内看到的根cgroup
+// 必须在父进程把init进程加入容器的cgroup之后调用，unshare只对当前线程生效，调用方需要锁定线程
+func unshareCgroupNamespace() error {
+	private := os.Getenv(EnvCgroupNamespace) == "1"
+	os.Unsetenv(EnvCgroupNamespace)
+	if !private {
+		return nil
+	}
+	return syscall.Unshare(syscall.CLONE_NEWCGROUP)
+}
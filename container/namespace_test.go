@@ -0,0 +1,46 @@
+//go:build linux
+// +build linux
+
+package container
+
+import (
+	"syscall"
+	"testing"
+)
+
+func TestParseNamespaceMode(t *testing.T) {
+	for _, c := range []struct {
+		nsType, mode string
+		ok           bool
+	}{
+		{"net", "host", true},
+		{"net", "container:web", true},
+		{"ipc", "pod:api", true},
+		{"pid", "pod:api", false},
+		{"uts", "container:", false},
+		{"mnt", "host", false},
+	} {
+		if err := ParseNamespaceMode(c.nsType, c.mode); (err == nil) != c.ok {
+			t.Errorf("ParseNamespaceMode(%s, %s) error %v", c.nsType, c.mode, err)
+		}
+	}
+	flags := cloneFlags(map[string]string{"net": "host", "pid": "container:web"})
+	if flags&syscall.CLONE_NEWNET != 0 || flags&syscall.CLONE_NEWPID != 0 || flags&syscall.CLONE_NEWUTS == 0 {
+		t.Fatalf("unexpected clone flags %x", flags)
+	}
+}
+
+func TestTimeOffsetsEnv(t *testing.T) {
+	offsets, err := ParseTimeOffsets([]string{"boottime=-1.5s", "monotonic=1h"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := timeOffsetsEnv(offsets)
+	expect := EnvTimeOffset + "=monotonic 3600 0\nboottime -2 500000000"
+	if len(env) != 1 || env[0] != expect {
+		t.Fatalf("expect %q, got %q", expect, env)
+	}
+	if _, err = ParseTimeOffsets([]string{"realtime=1s"}); err == nil {
+		t.Fatal("expect error for realtime clock")
+	}
+}
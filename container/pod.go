@@ -0,0 +1,33 @@
+package container
+
+import "fmt"
+
+// PodsDir pod的状态目录，与容器的状态目录放在一起
+const PodsDir = "pods"
+
+// PodInfo pod信息，pod内的容器共享infra进程持有的网络、ipc和uts namespace
+type PodInfo struct {
+	// pod Id
+	Id string `json:"id"`
+	// pod名
+	Name string `json:"name"`
+	// infra进程在宿主机上的PID
+	InfraPid string `json:"infraPid"`
+	// pod的状态
+	Status string `json:"status"`
+	// pod连接的网络
+	Network string `json:"network,omitempty"`
+	// infra进程连接网络的端点，停止pod时据此释放地址
+	Endpoint *EndpointSettings `json:"endpoint,omitempty"`
+	// 端口映射，pod内的容器共用
+	PortMapping []string `json:"portmapping"`
+	// pod内的容器id
+	Containers []string `json:"containers"`
+	// 创建时间
+	CreatedTime string `json:"createTime"`
+}
+
+// PodInfoLocation pod状态文件所在的目录
+func PodInfoLocation(podName string) string {
+	return fmt.Sprintf(DefaultInfoLocation, PodsDir) + podName + "/"
+}
@@ -10,20 +10,68 @@ import (
 	"syscall"
 
 	"github.com/sirupsen/logrus"
+	"github.com/yunfeiyang1916/cloud-docker/cgroups/subsystems"
+	"github.com/yunfeiyang1916/cloud-docker/logger"
 )
 
 const (
-	Running             = "running"
-	Stop                = "stopped"
-	Exit                = "exited"
+	Created    = "created"
+	Running    = "running"
+	Stop       = "stopped"
+	Exit       = "exited"
+	ConfigName = "config.json"
+	// AttachSocket monitor进程监听的unix socket，attach命令通过它连接容器的标准输入输出
+	AttachSocket = "attach.sock"
+)
+
+const (
+	// DefaultExecRoot 默认的运行时状态目录
+	DefaultExecRoot = "/var/run/cloud-docker"
+	// DefaultDataRoot 默认的数据目录，镜像以<镜像名>.tar的形式放在这个目录下
+	DefaultDataRoot = "/root"
+)
+
+// 运行时状态和镜像、容器层的存放位置，可以通过--exec-root、--data-root或配置文件修改，
+// rootless模式下默认放到当前用户的目录中
+var (
+	ExecRoot            = DefaultExecRoot
+	DataRoot            = DefaultDataRoot
 	DefaultInfoLocation = "/var/run/cloud-docker/%s/"
-	ConfigName          = "config.json"
-	ContainerLogFile    = "container.log"
 	RootUrl             = "/root"
 	MntUrl              = "/root/mnt/%s"
 	WriteLayerUrl       = "/root/writeLayer/%s"
+	// fuse-overlayfs所需的工作目录，只在rootless模式下使用
+	WorkLayerUrl = "/root/workLayer/%s"
+	// 匿名数据卷在宿主机上的目录，以容器id命名
+	VolumesUrl = "/root/volumes/%s"
 )
 
+// SetRoots 设置运行时状态目录和数据目录
+func SetRoots(runRoot, dataRoot string) {
+	ExecRoot = runRoot
+	DataRoot = dataRoot
+	DefaultInfoLocation = runRoot + "/%s/"
+	RootUrl = dataRoot
+	MntUrl = dataRoot + "/mnt/%s"
+	WriteLayerUrl = dataRoot + "/writeLayer/%s"
+	WorkLayerUrl = dataRoot + "/workLayer/%s"
+	VolumesUrl = dataRoot + "/volumes/%s"
+}
+
+// 启动内部命令时除了两个根目录之外还要传递的全局参数
+var selfArgs []string
+
+// SetSelfArgs 设置启动内部命令时传递的其他全局参数，比如配置文件和日志参数
+func SetSelfArgs(args ...string) {
+	selfArgs = args
+}
+
+// SelfCommand 以cloud-docker自身启动内部命令，子进程使用与当前进程相同的运行时状态目录、数据目录、配置文件和日志设置
+func SelfCommand(args ...string) *exec.Cmd {
+	globals := append([]string{"--exec-root", ExecRoot, "--data-root", DataRoot}, selfArgs...)
+	return exec.Command("/proc/self/exe", append(globals, args...)...)
+}
+
 // ContainerInfo 容器信息
 type ContainerInfo struct {
 	// 容器的init进程在宿主机上的 PID
@@ -42,49 +90,187 @@ type ContainerInfo struct {
 	Volume string `json:"volume"`
 	// 端口映射
 	PortMapping []string `json:"portmapping"`
+	// 容器连接的网络
+	Network string `json:"network,omitempty"`
+	// user namespace的uid映射，为空表示未开启uid映射
+	UidMappings []IDMapping `json:"uidMappings,omitempty"`
+	// user namespace的gid映射
+	GidMappings []IDMapping `json:"gidMappings,omitempty"`
+	// 安全配置
+	Security *SecurityConfig `json:"securityOpt,omitempty"`
+	// 资源限制
+	Rlimits []Rlimit `json:"ulimits,omitempty"`
+	// 容器使用的镜像
+	Image string `json:"image"`
+	// 与宿主机共享或加入其他容器的namespace，key为namespace类型，value为host或container:<name>
+	Namespaces map[string]string `json:"namespaces,omitempty"`
+	// 容器所属的pod
+	Pod string `json:"pod,omitempty"`
+	// cgroup namespace的模式，private或host
+	Cgroupns string `json:"cgroupns,omitempty"`
+	// time namespace中的时钟偏移，为空表示不创建time namespace
+	TimeOffsets map[string]string `json:"timeOffsets,omitempty"`
+	// 是否分配了伪终端
+	Tty bool `json:"tty,omitempty"`
+	// 非tty模式下是否保持标准输入打开，为false时标准输入是/dev/null
+	OpenStdin bool `json:"openStdin,omitempty"`
+	// 日志驱动配置
+	LogConfig logger.Config `json:"logConfig"`
+	// 传给容器进程的环境变量
+	Env []string `json:"env,omitempty"`
+	// cgroup资源限制
+	Resources *subsystems.ResourceConfig `json:"resources,omitempty"`
+	// 容器在所连接网络中的端点，未连接网络时为空
+	Endpoint *EndpointSettings `json:"endpoint,omitempty"`
+	// 容器进程的退出码，容器停止后有效
+	ExitCode int `json:"exitCode"`
+	// 容器的标签
+	Labels map[string]string `json:"labels,omitempty"`
+	// 数据卷是否为匿名数据卷，即-v只指定了容器内目录，宿主机目录由cloud-docker创建
+	AnonymousVolume bool `json:"anonymousVolume,omitempty"`
+	// 挂载容器根文件系统使用的存储驱动
+	StorageDriver string `json:"storageDriver,omitempty"`
+	// 容器使用的域名服务器，为空时使用镜像中的resolv.conf
+	Dns []string `json:"dns,omitempty"`
 }
 
-// NewParentProcess 构建父进程，实际上是克隆了一个当前进程处理做环境隔离，执行init命令
-func NewParentProcess(tty bool, containerName, volume, imageName string, envSlice []string) (*exec.Cmd, *os.File) {
-	readPipe, writePipe, err := NewPipe()
-	if err != nil {
-		logrus.Errorf("New pipe error %v", err)
-		return nil, nil
+// EndpointSettings 容器的网络端点，由网络驱动连接容器时填写
+type EndpointSettings struct {
+	// 端点id，即 容器id-网络名
+	EndpointID string `json:"endpointId"`
+	// 容器在网络中的地址
+	IPAddress string `json:"ipAddress,omitempty"`
+	// 网段的前缀长度
+	IPPrefixLen int `json:"ipPrefixLen,omitempty"`
+	// 容器的默认网关
+	Gateway string `json:"gateway,omitempty"`
+	// 容器内网卡的MAC地址
+	MacAddress string `json:"macAddress,omitempty"`
+}
+
+// LogInfo 日志驱动需要的容器信息
+func (info *ContainerInfo) LogInfo() logger.Info {
+	return logger.Info{ContainerName: info.Name, LogDir: fmt.Sprintf(DefaultInfoLocation, info.Id)}
+}
+
+// Userns 容器的uid/gid映射配置，未开启时返回nil
+func (info *ContainerInfo) Userns() *UsernsConfig {
+	if len(info.UidMappings) == 0 {
+		return nil
 	}
-	// 克隆自己，执行init命令
-	cmd := exec.Command("/proc/self/exe", "init")
-	// 下面的 clone 参数就是去 fork 出来一个新进程，并且使用了 namespace 隔离新创建的进程和外部环境
-	cmd.SysProcAttr = &syscall.SysProcAttr{
-		Cloneflags: syscall.CLONE_NEWUTS | syscall.CLONE_NEWPID | syscall.CLONE_NEWNS |
-			syscall.CLONE_NEWNET | syscall.CLONE_NEWIPC,
+	return &UsernsConfig{UidMappings: info.UidMappings, GidMappings: info.GidMappings}
+}
+
+// ContainerIO 容器进程的标准输入输出在宿主机一侧的文件，由monitor进程持有
+type ContainerIO struct {
+	// tty模式下的伪终端，master端同时用于输入和输出
+	Pty *Pty
+	// 非tty模式下标准输入管道的写端，未开启-i时为nil
+	Stdin *os.File
+	// 非tty模式下标准输出和标准错误管道的读端
+	Stdout *os.File
+	Stderr *os.File
+	// 子进程一侧的文件，子进程启动后父进程需要关闭
+	childFiles []*os.File
+}
+
+// CloseChildSide 子进程启动后关闭父进程中子进程一侧的文件，容器进程退出后monitor才能读到EOF
+func (cio *ContainerIO) CloseChildSide() {
+	for _, f := range cio.childFiles {
+		f.Close()
 	}
-	// 是否需要设置交互式终端，将当前进程的输入输出导入到标准输入输出上
+}
+
+// 为容器进程创建标准输入输出，tty模式使用伪终端，否则标准输出和标准错误使用管道
+// 标准输入只在openStdin为true时使用管道，否则为/dev/null，读取标准输入的命令会立即读到EOF
+func newContainerIO(cmd *exec.Cmd, tty, openStdin bool) (*ContainerIO, error) {
+	cio := &ContainerIO{}
 	if tty {
-		cmd.Stdin = os.Stdin
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-	} else {
-		// 生成容器日志
-		dirPath := fmt.Sprintf(DefaultInfoLocation, containerName)
-		if err = os.MkdirAll(dirPath, 0622); err != nil {
-			logrus.Errorf("NewParentProcess mkdir %s error %v", dirPath, err)
-			return nil, nil
+		pty, err := NewPty()
+		if err != nil {
+			return nil, err
 		}
-		stdLogFilePath := dirPath + ContainerLogFile
-		stdLogFile, err := os.Create(stdLogFilePath)
+		pty.SetCtty(cmd)
+		cio.Pty = pty
+		cio.childFiles = []*os.File{pty.Slave}
+		return cio, nil
+	}
+	if openStdin {
+		stdinRead, stdinWrite, err := os.Pipe()
 		if err != nil {
-			logrus.Errorf("NewParentProcess create file %s error %v", stdLogFilePath, err)
-			return nil, nil
+			return nil, err
 		}
-		cmd.Stdout = stdLogFile
+		cmd.Stdin, cio.Stdin = stdinRead, stdinWrite
+		cio.childFiles = append(cio.childFiles, stdinRead)
+	}
+	stdoutRead, stdoutWrite, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	stderrRead, stderrWrite, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	cmd.Stdout, cmd.Stderr = stdoutWrite, stderrWrite
+	cio.Stdout, cio.Stderr = stdoutRead, stderrRead
+	cio.childFiles = append(cio.childFiles, stdoutWrite, stderrWrite)
+	return cio, nil
+}
+
+// NewParentProcess 构建父进程，实际上是克隆了一个当前进程处理做环境隔离，执行init命令
+// nsPaths是需要加入的已有namespace，key为namespace类型，value为/proc/<pid>/ns/<type>
+// readPipe是init进程读取用户命令的管道，写端留在run命令中，等容器的资源都设置好之后再发送命令
+// 返回的ContainerIO是容器标准输入输出在宿主机一侧的文件，由启动容器的monitor进程持有
+// 容器的根文件系统没有准备好时返回错误，不会启动容器
+func NewParentProcess(tty bool, info *ContainerInfo, envSlice []string, nsPaths map[string]string, readPipe *os.File) (*exec.Cmd, *ContainerIO, error) {
+	// 克隆自己，执行init命令
+	cmd := SelfCommand("init")
+	// 下面的 clone 参数就是去 fork 出来一个新进程，并且使用了 namespace 隔离新创建的进程和外部环境
+	// 与宿主机共享或加入其他容器的namespace不再新建
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Cloneflags: cloneFlags(info.Namespaces),
+	}
+	// 开启uid映射后再创建user namespace，容器内的root映射为宿主机上的普通用户
+	userns := info.Userns()
+	if userns != nil {
+		cmd.SysProcAttr.Cloneflags |= syscall.CLONE_NEWUSER
+		cmd.SysProcAttr.UidMappings = toSysProcIDMap(userns.UidMappings)
+		cmd.SysProcAttr.GidMappings = toSysProcIDMap(userns.GidMappings)
+		// 父进程是宿主机上的root时允许容器内调用setgroups，非特权用户不允许写入gid_map之前开启setgroups
+		cmd.SysProcAttr.GidMappingsEnableSetgroups = !IsRootless()
+	}
+	// 容器进程的标准输入输出不再直接继承当前进程的，而是交给monitor进程持有，
+	// 这样run命令退出后仍然可以通过attach命令连接，输出也由monitor写入容器日志
+	dirPath := fmt.Sprintf(DefaultInfoLocation, info.Id)
+	if err := os.MkdirAll(dirPath, 0622); err != nil {
+		return nil, nil, fmt.Errorf("mkdir %s error %v", dirPath, err)
+	}
+	if err := NewWorkSpace(info.Volume, info.Image, info.Id, info.StorageDriverName(), userns); err != nil {
+		return nil, nil, err
+	}
+	cio, err := newContainerIO(cmd, tty, info.OpenStdin)
+	if err != nil {
+		return nil, nil, fmt.Errorf("create stdio error %v", err)
 	}
 	// 将读管道文件附带给子进程，子进程的第4个文件描述符就是该管道文件
 	cmd.ExtraFiles = []*os.File{readPipe}
 	cmd.Env = append(os.Environ(), envSlice...)
-	NewWorkSpace(volume, imageName, containerName)
-	cmd.Dir = fmt.Sprintf(MntUrl, containerName)
+	// 安全配置和资源限制通过环境变量交给init进程，在exec用户命令前生效
+	cmd.Env = append(cmd.Env, info.Security.Env()...)
+	cmd.Env = append(cmd.Env, RlimitsEnv(info.Rlimits)...)
+	cmd.Env = append(cmd.Env, joinNamespacesEnv(nsPaths)...)
+	cmd.Env = append(cmd.Env, timeOffsetsEnv(info.TimeOffsets)...)
+	if info.Cgroupns == CgroupnsPrivate {
+		cmd.Env = append(cmd.Env, EnvCgroupNamespace+"=1")
+	}
+	cmd.Dir = fmt.Sprintf(MntUrl, info.Id)
+	if len(info.Dns) > 0 {
+		if err = writeResolvConf(cmd.Dir, info.Dns); err != nil {
+			logrus.Errorf("write resolv.conf error %v", err)
+		}
+	}
 	// cmd.Dir = "/root/busybox"
-	return cmd, writePipe
+	return cmd, cio, nil
 }
 
 // NewPipe 创建匿名管道，供init进程与run进程通信
@@ -0,0 +1,117 @@
+//go:build linux
+// +build linux
+
+package container
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// Pty 伪终端，master端留在宿主机上转发输入输出，slave端作为容器内进程的控制终端
+type Pty struct {
+	Master *os.File
+	Slave  *os.File
+}
+
+// NewPty 创建一对伪终端
+func NewPty() (*Pty, error) {
+	// 以非阻塞方式打开，Go运行时才会通过epoll读写master端，读写可以设置超时
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|unix.O_NOCTTY|unix.O_CLOEXEC|unix.O_NONBLOCK, 0)
+	if err != nil {
+		return nil, err
+	}
+	// 解锁slave端，相当于unlockpt
+	if err = unix.IoctlSetPointerInt(int(master.Fd()), unix.TIOCSPTLCK, 0); err != nil {
+		master.Close()
+		return nil, fmt.Errorf("unlockpt error %v", err)
+	}
+	// 获取slave端的编号，相当于ptsname
+	n, err := unix.IoctlGetInt(int(master.Fd()), unix.TIOCGPTN)
+	if err != nil {
+		master.Close()
+		return nil, fmt.Errorf("ptsname error %v", err)
+	}
+	slave, err := os.OpenFile(fmt.Sprintf("/dev/pts/%d", n), os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		master.Close()
+		return nil, err
+	}
+	return &Pty{Master: master, Slave: slave}, nil
+}
+
+// SetCtty 把slave端设置为子进程的标准输入输出，并在新的会话中作为它的控制终端，这样才有作业控制
+func (p *Pty) SetCtty(cmd *exec.Cmd) {
+	cmd.Stdin = p.Slave
+	cmd.Stdout = p.Slave
+	cmd.Stderr = p.Slave
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setsid = true
+	cmd.SysProcAttr.Setctty = true
+	// Ctty是子进程中的文件描述符，即标准输入
+	cmd.SysProcAttr.Ctty = 0
+}
+
+// CloseSlave 子进程启动后父进程不再需要slave端，关闭后子进程全部退出时读取master端会返回EIO
+func (p *Pty) CloseSlave() {
+	p.Slave.Close()
+}
+
+// IsTerminal 文件是否是终端
+func IsTerminal(f *os.File) bool {
+	_, err := unix.IoctlGetTermios(int(f.Fd()), unix.TCGETS)
+	return err == nil
+}
+
+// SetRawTerminal 把终端设置为raw模式，按键直接交给容器内的进程处理，返回恢复终端设置的函数
+func SetRawTerminal(f *os.File) (func(), error) {
+	fd := int(f.Fd())
+	oldState, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+	if err != nil {
+		return nil, err
+	}
+	// 与cfmakeraw的设置相同
+	raw := *oldState
+	raw.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP | unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON
+	raw.Oflag &^= unix.OPOST
+	raw.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+	raw.Cflag &^= unix.CSIZE | unix.PARENB
+	raw.Cflag |= unix.CS8
+	raw.Cc[unix.VMIN] = 1
+	raw.Cc[unix.VTIME] = 0
+	if err = unix.IoctlSetTermios(fd, unix.TCSETS, &raw); err != nil {
+		return nil, err
+	}
+	return func() {
+		unix.IoctlSetTermios(fd, unix.TCSETS, oldState)
+	}, nil
+}
+
+// ResizePty 把终端from的窗口大小同步到伪终端上
+func ResizePty(pty, from *os.File) error {
+	ws, err := unix.IoctlGetWinsize(int(from.Fd()), unix.TIOCGWINSZ)
+	if err != nil {
+		return err
+	}
+	return unix.IoctlSetWinsize(int(pty.Fd()), unix.TIOCSWINSZ, ws)
+}
+
+// TerminalSize 终端的窗口大小
+func TerminalSize(f *os.File) (uint16, uint16, error) {
+	ws, err := unix.IoctlGetWinsize(int(f.Fd()), unix.TIOCGWINSZ)
+	if err != nil {
+		return 0, 0, err
+	}
+	return ws.Row, ws.Col, nil
+}
+
+// SetPtySize 设置伪终端的窗口大小，attach的客户端不在本机终端上时使用
+func SetPtySize(pty *os.File, rows, cols uint16) error {
+	return unix.IoctlSetWinsize(int(pty.Fd()), unix.TIOCSWINSZ, &unix.Winsize{Row: rows, Col: cols})
+}
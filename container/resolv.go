@@ -0,0 +1,38 @@
+package container
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"strings"
+)
+
+// ParseDNS 校验--dns参数，每个值都必须是ip地址
+func ParseDNS(servers []string) ([]string, error) {
+	for _, server := range servers {
+		if net.ParseIP(server) == nil {
+			return nil, fmt.Errorf("invalid dns server %s, expect an ip address", server)
+		}
+	}
+	return servers, nil
+}
+
+// 在容器的根文件系统中写入resolv.conf，文件写入容器层，不会修改镜像
+func writeResolvConf(rootfs string, servers []string) error {
+	etc := path.Join(rootfs, "etc")
+	if err := os.MkdirAll(etc, 0755); err != nil {
+		return err
+	}
+	var lines []string
+	for _, server := range servers {
+		lines = append(lines, "nameserver "+server)
+	}
+	// 镜像中的resolv.conf可能是符号链接，先删除，避免写到链接指向的宿主机文件
+	resolvConf := path.Join(etc, "resolv.conf")
+	if err := os.Remove(resolvConf); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return ioutil.WriteFile(resolvConf, []byte(strings.Join(lines, "\n")+"\n"), 0644)
+}
@@ -0,0 +1,116 @@
+//go:build linux
+// +build linux
+
+package container
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// EnvRlimits 通过环境变量把资源限制传给init进程和nsenter，格式为 资源编号=soft:hard,...
+const EnvRlimits = "cloud_docker_rlimits"
+
+// rlimitUnlimited 对应RLIM_INFINITY
+const rlimitUnlimited = ^uint64(0)
+
+// 支持的资源限制名称，对应setrlimit的RLIMIT_*
+var rlimitTypes = map[string]int{
+	"cpu":        0,  // RLIMIT_CPU
+	"fsize":      1,  // RLIMIT_FSIZE
+	"data":       2,  // RLIMIT_DATA
+	"stack":      3,  // RLIMIT_STACK
+	"core":       4,  // RLIMIT_CORE
+	"rss":        5,  // RLIMIT_RSS
+	"nproc":      6,  // RLIMIT_NPROC
+	"nofile":     7,  // RLIMIT_NOFILE
+	"memlock":    8,  // RLIMIT_MEMLOCK
+	"as":         9,  // RLIMIT_AS
+	"locks":      10, // RLIMIT_LOCKS
+	"sigpending": 11, // RLIMIT_SIGPENDING
+	"msgqueue":   12, // RLIMIT_MSGQUEUE
+	"nice":       13, // RLIMIT_NICE
+	"rtprio":     14, // RLIMIT_RTPRIO
+	"rttime":     15, // RLIMIT_RTTIME
+}
+
+// Rlimit 容器进程的资源限制，由--ulimit指定
+type Rlimit struct {
+	Name string `json:"name"`
+	Soft uint64 `json:"soft"`
+	Hard uint64 `json:"hard"`
+}
+
+// ParseUlimits 解析--ulimit参数，格式为 name=soft[:hard]，unlimited或-1表示不限制
+func ParseUlimits(ulimits []string) ([]Rlimit, error) {
+	var rlimits []Rlimit
+	for _, u := range ulimits {
+		kv := strings.SplitN(u, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid ulimit %s, expect name=soft:hard", u)
+		}
+		if _, ok := rlimitTypes[kv[0]]; !ok {
+			return nil, fmt.Errorf("invalid ulimit %s, unknown type %s", u, kv[0])
+		}
+		limits := strings.SplitN(kv[1], ":", 2)
+		soft, err := parseRlimitValue(limits[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid ulimit %s, %v", u, err)
+		}
+		hard := soft
+		if len(limits) == 2 {
+			if hard, err = parseRlimitValue(limits[1]); err != nil {
+				return nil, fmt.Errorf("invalid ulimit %s, %v", u, err)
+			}
+		}
+		if soft > hard {
+			return nil, fmt.Errorf("invalid ulimit %s, soft limit is greater than hard limit", u)
+		}
+		rlimits = append(rlimits, Rlimit{Name: kv[0], Soft: soft, Hard: hard})
+	}
+	return rlimits, nil
+}
+
+func parseRlimitValue(value string) (uint64, error) {
+	if value == "unlimited" || value == "-1" {
+		return rlimitUnlimited, nil
+	}
+	return strconv.ParseUint(value, 10, 64)
+}
+
+// RlimitsEnv 转换为传给init进程或nsenter的环境变量，使用资源编号便于nsenter中的C代码解析
+func RlimitsEnv(rlimits []Rlimit) []string {
+	if len(rlimits) == 0 {
+		return nil
+	}
+	var items []string
+	for _, r := range rlimits {
+		items = append(items, fmt.Sprintf("%d=%d:%d", rlimitTypes[r.Name], r.Soft, r.Hard))
+	}
+	sort.Strings(items)
+	return []string{EnvRlimits + "=" + strings.Join(items, ",")}
+}
+
+// 在exec用户进程之前设置资源限制，读取后删除环境变量
+func applyRlimitsFromEnv() error {
+	value := os.Getenv(EnvRlimits)
+	os.Unsetenv(EnvRlimits)
+	if value == "" {
+		return nil
+	}
+	for _, item := range strings.Split(value, ",") {
+		var resource int
+		var rlimit syscall.Rlimit
+		if _, err := fmt.Sscanf(item, "%d=%d:%d", &resource, &rlimit.Cur, &rlimit.Max); err != nil {
+			return fmt.Errorf("parse rlimit %s error %v", item, err)
+		}
+		if err := syscall.Setrlimit(resource, &rlimit); err != nil {
+			return fmt.Errorf("setrlimit %s error %v", item, err)
+		}
+	}
+	return nil
+}
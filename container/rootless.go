@@ -0,0 +1,45 @@
+//go:build linux
+// +build linux
+
+package container
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// IsRootless 是否以非特权用户运行
+func IsRootless() bool {
+	return os.Geteuid() != 0
+}
+
+// RootlessRunRoot rootless模式下的运行时状态目录，位于$XDG_RUNTIME_DIR中
+func RootlessRunRoot() string {
+	runtimeDir := os.Getenv("XDG_RUNTIME_DIR")
+	if runtimeDir == "" {
+		runtimeDir = fmt.Sprintf("/run/user/%d", os.Geteuid())
+	}
+	return filepath.Join(runtimeDir, "cloud-docker")
+}
+
+// RootlessDataRoot rootless模式下镜像和容器层的存放目录，默认为~/.local/share/cloud-docker
+func RootlessDataRoot() string {
+	dataHome := os.Getenv("XDG_DATA_HOME")
+	if dataHome == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			home = fmt.Sprintf("/tmp/cloud-docker-%d", os.Geteuid())
+		}
+		dataHome = filepath.Join(home, ".local", "share")
+	}
+	return filepath.Join(dataHome, "cloud-docker")
+}
+
+// RootlessUserns rootless模式下的uid映射，非特权用户只能把自己映射为容器内的root
+func RootlessUserns() *UsernsConfig {
+	return &UsernsConfig{
+		UidMappings: []IDMapping{{ContainerID: 0, HostID: os.Getuid(), Size: 1}},
+		GidMappings: []IDMapping{{ContainerID: 0, HostID: os.Getgid(), Size: 1}},
+	}
+}
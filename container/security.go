@@ -0,0 +1,146 @@
+//go:build linux
+// +build linux
+
+package container
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"syscall"
+)
+
+const (
+	// EnvNoNewPrivs 通过环境变量把安全配置传给init进程和nsenter
+	EnvNoNewPrivs = "cloud_docker_no_new_privs"
+	// EnvAppArmor apparmor配置文件名
+	EnvAppArmor = "cloud_docker_apparmor"
+	// EnvSelinuxLabel selinux的进程标签
+	EnvSelinuxLabel = "cloud_docker_selinux_label"
+
+	// prctl的PR_SET_NO_NEW_PRIVS选项
+	prSetNoNewPrivs = 38
+	// 未指定时使用的selinux标签
+	defaultSelinuxLabel = "system_u:system_r:container_t:s0"
+)
+
+// SecurityConfig 容器的安全配置，由--security-opt指定
+type SecurityConfig struct {
+	// 禁止通过setuid等方式获取新的权限
+	NoNewPrivileges bool `json:"noNewPrivileges"`
+	// apparmor配置文件名
+	AppArmorProfile string `json:"apparmorProfile,omitempty"`
+	// selinux进程标签
+	SelinuxLabel string `json:"selinuxLabel,omitempty"`
+}
+
+// ParseSecurityOpts 解析--security-opt参数，支持以下格式
+// no-new-privileges[=true|false]
+// apparmor=<profile>
+// label=user:<user> label=role:<role> label=type:<type> label=level:<level> label=disable
+func ParseSecurityOpts(opts []string) (*SecurityConfig, error) {
+	if len(opts) == 0 {
+		return nil, nil
+	}
+	config := &SecurityConfig{}
+	// selinux标签由 user:role:type:level 四部分组成，label参数只替换其中一部分
+	label := strings.SplitN(defaultSelinuxLabel, ":", 4)
+	labelSet := false
+	for _, opt := range opts {
+		kv := strings.SplitN(opt, "=", 2)
+		switch kv[0] {
+		case "no-new-privileges":
+			config.NoNewPrivileges = len(kv) == 1 || kv[1] == "true"
+		case "apparmor":
+			if len(kv) != 2 || kv[1] == "" {
+				return nil, fmt.Errorf("invalid security opt %s, apparmor profile is required", opt)
+			}
+			config.AppArmorProfile = kv[1]
+		case "label":
+			if len(kv) != 2 {
+				return nil, fmt.Errorf("invalid security opt %s", opt)
+			}
+			if kv[1] == "disable" {
+				labelSet = false
+				label = nil
+				continue
+			}
+			part := strings.SplitN(kv[1], ":", 2)
+			if len(part) != 2 || label == nil {
+				return nil, fmt.Errorf("invalid security opt %s", opt)
+			}
+			idx := map[string]int{"user": 0, "role": 1, "type": 2, "level": 3}
+			i, ok := idx[part[0]]
+			if !ok {
+				return nil, fmt.Errorf("invalid security opt %s, unknown label field %s", opt, part[0])
+			}
+			label[i] = part[1]
+			labelSet = true
+		default:
+			return nil, fmt.Errorf("unknown security opt %s", opt)
+		}
+	}
+	if labelSet {
+		config.SelinuxLabel = strings.Join(label, ":")
+	}
+	return config, nil
+}
+
+// Env 转换为传给init进程或nsenter的环境变量
+func (c *SecurityConfig) Env() []string {
+	if c == nil {
+		return nil
+	}
+	var envs []string
+	if c.NoNewPrivileges {
+		envs = append(envs, EnvNoNewPrivs+"=1")
+	}
+	if c.AppArmorProfile != "" {
+		envs = append(envs, EnvAppArmor+"="+c.AppArmorProfile)
+	}
+	if c.SelinuxLabel != "" {
+		envs = append(envs, EnvSelinuxLabel+"="+c.SelinuxLabel)
+	}
+	return envs
+}
+
+// 从环境变量中读取安全配置，读取后删除这些变量，避免泄露到用户进程中
+func readSecurityFromEnv() *SecurityConfig {
+	config := &SecurityConfig{
+		NoNewPrivileges: os.Getenv(EnvNoNewPrivs) == "1",
+		AppArmorProfile: os.Getenv(EnvAppArmor),
+		SelinuxLabel:    os.Getenv(EnvSelinuxLabel),
+	}
+	os.Unsetenv(EnvNoNewPrivs)
+	os.Unsetenv(EnvAppArmor)
+	os.Unsetenv(EnvSelinuxLabel)
+	return config
+}
+
+// 在exec用户进程之前应用安全配置
+// 这些属性都是线程级别的，调用方需要先runtime.LockOSThread，保证在同一个线程上调用execve
+func applySecurity(config *SecurityConfig) error {
+	if config.AppArmorProfile != "" {
+		// 新内核中apparmor有单独的属性目录，旧内核则与其他LSM共用/proc/self/attr/exec
+		attr := "/proc/thread-self/attr/apparmor/exec"
+		if _, err := os.Stat(attr); err != nil {
+			attr = "/proc/thread-self/attr/exec"
+		}
+		if err := ioutil.WriteFile(attr, []byte("exec "+config.AppArmorProfile), 0); err != nil {
+			return fmt.Errorf("set apparmor profile %s error %v", config.AppArmorProfile, err)
+		}
+	}
+	if config.SelinuxLabel != "" {
+		if err := ioutil.WriteFile("/proc/thread-self/attr/exec", []byte(config.SelinuxLabel), 0); err != nil {
+			return fmt.Errorf("set selinux label %s error %v", config.SelinuxLabel, err)
+		}
+	}
+	// no_new_privs最后设置，它会影响后续的标签切换
+	if config.NoNewPrivileges {
+		if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prSetNoNewPrivs, 1, 0); errno != 0 {
+			return fmt.Errorf("set no_new_privs error %v", errno)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,22 @@
+//go:build linux
+// +build linux
+
+package container
+
+import "testing"
+
+func TestParseSecurityOpts(t *testing.T) {
+	config, err := ParseSecurityOpts([]string{"no-new-privileges", "apparmor=docker-default", "label=type:svirt_lxc_net_t", "label=level:s0:c1,c2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !config.NoNewPrivileges || config.AppArmorProfile != "docker-default" {
+		t.Fatalf("unexpected config %+v", config)
+	}
+	if config.SelinuxLabel != "system_u:system_r:svirt_lxc_net_t:s0:c1,c2" {
+		t.Fatalf("unexpected selinux label %s", config.SelinuxLabel)
+	}
+	if _, err = ParseSecurityOpts([]string{"seccomp=unconfined"}); err == nil {
+		t.Fatal("expect error for unknown security opt")
+	}
+}
@@ -0,0 +1,98 @@
+//go:build linux
+// +build linux
+
+package container
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+const (
+	// StorageDriverAufs 使用aufs联合挂载镜像层和容器层，特权模式下的默认驱动
+	StorageDriverAufs = "aufs"
+	// StorageDriverOverlay 使用内核的overlayfs
+	StorageDriverOverlay = "overlay"
+	// StorageDriverFuseOverlay 使用用户态的fuse-overlayfs，rootless模式下只能使用这个驱动
+	StorageDriverFuseOverlay = "fuse-overlayfs"
+)
+
+// StorageDrivers 支持的存储驱动
+var StorageDrivers = []string{StorageDriverAufs, StorageDriverOverlay, StorageDriverFuseOverlay}
+
+// DefaultStorageDriver 未指定存储驱动时使用的驱动
+func DefaultStorageDriver() string {
+	if IsRootless() {
+		return StorageDriverFuseOverlay
+	}
+	return StorageDriverAufs
+}
+
+// ParseStorageDriver 校验--storage-driver参数，为空时使用默认驱动
+func ParseStorageDriver(driver string) (string, error) {
+	if driver == "" {
+		return DefaultStorageDriver(), nil
+	}
+	valid := false
+	for _, d := range StorageDrivers {
+		valid = valid || d == driver
+	}
+	if !valid {
+		return "", fmt.Errorf("unknown storage driver %s, expect one of %s", driver, strings.Join(StorageDrivers, ", "))
+	}
+	// 非特权用户无法在宿主机上挂载aufs和overlayfs
+	if IsRootless() && driver != StorageDriverFuseOverlay {
+		return "", fmt.Errorf("storage driver %s is not supported in rootless mode", driver)
+	}
+	return driver, nil
+}
+
+// StorageDriverSupported 宿主机是否支持这个存储驱动，内核文件系统以/proc/filesystems为准
+func StorageDriverSupported(driver string) bool {
+	switch driver {
+	case StorageDriverAufs, StorageDriverOverlay:
+		return FilesystemSupported(driver)
+	case StorageDriverFuseOverlay:
+		_, err := exec.LookPath("fuse-overlayfs")
+		return err == nil && FilesystemSupported("fuse")
+	}
+	return false
+}
+
+// FilesystemSupported 内核是否支持某种文件系统
+func FilesystemSupported(fs string) bool {
+	f, err := os.Open("/proc/filesystems")
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// 格式为 [nodev]\t文件系统名
+		fields := strings.Fields(scanner.Text())
+		if len(fields) > 0 && fields[len(fields)-1] == fs {
+			return true
+		}
+	}
+	return false
+}
+
+// UnmountCommand 卸载存储驱动挂载的根文件系统的命令，fuse-overlayfs的挂载需要通过fusermount卸载
+// 按容器创建时记录的驱动选择，而不是当前是否是rootless模式
+func UnmountCommand(driver, path string) *exec.Cmd {
+	if driver == StorageDriverFuseOverlay {
+		return exec.Command("fusermount", "-u", path)
+	}
+	return exec.Command("umount", path)
+}
+
+// StorageDriverName 容器使用的存储驱动，旧版本创建的容器没有记录，使用当时的默认驱动
+func (info *ContainerInfo) StorageDriverName() string {
+	if info.StorageDriver != "" {
+		return info.StorageDriver
+	}
+	return DefaultStorageDriver()
+}
@@ -0,0 +1,118 @@
+package container
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// 容器内的用户数据库，需要在进入容器的mnt namespace之后读取
+var (
+	passwdFile = "/etc/passwd"
+	groupFile  = "/etc/group"
+)
+
+// ExecUser 解析后的用户
+type ExecUser struct {
+	Uid    int
+	Gid    int
+	Groups []int
+}
+
+// LookupUser 解析 user[:group] 格式的用户，user和group可以是名字也可以是id
+// 只给出数字uid且容器内没有对应用户时，gid与uid相同
+func LookupUser(spec string) (*ExecUser, error) {
+	parts := strings.SplitN(spec, ":", 2)
+	u := &ExecUser{}
+	userName := ""
+	found := false
+	if err := scanColonFile(passwdFile, func(fields []string) bool {
+		// 格式为 name:password:uid:gid:gecos:home:shell
+		if len(fields) < 4 || (fields[0] != parts[0] && fields[2] != parts[0]) {
+			return false
+		}
+		u.Uid, _ = strconv.Atoi(fields[2])
+		u.Gid, _ = strconv.Atoi(fields[3])
+		userName = fields[0]
+		found = true
+		return true
+	}); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if !found {
+		uid, err := strconv.Atoi(parts[0])
+		if err != nil {
+			return nil, fmt.Errorf("no such user %s in container", parts[0])
+		}
+		u.Uid, u.Gid = uid, uid
+	}
+	if len(parts) == 2 {
+		gid, err := lookupGroup(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		u.Gid = gid
+	}
+	u.Groups = []int{u.Gid}
+	// 补充用户所在的附加组
+	if userName != "" {
+		scanColonFile(groupFile, func(fields []string) bool {
+			// 格式为 name:password:gid:member1,member2
+			if len(fields) < 4 {
+				return false
+			}
+			for _, member := range strings.Split(fields[3], ",") {
+				if member == userName {
+					if gid, err := strconv.Atoi(fields[2]); err == nil && gid != u.Gid {
+						u.Groups = append(u.Groups, gid)
+					}
+				}
+			}
+			return false
+		})
+	}
+	return u, nil
+}
+
+func lookupGroup(group string) (int, error) {
+	gid := -1
+	if err := scanColonFile(groupFile, func(fields []string) bool {
+		if len(fields) < 3 || (fields[0] != group && fields[2] != group) {
+			return false
+		}
+		gid, _ = strconv.Atoi(fields[2])
+		return true
+	}); err != nil && !os.IsNotExist(err) {
+		return -1, err
+	}
+	if gid >= 0 {
+		return gid, nil
+	}
+	id, err := strconv.Atoi(group)
+	if err != nil {
+		return -1, fmt.Errorf("no such group %s in container", group)
+	}
+	return id, nil
+}
+
+// 逐行解析以冒号分隔的文件，fn返回true时停止
+func scanColonFile(file string, fn func(fields []string) bool) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if fn(strings.Split(line, ":")) {
+			break
+		}
+	}
+	return scanner.Err()
+}
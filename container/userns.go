@@ -0,0 +1,173 @@
+//go:build linux
+// +build linux
+
+package container
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// SubUidFile 从属uid范围的配置文件
+	SubUidFile = "/etc/subuid"
+	// SubGidFile 从属gid范围的配置文件
+	SubGidFile = "/etc/subgid"
+	// DefaultRemapUser --userns-remap=default时使用的用户
+	DefaultRemapUser = "cloud-docker"
+)
+
+// IDMapping 容器内外uid/gid的映射关系，对应/proc/[pid]/uid_map中的一行
+type IDMapping struct {
+	// 容器内的起始id
+	ContainerID int `json:"containerId"`
+	// 宿主机上的起始id
+	HostID int `json:"hostId"`
+	// 映射的id个数
+	Size int `json:"size"`
+}
+
+// UsernsConfig user namespace的uid/gid映射配置
+type UsernsConfig struct {
+	UidMappings []IDMapping
+	GidMappings []IDMapping
+}
+
+// NewUsernsRemap 根据--userns-remap参数从/etc/subuid和/etc/subgid中读取映射范围
+// remap的格式为 user[:group]，default表示使用cloud-docker用户
+func NewUsernsRemap(remap string) (*UsernsConfig, error) {
+	if remap == "" {
+		return nil, nil
+	}
+	if remap == "default" {
+		remap = DefaultRemapUser
+	}
+	parts := strings.SplitN(remap, ":", 2)
+	userName, groupName := parts[0], parts[0]
+	if len(parts) == 2 && parts[1] != "" {
+		groupName = parts[1]
+	}
+	// 从属id文件中既可以写用户名也可以写uid，所以两种都需要匹配
+	uid, gid := userName, groupName
+	if u, err := user.Lookup(userName); err == nil {
+		uid = u.Uid
+	}
+	if g, err := user.LookupGroup(groupName); err == nil {
+		gid = g.Gid
+	}
+	uidMaps, err := readSubIDRanges(SubUidFile, userName, uid)
+	if err != nil {
+		return nil, err
+	}
+	gidMaps, err := readSubIDRanges(SubGidFile, groupName, gid)
+	if err != nil {
+		return nil, err
+	}
+	return &UsernsConfig{UidMappings: uidMaps, GidMappings: gidMaps}, nil
+}
+
+// 读取从属id文件中属于name的所有范围，依次映射为容器内从0开始的连续id
+func readSubIDRanges(file, name, id string) ([]IDMapping, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var maps []IDMapping
+	containerID := 0
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// 每一行的格式为 name:start:count
+		fields := strings.Split(strings.TrimSpace(scanner.Text()), ":")
+		if len(fields) != 3 || (fields[0] != name && fields[0] != id) {
+			continue
+		}
+		start, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("parse %s start %s error %v", file, fields[1], err)
+		}
+		size, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("parse %s count %s error %v", file, fields[2], err)
+		}
+		maps = append(maps, IDMapping{ContainerID: containerID, HostID: start, Size: size})
+		containerID += size
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(maps) == 0 {
+		return nil, fmt.Errorf("no subordinate id range for %s in %s", name, file)
+	}
+	return maps, nil
+}
+
+// 将容器内的id换算成宿主机上的id
+func toHostID(maps []IDMapping, containerID int) (int, error) {
+	for _, m := range maps {
+		if containerID >= m.ContainerID && containerID < m.ContainerID+m.Size {
+			return m.HostID + containerID - m.ContainerID, nil
+		}
+	}
+	return -1, fmt.Errorf("container id %d is not mapped", containerID)
+}
+
+// RootPair 容器内root用户在宿主机上对应的uid和gid
+func (u *UsernsConfig) RootPair() (int, int) {
+	uid, _ := toHostID(u.UidMappings, 0)
+	gid, _ := toHostID(u.GidMappings, 0)
+	return uid, gid
+}
+
+// 转换成exec.Cmd所需要的映射格式
+func toSysProcIDMap(maps []IDMapping) []syscall.SysProcIDMap {
+	var sysMaps []syscall.SysProcIDMap
+	for _, m := range maps {
+		sysMaps = append(sysMaps, syscall.SysProcIDMap{ContainerID: m.ContainerID, HostID: m.HostID, Size: m.Size})
+	}
+	return sysMaps
+}
+
+// ShiftOwnership 把目录下所有文件的属主从容器内的id平移到宿主机上映射后的id
+// 这样容器内的root才能像操作自己的文件一样操作镜像层
+func ShiftOwnership(dir string, u *UsernsConfig) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		stat, ok := info.Sys().(*syscall.Stat_t)
+		if !ok {
+			return nil
+		}
+		uid, err := toHostID(u.UidMappings, int(stat.Uid))
+		if err != nil {
+			logrus.Warnf("shift owner of %s: %v", path, err)
+			return nil
+		}
+		gid, err := toHostID(u.GidMappings, int(stat.Gid))
+		if err != nil {
+			logrus.Warnf("shift group of %s: %v", path, err)
+			return nil
+		}
+		// 使用Lchown，避免跟随符号链接修改到宿主机上的文件
+		return os.Lchown(path, uid, gid)
+	})
+}
+
+// ImageLayerUrl 镜像只读层的解压目录，开启uid映射时每种映射单独解压一份，避免修改共享的镜像层
+// rootless模式下镜像层本来就属于当前用户，不需要单独解压
+func ImageLayerUrl(imageName string, u *UsernsConfig) string {
+	if u == nil || IsRootless() {
+		return RootUrl + "/" + imageName
+	}
+	uid, gid := u.RootPair()
+	return fmt.Sprintf("%s/%d.%d/%s", RootUrl, uid, gid, imageName)
+}
@@ -0,0 +1,76 @@
+//go:build linux
+// +build linux
+
+package container
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestReadSubIDRanges(t *testing.T) {
+	f, err := ioutil.TempFile("", "subuid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("other:200000:65536\ncloud-docker:100000:1000\n1000:300000:65536\ncloud-docker:500000:65536\n")
+	f.Close()
+
+	maps, err := readSubIDRanges(f.Name(), "cloud-docker", "999")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(maps) != 2 {
+		t.Fatalf("expect 2 ranges, got %v", maps)
+	}
+	if maps[1].ContainerID != 1000 || maps[1].HostID != 500000 {
+		t.Fatalf("second range should continue after the first one, got %v", maps[1])
+	}
+	if id, _ := toHostID(maps, 1001); id != 500001 {
+		t.Fatalf("expect 500001, got %d", id)
+	}
+	if _, err = readSubIDRanges(f.Name(), "nobody", "65534"); err == nil {
+		t.Fatal("expect error for user without ranges")
+	}
+}
+
+func TestCreateReadOnlyLayerIsAtomic(t *testing.T) {
+	root, err := ioutil.TempDir("", "layer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	SetRoots(root+"/exec", root)
+	defer SetRoots(DefaultExecRoot, DefaultDataRoot)
+
+	// 镜像不存在时解压失败，不能留下空的镜像层被之后的容器复用
+	if err = CreateReadOnlyLayer("missing", nil); err == nil {
+		t.Fatal("expect error for missing image")
+	}
+	if exist, _ := PathExists(ImageLayerUrl("missing", nil)); exist {
+		t.Fatal("failed extraction should not leave the layer dir")
+	}
+
+	src := root + "/src"
+	os.MkdirAll(src, 0755)
+	ioutil.WriteFile(src+"/hello", []byte("hi"), 0644)
+	if out, err := exec.Command("tar", "-cf", root+"/img.tar", "-C", src, ".").CombinedOutput(); err != nil {
+		t.Fatalf("tar error %v: %s", err, out)
+	}
+	if err = CreateReadOnlyLayer("img", nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(ImageLayerUrl("img", nil) + "/hello"); err != nil {
+		t.Fatal(err)
+	}
+	files, _ := ioutil.ReadDir(root)
+	for _, f := range files {
+		if strings.HasPrefix(f.Name(), ".") {
+			t.Fatalf("temp dir %s is left behind", f.Name())
+		}
+	}
+}
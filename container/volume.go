@@ -3,137 +3,252 @@ package container
 import (
 	"fmt"
 	"github.com/sirupsen/logrus"
+	"io/ioutil"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
+	"syscall"
 )
 
-// NewWorkSpace Create a AUFS filesystem as container root workspace
-func NewWorkSpace(volume, imageName, containerName string) {
-	CreateReadOnlyLayer(imageName)
-	CreateWriteLayer(containerName)
-	CreateMountPoint(containerName, imageName)
+// NewWorkSpace 使用存储驱动driver联合挂载镜像层和容器层，作为容器的根文件系统
+// 任何一步失败都返回错误，容器不能在不完整的根文件系统上启动，已经创建的部分由DeleteWorkSpace清理
+func NewWorkSpace(volume, imageName, containerID, driver string, userns *UsernsConfig) error {
+	if err := CreateReadOnlyLayer(imageName, userns); err != nil {
+		return fmt.Errorf("create image layer %s error %v", imageName, err)
+	}
+	if err := CreateWriteLayer(containerID, userns); err != nil {
+		return fmt.Errorf("create write layer error %v", err)
+	}
+	if err := CreateMountPoint(containerID, imageName, driver, userns); err != nil {
+		return fmt.Errorf("mount rootfs error %v", err)
+	}
 	// 根据volume判断是否执行挂载数据卷操作
-	if volume != "" {
-		volumeUrls := volumeUrlExtract(volume)
-		length := len(volumeUrls)
-		if length == 2 && volumeUrls[0] != "" && volumeUrls[1] != "" {
-			MountVolume(volumeUrls, containerName)
-			logrus.Infof("%q", volumeUrls)
-		} else {
-			logrus.Infof("数据卷参数不正确")
-		}
+	if volume == "" {
+		return nil
+	}
+	if IsRootless() {
+		// 非特权用户无法在宿主机上挂载aufs
+		return fmt.Errorf("volume is not supported in rootless mode")
+	}
+	volumeUrls := VolumeUrls(volume)
+	if volumeUrls == nil {
+		return fmt.Errorf("invalid volume %s, expect <host dir>:<container dir>", volume)
 	}
+	if err := MountVolume(volumeUrls, containerID, driver); err != nil {
+		return fmt.Errorf("mount volume %s error %v", volume, err)
+	}
+	return nil
 }
 
-// MountVolume 挂载数据卷
-func MountVolume(volumeUrls []string, containerName string) error {
+// MountVolume 挂载数据卷，aufs驱动下用只有一层的aufs挂载，其他驱动使用bind mount
+func MountVolume(volumeUrls []string, containerID, driver string) error {
 	// 创建宿主机目录
 	parentUrl := volumeUrls[0]
 	if err := os.Mkdir(parentUrl, 0777); err != nil {
 		logrus.Infof("Mkdir parent dir %s error.%v", parentUrl, err)
 	}
 	// 在容器文件系统里创建挂载点
-	mntUrl := fmt.Sprintf(MntUrl, containerName)
+	mntUrl := fmt.Sprintf(MntUrl, containerID)
 	containerVolumeUrl := mntUrl + "/" + volumeUrls[1]
 	if err := os.Mkdir(containerVolumeUrl, 0777); err != nil {
 		logrus.Infof("Mkdir container dir %s error.%v", containerVolumeUrl, err)
 	}
 	// 把宿主机文件目录挂载到容器挂载点
-	dirs := "dirs=" + parentUrl
-	if _, err := exec.Command("mount", "-t", "aufs", "-o", dirs, "none", containerVolumeUrl).CombinedOutput(); err != nil {
+	cmd := exec.Command("mount", "--bind", parentUrl, containerVolumeUrl)
+	if VolumeMountType(driver) == "aufs" {
+		cmd = exec.Command("mount", "-t", "aufs", "-o", "dirs="+parentUrl, "none", containerVolumeUrl)
+	}
+	if _, err := cmd.CombinedOutput(); err != nil {
 		logrus.Errorf("Mount volume failed.%v", err)
 		return err
 	}
 	return nil
 }
 
-// 解析volume字符串
-func volumeUrlExtract(volume string) []string {
-	return strings.Split(volume, ":")
+// VolumeUrls 把<宿主机目录>:<容器内目录>形式的volume参数拆成两部分，格式不正确时返回nil
+func VolumeUrls(volume string) []string {
+	parts := strings.Split(volume, ":")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil
+	}
+	return parts
+}
+
+// VolumeMountType 数据卷在driver存储驱动的容器中的挂载方式，aufs驱动下用只有一层的aufs挂载，其他驱动使用bind mount
+func VolumeMountType(driver string) string {
+	if driver == StorageDriverAufs {
+		return "aufs"
+	}
+	return "bind"
 }
 
 // CreateReadOnlyLayer 将busybox.tar解压到busybox目录下,作为容器的只读层
-func CreateReadOnlyLayer(imageName string) error {
-	unTarFolderUrl := RootUrl + "/" + imageName + "/"
+// 先解压到同一目录下的临时目录，平移属主之后再重命名，中途失败不会留下只完成了一半的镜像层
+func CreateReadOnlyLayer(imageName string, userns *UsernsConfig) error {
+	unTarFolderUrl := ImageLayerUrl(imageName, userns)
 	imageUrl := RootUrl + "/" + imageName + ".tar"
 	exist, err := PathExists(unTarFolderUrl)
 	if err != nil {
 		logrus.Infof("Fail to judge whether dir %s exists. %v", unTarFolderUrl, err)
 		return err
 	}
-	if !exist {
-		if err = os.Mkdir(unTarFolderUrl, 0777); err != nil {
-			logrus.Errorf("Mkdir dir %s error. %v", unTarFolderUrl, err)
+	if exist {
+		return nil
+	}
+	parent := filepath.Dir(unTarFolderUrl)
+	if err = os.MkdirAll(parent, 0777); err != nil {
+		logrus.Errorf("Mkdir dir %s error. %v", parent, err)
+		return err
+	}
+	tmpUrl, err := ioutil.TempDir(parent, "."+imageName+"-")
+	if err != nil {
+		logrus.Errorf("Create temp dir in %s error. %v", parent, err)
+		return err
+	}
+	defer os.RemoveAll(tmpUrl)
+	if err = os.Chmod(tmpUrl, 0777); err != nil {
+		return err
+	}
+	if _, err = exec.Command("tar", "-xvf", imageUrl, "-C", tmpUrl).CombinedOutput(); err != nil {
+		logrus.Errorf("Untar dir %s error %v", tmpUrl, err)
+		return err
+	}
+	// 开启uid映射时，解压出来的文件属主需要平移到映射后的id上
+	if userns != nil && !IsRootless() {
+		if err = ShiftOwnership(tmpUrl, userns); err != nil {
+			logrus.Errorf("Shift ownership of %s error %v", tmpUrl, err)
 			return err
 		}
-		if _, err = exec.Command("tar", "-xvf", imageUrl, "-C", unTarFolderUrl).CombinedOutput(); err != nil {
-			logrus.Errorf("Untar dir %s error %v", unTarFolderUrl, err)
-			return err
+	}
+	if err = os.Rename(tmpUrl, unTarFolderUrl); err != nil {
+		// 同时启动的其他容器已经解压好了同一个镜像层
+		if exist, _ = PathExists(unTarFolderUrl); exist {
+			return nil
 		}
+		logrus.Errorf("Rename %s to %s error %v", tmpUrl, unTarFolderUrl, err)
+		return err
 	}
 	return nil
 }
 
 // CreateWriteLayer 创建一个名为writeLayer的文件夹作为容器唯一的可写层
-func CreateWriteLayer(containerName string) {
-	writeURL := fmt.Sprintf(WriteLayerUrl, containerName)
+func CreateWriteLayer(containerID string, userns *UsernsConfig) error {
+	writeURL := fmt.Sprintf(WriteLayerUrl, containerID)
 	if err := os.MkdirAll(writeURL, 0777); err != nil {
 		logrus.Errorf("Mkdir dir %s error. %v", writeURL, err)
+		return err
+	}
+	// 可写层属于容器内的root
+	if userns != nil {
+		uid, gid := userns.RootPair()
+		if err := os.Chown(writeURL, uid, gid); err != nil {
+			logrus.Errorf("Chown dir %s error. %v", writeURL, err)
+			return err
+		}
 	}
+	return nil
 }
 
-func CreateMountPoint(containerName, imageName string) error {
+func CreateMountPoint(containerID, imageName, driver string, userns *UsernsConfig) error {
 	// 创建mnt文件夹作为挂载点
-	mntUrl := fmt.Sprintf(MntUrl, containerName)
+	mntUrl := fmt.Sprintf(MntUrl, containerID)
 	if err := os.MkdirAll(mntUrl, 0777); err != nil {
 		logrus.Errorf("Mkdir dir %s error. %v", mntUrl, err)
+		return err
+	}
+	tmpWriteLayer := fmt.Sprintf(WriteLayerUrl, containerID)
+	tmpImageLocation := ImageLayerUrl(imageName, userns)
+	switch driver {
+	case StorageDriverFuseOverlay:
+		return createFuseOverlayMountPoint(containerID, tmpImageLocation, tmpWriteLayer, mntUrl)
+	case StorageDriverOverlay:
+		if err := createOverlayMountPoint(containerID, tmpImageLocation, tmpWriteLayer, mntUrl); err != nil {
+			return err
+		}
+	default:
+		dirs := "dirs=" + tmpWriteLayer + ":" + tmpImageLocation
+		if _, err := exec.Command("mount", "-t", "aufs", "-o", dirs, "none", mntUrl).CombinedOutput(); err != nil {
+			logrus.Errorf("run command for creating mount point failed %v", err)
+			return err
+		}
+	}
+	if userns != nil {
+		uid, gid := userns.RootPair()
+		if err := os.Chown(mntUrl, uid, gid); err != nil {
+			logrus.Errorf("Chown dir %s error. %v", mntUrl, err)
+			return err
+		}
+	}
+	return nil
+}
+
+// 使用内核的overlayfs联合挂载，overlayfs需要一个与容器层在同一文件系统上的工作目录
+func createOverlayMountPoint(containerID, lowerDir, upperDir, mntUrl string) error {
+	workUrl := fmt.Sprintf(WorkLayerUrl, containerID)
+	if err := os.MkdirAll(workUrl, 0777); err != nil {
+		logrus.Errorf("Mkdir dir %s error. %v", workUrl, err)
+		return err
 	}
-	tmpWriteLayer := fmt.Sprintf(WriteLayerUrl, containerName)
-	tmpImageLocation := RootUrl + "/" + imageName
-	dirs := "dirs=" + tmpWriteLayer + ":" + tmpImageLocation
-	if _, err := exec.Command("mount", "-t", "aufs", "-o", dirs, "none", mntUrl).CombinedOutput(); err != nil {
-		logrus.Errorf("run command for creating mount point failed %v", err)
+	opts := fmt.Sprintf("lowerdir=%s,upperdir=%s,workdir=%s", lowerDir, upperDir, workUrl)
+	if out, err := exec.Command("mount", "-t", "overlay", "overlay", "-o", opts, mntUrl).CombinedOutput(); err != nil {
+		logrus.Errorf("overlay mount %s failed %v: %s", mntUrl, err, out)
 		return err
 	}
 	return nil
 }
 
-// DeleteWorkSpace Delete the AUFS filesystem while container exit
-func DeleteWorkSpace(volume, containerName string) {
+// 非特权用户无法挂载aufs，使用fuse-overlayfs在用户态完成联合挂载
+func createFuseOverlayMountPoint(containerID, lowerDir, upperDir, mntUrl string) error {
+	workUrl := fmt.Sprintf(WorkLayerUrl, containerID)
+	if err := os.MkdirAll(workUrl, 0777); err != nil {
+		logrus.Errorf("Mkdir dir %s error. %v", workUrl, err)
+		return err
+	}
+	opts := fmt.Sprintf("lowerdir=%s,upperdir=%s,workdir=%s", lowerDir, upperDir, workUrl)
+	if out, err := exec.Command("fuse-overlayfs", "-o", opts, mntUrl).CombinedOutput(); err != nil {
+		logrus.Errorf("fuse-overlayfs mount %s failed %v: %s", mntUrl, err, out)
+		return err
+	}
+	return nil
+}
+
+// DeleteWorkSpace 容器删除时卸载并删除它的文件系统，driver为容器创建时使用的存储驱动
+func DeleteWorkSpace(volume, containerID, driver string) {
 	if volume != "" {
-		volumeUrls := volumeUrlExtract(volume)
-		length := len(volumeUrls)
-		if length == 2 && volumeUrls[0] != "" && volumeUrls[1] != "" {
-			DeleteMountPointWithVolume(volumeUrls, containerName)
+		if volumeUrls := VolumeUrls(volume); volumeUrls != nil {
+			DeleteMountPointWithVolume(volumeUrls, containerID, driver)
 		} else {
-			DeleteMountPoint(containerName)
+			DeleteMountPoint(containerID, driver)
 		}
 	} else {
-		DeleteMountPoint(containerName)
+		DeleteMountPoint(containerID, driver)
 	}
-	DeleteWriteLayer(containerName)
+	DeleteWriteLayer(containerID)
 }
 
-func DeleteMountPointWithVolume(volumeUrls []string, containerName string) {
-	mntUrl := fmt.Sprintf(MntUrl, containerName)
+func DeleteMountPointWithVolume(volumeUrls []string, containerID, driver string) {
+	mntUrl := fmt.Sprintf(MntUrl, containerID)
 	// 卸载容器里volume挂载点的文件系统
 	containerUrl := mntUrl + "/" + volumeUrls[1]
 	if _, err := exec.Command("umount", containerUrl).CombinedOutput(); err != nil {
 		logrus.Errorf("umount volume failed.%v", err)
 	}
 	// 卸载整个容器文件系统的挂载点
-	DeleteMountPoint(containerName)
+	DeleteMountPoint(containerID, driver)
 }
 
-func DeleteMountPoint(containerName string) error {
-	mntUrl := fmt.Sprintf(MntUrl, containerName)
-	cmd := exec.Command("umount", mntUrl)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	if err := cmd.Run(); err != nil {
-		logrus.Errorf("%v", err)
-		return err
+func DeleteMountPoint(containerID, driver string) error {
+	mntUrl := fmt.Sprintf(MntUrl, containerID)
+	// 创建容器失败时挂载点可能还没有挂载，直接删除目录
+	if isMountPoint(mntUrl) {
+		cmd := UnmountCommand(driver, mntUrl)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			logrus.Errorf("%v", err)
+			return err
+		}
 	}
 	if err := os.RemoveAll(mntUrl); err != nil {
 		logrus.Errorf("Remove dir %s error %v", mntUrl, err)
@@ -142,11 +257,27 @@ func DeleteMountPoint(containerName string) error {
 	return nil
 }
 
-func DeleteWriteLayer(containerName string) {
-	writeURL := fmt.Sprintf(WriteLayerUrl, containerName)
+func DeleteWriteLayer(containerID string) {
+	writeURL := fmt.Sprintf(WriteLayerUrl, containerID)
 	if err := os.RemoveAll(writeURL); err != nil {
 		logrus.Errorf("Remove dir %s error %v", writeURL, err)
 	}
+	workURL := fmt.Sprintf(WorkLayerUrl, containerID)
+	if err := os.RemoveAll(workURL); err != nil {
+		logrus.Errorf("Remove dir %s error %v", workURL, err)
+	}
+}
+
+// 联合挂载的根文件系统与所在目录不在同一个设备上
+func isMountPoint(path string) bool {
+	var stat, parent syscall.Stat_t
+	if err := syscall.Stat(path, &stat); err != nil {
+		return false
+	}
+	if err := syscall.Stat(filepath.Dir(path), &parent); err != nil {
+		return false
+	}
+	return stat.Dev != parent.Dev
 }
 
 func PathExists(path string) (bool, error) {
@@ -0,0 +1,427 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/yunfeiyang1916/cloud-docker/container"
+	"github.com/yunfeiyang1916/cloud-docker/logger"
+)
+
+// 开启uid映射时镜像按映射解压到<uid>.<gid>目录下
+var idMapDirPattern = regexp.MustCompile(`^\d+\.\d+$`)
+
+// 镜像的磁盘占用
+type imageUsage struct {
+	Name string
+	// 镜像tar包的大小，只属于这个镜像
+	UniqueSize int64
+	// 解压出的只读层的大小，由使用这个镜像的所有容器共享，可以随时从tar包重新解压
+	SharedSize int64
+	// 使用这个镜像的容器数量
+	Containers int
+}
+
+func (img *imageUsage) Size() int64 {
+	return img.UniqueSize + img.SharedSize
+}
+
+// 容器的磁盘占用
+type containerUsage struct {
+	ID     string
+	Name   string
+	Image  string
+	Status string
+	// 容器层的大小
+	Size int64
+	// 日志文件的大小
+	LogSize int64
+}
+
+// 数据卷的磁盘占用
+type volumeUsage struct {
+	// 宿主机目录
+	Name string
+	Size int64
+	// 使用这个数据卷的容器数量
+	Containers int
+	// 是否有运行中的容器在使用
+	Running bool
+	// 匿名数据卷由cloud-docker创建，只属于一个容器
+	Anonymous bool
+}
+
+// Reclaimable 没有运行中的容器使用的匿名数据卷，删除容器或prune --volumes时会被删除
+// 用户创建的数据卷只会删除元数据，不计入可回收的空间
+func (v *volumeUsage) Reclaimable() bool {
+	return v.Anonymous && !v.Running
+}
+
+type diskUsage struct {
+	Images     []*imageUsage
+	Containers []*containerUsage
+	Volumes    []*volumeUsage
+}
+
+// 统计镜像、容器层、日志和数据卷占用的磁盘空间，容器的挂载点是联合挂载后的视图，不重复统计
+func collectDiskUsage() *diskUsage {
+	infos := allContainers()
+	du := &diskUsage{}
+	used := map[string]int{}
+	for _, info := range infos {
+		used[info.Image]++
+	}
+	for _, name := range imageNames() {
+		img := &imageUsage{Name: name, Containers: used[name]}
+		if stat, err := os.Stat(imageTar(name)); err == nil {
+			img.UniqueSize = stat.Size()
+		}
+		for _, dir := range imageLayerDirs(name) {
+			img.SharedSize += dirSize(dir)
+		}
+		du.Images = append(du.Images, img)
+	}
+
+	volumes := map[string]*volumeUsage{}
+	var sources []string
+	addVolume := func(source string) *volumeUsage {
+		if v, ok := volumes[source]; ok {
+			return v
+		}
+		v := &volumeUsage{Name: source}
+		volumes[source] = v
+		sources = append(sources, source)
+		return v
+	}
+	if meta, err := loadVolumes(); err == nil {
+		for source := range meta {
+			addVolume(source)
+		}
+	}
+	for _, info := range infos {
+		du.Containers = append(du.Containers, &containerUsage{
+			ID:      info.Id,
+			Name:    info.Name,
+			Image:   info.Image,
+			Status:  info.Status,
+			Size:    containerLayerSize(info.Id),
+			LogSize: logSize(info),
+		})
+		if parts := container.VolumeUrls(info.Volume); parts != nil {
+			v := addVolume(parts[0])
+			v.Containers++
+			v.Running = v.Running || info.Status == container.Running
+			v.Anonymous = v.Anonymous || info.AnonymousVolume
+		}
+	}
+	// 容器已经删除但目录还在的匿名数据卷
+	for _, source := range anonymousVolumeDirs() {
+		addVolume(source).Anonymous = true
+	}
+	for _, source := range sources {
+		volumes[source].Size = dirSize(source)
+		du.Volumes = append(du.Volumes, volumes[source])
+	}
+	return du
+}
+
+func imageTar(name string) string {
+	return container.RootUrl + "/" + name + ".tar"
+}
+
+// 镜像解压出的所有只读层目录
+func imageLayerDirs(name string) []string {
+	var dirs []string
+	if dir := container.ImageLayerUrl(name, nil); exists(dir) {
+		dirs = append(dirs, dir)
+	}
+	matches, _ := filepath.Glob(filepath.Join(container.RootUrl, "*", name))
+	for _, dir := range matches {
+		if idMapDirPattern.MatchString(filepath.Base(filepath.Dir(dir))) {
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+// 容器层和overlay的工作目录
+func containerLayerSize(id string) int64 {
+	return dirSize(fmt.Sprintf(container.WriteLayerUrl, id)) + dirSize(fmt.Sprintf(container.WorkLayerUrl, id))
+}
+
+func logSize(info *container.ContainerInfo) int64 {
+	var size int64
+	for _, file := range logger.LogFiles(info.LogInfo()) {
+		if stat, err := os.Stat(file); err == nil {
+			size += stat.Size()
+		}
+	}
+	return size
+}
+
+// 没有被挂载、也不是刚刚创建的文件或目录才可以删除
+// 解压镜像时会保留tar包中的修改时间，所以按改名、修改权限时都会更新的ctime计算宽限期
+func prunable(path string, now time.Time) bool {
+	var stat syscall.Stat_t
+	if err := syscall.Stat(path, &stat); err != nil {
+		return os.IsNotExist(err)
+	}
+	return now.Sub(time.Unix(stat.Ctim.Unix())) > reconcileGracePeriod && !usedByMount(path)
+}
+
+func allPrunable(paths []string, now time.Time) bool {
+	for _, path := range paths {
+		if !prunable(path, now) {
+			return false
+		}
+	}
+	return true
+}
+
+// 数据目录中以容器id命名的匿名数据卷目录
+func anonymousVolumeDirs() []string {
+	parent := filepath.Dir(container.VolumesUrl)
+	files, err := ioutil.ReadDir(parent)
+	if err != nil {
+		return nil
+	}
+	var dirs []string
+	for _, file := range files {
+		if file.IsDir() && container.IsValidID(file.Name()) {
+			dirs = append(dirs, filepath.Join(parent, file.Name()))
+		}
+	}
+	return dirs
+}
+
+// 目录中所有文件的大小之和，不跟随符号链接
+func dirSize(dir string) int64 {
+	var size int64
+	filepath.Walk(dir, func(_ string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
+
+// system df的汇总，可回收的空间为没有被使用的镜像、已停止容器的容器层和日志，以及不再被运行中容器使用的匿名数据卷
+func writeDiskUsage(w io.Writer, du *diskUsage) {
+	tw := tabwriter.NewWriter(w, 12, 1, 3, ' ', 0)
+	fmt.Fprint(tw, "TYPE\tTOTAL\tACTIVE\tSIZE\tRECLAIMABLE\n")
+	var total, active int
+	var size, reclaimable int64
+	for _, img := range du.Images {
+		size += img.Size()
+		if img.Containers > 0 {
+			active++
+		} else {
+			reclaimable += img.Size()
+		}
+	}
+	fmt.Fprintf(tw, "Images\t%d\t%d\t%s\t%s\n", len(du.Images), active, humanSize(size), reclaimableSize(reclaimable, size))
+
+	var logs, logReclaimable int64
+	active, size, reclaimable = 0, 0, 0
+	for _, c := range du.Containers {
+		size += c.Size
+		logs += c.LogSize
+		if c.Status == container.Running {
+			active++
+		} else {
+			reclaimable += c.Size
+			logReclaimable += c.LogSize
+		}
+	}
+	fmt.Fprintf(tw, "Containers\t%d\t%d\t%s\t%s\n", len(du.Containers), active, humanSize(size), reclaimableSize(reclaimable, size))
+	fmt.Fprintf(tw, "Logs\t%d\t%d\t%s\t%s\n", len(du.Containers), active, humanSize(logs), reclaimableSize(logReclaimable, logs))
+
+	total, active, size, reclaimable = len(du.Volumes), 0, 0, 0
+	for _, v := range du.Volumes {
+		size += v.Size
+		if v.Containers > 0 {
+			active++
+		}
+		if v.Reclaimable() {
+			reclaimable += v.Size
+		}
+	}
+	fmt.Fprintf(tw, "Local Volumes\t%d\t%d\t%s\t%s\n", total, active, humanSize(size), reclaimableSize(reclaimable, size))
+	if err := tw.Flush(); err != nil {
+		logrus.Errorf("Flush error %v", err)
+	}
+}
+
+func reclaimableSize(reclaimable, size int64) string {
+	if size == 0 {
+		return humanSize(reclaimable)
+	}
+	return fmt.Sprintf("%s (%d%%)", humanSize(reclaimable), reclaimable*100/size)
+}
+
+// system df -v的明细
+func writeDiskUsageVerbose(w io.Writer, du *diskUsage) {
+	tw := tabwriter.NewWriter(w, 12, 1, 3, ' ', 0)
+	fmt.Fprint(tw, "Images space usage:\n\n")
+	fmt.Fprint(tw, "IMAGE\tSIZE\tSHARED SIZE\tUNIQUE SIZE\tCONTAINERS\n")
+	for _, img := range du.Images {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\n", img.Name, humanSize(img.Size()), humanSize(img.SharedSize), humanSize(img.UniqueSize), img.Containers)
+	}
+	fmt.Fprint(tw, "\nContainers space usage:\n\n")
+	fmt.Fprint(tw, "CONTAINER ID\tIMAGE\tSTATUS\tSIZE\tLOG SIZE\tNAMES\n")
+	for _, c := range du.Containers {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", container.ShortID(c.ID), c.Image, c.Status, humanSize(c.Size), humanSize(c.LogSize), c.Name)
+	}
+	fmt.Fprint(tw, "\nLocal Volumes space usage:\n\n")
+	fmt.Fprint(tw, "VOLUME NAME\tLINKS\tSIZE\tANONYMOUS\n")
+	for _, v := range du.Volumes {
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%t\n", v.Name, v.Containers, humanSize(v.Size), v.Anonymous)
+	}
+	if err := tw.Flush(); err != nil {
+		logrus.Errorf("Flush error %v", err)
+	}
+}
+
+// system df命令的执行函数
+func systemDf(verbose bool) error {
+	du := collectDiskUsage()
+	if verbose {
+		writeDiskUsageVerbose(os.Stdout, du)
+	} else {
+		writeDiskUsage(os.Stdout, du)
+	}
+	return nil
+}
+
+// 删除已停止的容器、没有容器使用的镜像只读层，all为true时同时删除这些镜像的tar包，volumes为true时删除不属于任何容器的匿名数据卷
+// 过滤条件只作用于容器
+func systemPrune(all, volumes, force bool, filterArgs []string) error {
+	filters, err := parsePruneFilters(filterArgs, time.Now())
+	if err != nil {
+		return err
+	}
+	warning := []string{"WARNING! This will remove:", "  - all stopped containers"}
+	if all {
+		warning = append(warning, "  - all images without at least one container associated to them")
+	} else {
+		warning = append(warning, "  - unpacked layers of images without at least one container associated to them")
+	}
+	if volumes {
+		warning = append(warning, "  - all anonymous volumes not used by at least one container")
+	}
+	if !force && !confirm(strings.Join(warning, "\n")) {
+		return nil
+	}
+
+	var reclaimed int64
+	// 删除之前记录容器占用的空间，包括容器层、日志和匿名数据卷
+	sizes := map[string]int64{}
+	for _, info := range allContainers() {
+		if !filters.match(info) {
+			continue
+		}
+		sizes[info.Id] = containerLayerSize(info.Id) + logSize(info)
+		if parts := container.VolumeUrls(info.Volume); parts != nil && info.AnonymousVolume {
+			sizes[info.Id] += dirSize(parts[0])
+		}
+	}
+	deleted := removeStoppedContainers(filters)
+	if len(deleted) > 0 {
+		fmt.Println("Deleted Containers:")
+		for _, id := range deleted {
+			fmt.Println(id)
+			reclaimed += sizes[id]
+		}
+		fmt.Println()
+	}
+
+	// 剩下的容器仍然在使用的镜像和数据卷
+	// 旧版本的状态文件没有记录镜像，存在这样的容器时无法判断哪些镜像没有被使用，不删除镜像
+	usedImages := map[string]bool{}
+	usedVolumes := map[string]bool{}
+	unknownImage := false
+	for _, info := range allContainers() {
+		usedImages[info.Image] = true
+		unknownImage = unknownImage || info.Image == ""
+		if parts := container.VolumeUrls(info.Volume); parts != nil {
+			usedVolumes[parts[0]] = true
+		}
+	}
+	if unknownImage {
+		logrus.Warnf("skip pruning images, some containers do not record their image")
+	}
+	now := time.Now()
+	var deletedImages []string
+	for _, name := range imageNames() {
+		if unknownImage || usedImages[name] {
+			continue
+		}
+		// 正在启动的容器在保存状态之前就已经解压并挂载了镜像层
+		layers := imageLayerDirs(name)
+		if !prunable(imageTar(name), now) || !allPrunable(layers, now) {
+			continue
+		}
+		// 只读层可以从tar包重新解压，删除后不影响之后使用这个镜像
+		for _, dir := range layers {
+			size := dirSize(dir)
+			if err = os.RemoveAll(dir); err != nil {
+				logrus.Errorf("remove image layer %s error %v", dir, err)
+				continue
+			}
+			reclaimed += size
+			deletedImages = append(deletedImages, "deleted: "+dir)
+		}
+		if all {
+			stat, err := os.Stat(imageTar(name))
+			if err != nil {
+				continue
+			}
+			if err = os.Remove(imageTar(name)); err != nil {
+				logrus.Errorf("remove image %s error %v", name, err)
+				continue
+			}
+			reclaimed += stat.Size()
+			deletedImages = append(deletedImages, "untagged: "+name)
+		}
+	}
+	if len(deletedImages) > 0 {
+		fmt.Println("Deleted Images:")
+		fmt.Println(strings.Join(deletedImages, "\n"))
+		fmt.Println()
+	}
+
+	if volumes {
+		var deletedVolumes []string
+		for _, dir := range anonymousVolumeDirs() {
+			// 匿名数据卷以容器id命名，正在启动的容器在保存状态之前就已经创建了数据卷和状态目录
+			id := filepath.Base(dir)
+			if usedVolumes[dir] || exists(fmt.Sprintf(container.DefaultInfoLocation, id)) || !prunable(dir, now) {
+				continue
+			}
+			size := dirSize(dir)
+			if err = os.RemoveAll(dir); err != nil {
+				logrus.Errorf("remove volume %s error %v", dir, err)
+				continue
+			}
+			reclaimed += size
+			deletedVolumes = append(deletedVolumes, dir)
+		}
+		if len(deletedVolumes) > 0 {
+			fmt.Println("Deleted Volumes:")
+			fmt.Println(strings.Join(deletedVolumes, "\n"))
+			fmt.Println()
+		}
+	}
+	fmt.Printf("Total reclaimed space: %s\n", humanSize(reclaimed))
+	return nil
+}
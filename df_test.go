@@ -0,0 +1,36 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDirSize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "df")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.MkdirAll(filepath.Join(dir, "sub"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "a"), make([]byte, 100), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "sub", "b"), make([]byte, 50), 0644)
+	// 符号链接不计入大小
+	os.Symlink(filepath.Join(dir, "a"), filepath.Join(dir, "link"))
+	if got := dirSize(dir); got != 150 {
+		t.Errorf("dirSize = %d, want 150", got)
+	}
+	if got := dirSize(filepath.Join(dir, "missing")); got != 0 {
+		t.Errorf("dirSize of missing dir = %d, want 0", got)
+	}
+}
+
+func TestReclaimableSize(t *testing.T) {
+	if got := reclaimableSize(0, 0); got != "0B" {
+		t.Errorf("reclaimableSize(0, 0) = %s", got)
+	}
+	if got := reclaimableSize(250, 1000); got != "250B (25%)" {
+		t.Errorf("reclaimableSize(250, 1000) = %s", got)
+	}
+}
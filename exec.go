@@ -1,44 +1,112 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/sirupsen/logrus"
+	"github.com/yunfeiyang1916/cloud-docker/cgroups"
+	"github.com/yunfeiyang1916/cloud-docker/container"
 	_ "github.com/yunfeiyang1916/cloud-docker/nsenter"
 	"io/ioutil"
 	"os"
 	"os/exec"
 	"strings"
+	"syscall"
 )
 
 const (
-	// EnvExecPID pid环境变量
+	// EnvExecPID pid环境变量，nsenter根据它进入容器的namespace
 	EnvExecPID = "cloud_docker_pid"
-	// 	EnvExecCmd exec命令环境变量
-	EnvExecCmd = "cloud_docker_cmd"
+	// EnvExecCgroupProcs 容器init进程所在cgroup的cgroup.procs文件，每行一个，nsenter把exec进程加入这些cgroup
+	EnvExecCgroupProcs = "cloud_docker_cgroup_procs"
 )
 
-func ExecContainer(containerName string, cmdArray []string) {
+// ExecOptions exec命令的终端和运行方式
+type ExecOptions struct {
+	// 保持标准输入打开
+	Interactive bool
+	// 分配伪终端
+	Tty bool
+	// 在后台执行，不等待命令结束
+	Detach bool
+}
+
+// ExecContainer 在容器中执行命令，返回命令的退出码，后台执行时总是返回0
+func ExecContainer(containerName string, req *container.ExecRequest, opts ExecOptions) (int, error) {
 	info, err := getContainerInfo(containerName)
 	if err != nil {
-		logrus.Errorf("ExecContainer getContainerInfoByName %s error %v", containerName, err)
-		return
+		return -1, fmt.Errorf("get container info %s error %v", containerName, err)
+	}
+	if info.Status != container.Running {
+		return -1, fmt.Errorf("container %s is not running", containerName)
 	}
-	// 把命令以空格为分隔符拼接成一个字符串，便于传递
-	cmdStr := strings.Join(cmdArray, " ")
 	logrus.Infof("container pid %s", info.Pid)
-	logrus.Infof("command %s", cmdStr)
-	// 克隆自己，执行exec命令
-	cmd := exec.Command("/proc/self/exe", "exec")
-	cmd.Stdin = os.Stdin
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	os.Setenv(EnvExecPID, info.Pid)
-	os.Setenv(EnvExecCmd, cmdStr)
-	envs := getEnvsByPid(info.Pid)
-	cmd.Env = append(os.Environ(), envs...)
-	if err = cmd.Run(); err != nil {
-		logrus.Errorf("exec container %s error %v", containerName, err)
+	logrus.Infof("command %q", req.Args)
+	// 命令默认继承容器init进程的环境变量，-e指定的变量覆盖同名变量
+	req.Env = append(getEnvsByPid(info.Pid), req.Env...)
+	// exec进入的进程与init进程在同一个cgroup中，不能逃脱容器的资源限制
+	procsFiles, err := cgroups.ProcsFiles(info.Pid)
+	if err != nil {
+		return -1, fmt.Errorf("get cgroup of container %s error %v", containerName, err)
+	}
+
+	// 请求通过管道传给容器内的进程，子进程的第4个文件描述符就是读管道
+	readPipe, writePipe, err := container.NewPipe()
+	if err != nil {
+		return -1, err
+	}
+	// 克隆自己，执行exec命令，nsenter会在Go运行时启动前进入容器的namespace
+	cmd := container.SelfCommand("exec")
+	var pty *container.Pty
+	switch {
+	case opts.Detach:
+		// 后台执行时标准输入输出都指向/dev/null，并脱离当前会话，exec命令退出后不受终端关闭影响
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	case opts.Tty:
+		if pty, err = container.NewPty(); err != nil {
+			return -1, fmt.Errorf("allocate pty error %v", err)
+		}
+		pty.SetCtty(cmd)
+	default:
+		if opts.Interactive {
+			cmd.Stdin = os.Stdin
+		}
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
 	}
+	cmd.ExtraFiles = []*os.File{readPipe}
+	cmd.Env = append(os.Environ(), EnvExecPID+"="+info.Pid)
+	// exec进入的进程使用和init进程相同的安全配置和资源限制
+	cmd.Env = append(cmd.Env, info.Security.Env()...)
+	cmd.Env = append(cmd.Env, container.RlimitsEnv(info.Rlimits)...)
+	cmd.Env = append(cmd.Env, EnvExecCgroupProcs+"="+strings.Join(procsFiles, "\n"))
+	if err = cmd.Start(); err != nil {
+		return -1, fmt.Errorf("exec container %s error %v", containerName, err)
+	}
+	readPipe.Close()
+	if pty != nil {
+		pty.CloseSlave()
+	}
+	if err = json.NewEncoder(writePipe).Encode(req); err != nil {
+		logrus.Errorf("send exec request error %v", err)
+	}
+	writePipe.Close()
+	if opts.Detach {
+		logrus.Infof("exec process %d running in background", cmd.Process.Pid)
+		return 0, cmd.Process.Release()
+	}
+	if pty != nil {
+		stopProxy := proxyTerminal(pty, opts.Interactive)
+		defer stopProxy()
+	}
+	// 容器内命令的退出码就是exec命令的退出码
+	if err = cmd.Wait(); err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return exitErr.ExitCode(), nil
+		}
+		return -1, err
+	}
+	return 0, nil
 }
 
 // 获取指定进程的环境变量
@@ -50,6 +118,11 @@ func getEnvsByPid(pid string) []string {
 		return nil
 	}
 	// 多个环境变量中的分隔符是\u0000
-	envs := strings.Split(string(buf), "\u0000")
+	var envs []string
+	for _, env := range strings.Split(string(buf), "\u0000") {
+		if env != "" {
+			envs = append(envs, env)
+		}
+	}
 	return envs
 }
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/sirupsen/logrus"
+	"github.com/yunfeiyang1916/cloud-docker/cgroups/subsystems"
+	"github.com/yunfeiyang1916/cloud-docker/container"
+	"github.com/yunfeiyang1916/cloud-docker/logger"
+	"github.com/yunfeiyang1916/cloud-docker/network"
+)
+
+// info命令展示的生效配置、宿主机内核特性和资源统计
+type systemInfo struct {
+	ConfigFile     string
+	DataRoot       string
+	ExecRoot       string
+	Rootless       bool
+	StorageDriver  string
+	DefaultNetwork string
+	LoggingDriver  string
+	LogOpts        map[string]string
+	DefaultUlimits []string
+	DNS            []string
+	LogLevel       string
+	LogFormat      string
+	KernelVersion  string
+	// cgroup由cloud-docker直接读写cgroupfs管理
+	CgroupDriver      string
+	CgroupVersion     string
+	CgroupControllers []string
+	OverlaySupport    bool
+	// 宿主机支持的存储驱动
+	StorageDrivers []string
+	// 内核支持的namespace
+	Namespaces []string
+	// iptables命令的路径，为空表示找不到iptables
+	Iptables          string
+	IptablesBackend   string
+	Nftables          bool
+	Containers        int
+	ContainersRunning int
+	ContainersStopped int
+	Images            int
+	Networks          int
+	Volumes           int
+	// 数据目录所在文件系统的容量，单位为字节
+	DataSpaceTotal     uint64
+	DataSpaceUsed      uint64
+	DataSpaceAvailable uint64
+}
+
+// 收集当前生效的配置，配置文件中没有设置的项显示默认值
+func collectInfo(configFile string) *systemInfo {
+	storageDriver, _ := container.ParseStorageDriver(daemonCfg.StorageDriver)
+	logConfig, _ := daemonCfg.logConfig("", false, nil)
+	info := &systemInfo{
+		ConfigFile:        configFile,
+		DataRoot:          container.DataRoot,
+		ExecRoot:          container.ExecRoot,
+		Rootless:          container.IsRootless(),
+		StorageDriver:     storageDriver,
+		DefaultNetwork:    daemonCfg.DefaultNetwork,
+		LoggingDriver:     logConfig.Type,
+		LogOpts:           logConfig.Config,
+		DefaultUlimits:    daemonCfg.DefaultUlimits,
+		DNS:               daemonCfg.DNS,
+		LogLevel:          logrus.GetLevel().String(),
+		LogFormat:         daemonCfg.LogFormat,
+		KernelVersion:     kernelVersion(),
+		CgroupDriver:      "cgroupfs",
+		CgroupVersion:     "1",
+		CgroupControllers: subsystems.AvailableControllers(),
+		OverlaySupport:    container.FilesystemSupported("overlay"),
+		Nftables:          network.NftablesAvailable(),
+		Images:            len(imageNames()),
+	}
+	if info.LoggingDriver == "" {
+		info.LoggingDriver = logger.DefaultDriver
+	}
+	if info.LogFormat == "" {
+		info.LogFormat = "json"
+	}
+	if subsystems.IsCgroup2() {
+		info.CgroupVersion = "2"
+	}
+	for _, driver := range container.StorageDrivers {
+		if container.StorageDriverSupported(driver) {
+			info.StorageDrivers = append(info.StorageDrivers, driver)
+		}
+	}
+	for _, nsType := range container.AllNamespaces {
+		if container.NamespaceSupported(nsType) {
+			info.Namespaces = append(info.Namespaces, nsType)
+		}
+	}
+	info.Iptables, info.IptablesBackend = network.IptablesBackend()
+	for _, c := range allContainers() {
+		info.Containers++
+		if c.Status == container.Running {
+			info.ContainersRunning++
+		} else {
+			info.ContainersStopped++
+		}
+	}
+	if err := network.Init(); err == nil {
+		info.Networks = len(network.Networks())
+	}
+	if volumes, err := loadVolumes(); err == nil {
+		info.Volumes = len(volumes)
+	}
+	var fs syscall.Statfs_t
+	if err := syscall.Statfs(container.DataRoot, &fs); err == nil {
+		info.DataSpaceTotal = fs.Blocks * uint64(fs.Bsize)
+		info.DataSpaceAvailable = fs.Bavail * uint64(fs.Bsize)
+		info.DataSpaceUsed = (fs.Blocks - fs.Bfree) * uint64(fs.Bsize)
+	}
+	return info
+}
+
+// 展示生效的配置和检测到的内核特性，format不为空时按模板输出
+func showInfo(configFile, format string) error {
+	info := collectInfo(configFile)
+	if format != "" {
+		tmpl, err := parseFormat(format)
+		if err != nil {
+			return err
+		}
+		return writeFormat(os.Stdout, tmpl, info)
+	}
+	writeInfo(os.Stdout, info)
+	return nil
+}
+
+func writeInfo(w io.Writer, info *systemInfo) {
+	fmt.Fprintf(w, "Containers: %d\n", info.Containers)
+	fmt.Fprintf(w, " Running: %d\n", info.ContainersRunning)
+	fmt.Fprintf(w, " Stopped: %d\n", info.ContainersStopped)
+	fmt.Fprintf(w, "Images: %d\n", info.Images)
+	fmt.Fprintf(w, "Networks: %d\n", info.Networks)
+	fmt.Fprintf(w, "Volumes: %d\n", info.Volumes)
+	fmt.Fprintf(w, "Config File: %s\n", info.ConfigFile)
+	fmt.Fprintf(w, "Data Root: %s\n", info.DataRoot)
+	fmt.Fprintf(w, " Data Space Total: %s\n", humanSize(int64(info.DataSpaceTotal)))
+	fmt.Fprintf(w, " Data Space Used: %s\n", humanSize(int64(info.DataSpaceUsed)))
+	fmt.Fprintf(w, " Data Space Available: %s\n", humanSize(int64(info.DataSpaceAvailable)))
+	fmt.Fprintf(w, "Exec Root: %s\n", info.ExecRoot)
+	fmt.Fprintf(w, "Rootless: %t\n", info.Rootless)
+	fmt.Fprintf(w, "Storage Driver: %s\n", info.StorageDriver)
+	fmt.Fprintf(w, " Supported Storage Drivers: %s\n", orNone(strings.Join(info.StorageDrivers, ", ")))
+	fmt.Fprintf(w, "Default Network: %s\n", orNone(info.DefaultNetwork))
+	fmt.Fprintf(w, "Logging Driver: %s\n", info.LoggingDriver)
+	var opts []string
+	for key, value := range info.LogOpts {
+		opts = append(opts, key+"="+value)
+	}
+	sort.Strings(opts)
+	fmt.Fprintf(w, "Log Opts: %s\n", orNone(strings.Join(opts, ",")))
+	fmt.Fprintf(w, "Default Ulimits: %s\n", orNone(strings.Join(info.DefaultUlimits, ",")))
+	fmt.Fprintf(w, "DNS: %s\n", orNone(strings.Join(info.DNS, ",")))
+	fmt.Fprintf(w, "Log Level: %s\n", info.LogLevel)
+	fmt.Fprintf(w, "Log Format: %s\n", info.LogFormat)
+	fmt.Fprintf(w, "Kernel Version: %s\n", info.KernelVersion)
+	fmt.Fprintf(w, "Cgroup Driver: %s\n", info.CgroupDriver)
+	fmt.Fprintf(w, "Cgroup Version: %s\n", info.CgroupVersion)
+	fmt.Fprintf(w, " Controllers: %s\n", orNone(strings.Join(info.CgroupControllers, ", ")))
+	fmt.Fprintf(w, "Namespaces: %s\n", orNone(strings.Join(info.Namespaces, ", ")))
+	fmt.Fprintf(w, "Overlay Support: %t\n", info.OverlaySupport)
+	if info.Iptables == "" {
+		fmt.Fprintln(w, "WARNING: iptables not found, bridge networks and port mapping are unavailable")
+	} else {
+		fmt.Fprintf(w, "Iptables: %s (%s)\n", info.Iptables, orNone(info.IptablesBackend))
+	}
+	fmt.Fprintf(w, "Nftables: %t\n", info.Nftables)
+}
+
+func orNone(value string) string {
+	if value == "" {
+		return "none"
+	}
+	return value
+}
+
+// 内核版本，即uname -r的输出
+func kernelVersion() string {
+	var uts syscall.Utsname
+	if err := syscall.Uname(&uts); err != nil {
+		return "unknown"
+	}
+	var buf []byte
+	for _, c := range uts.Release {
+		if c == 0 {
+			break
+		}
+		buf = append(buf, byte(c))
+	}
+	return string(buf)
+}
+
+// 数据目录中的镜像，镜像即<镜像名>.tar
+func imageNames() []string {
+	files, _ := filepath.Glob(filepath.Join(container.RootUrl, "*.tar"))
+	var names []string
+	for _, file := range files {
+		names = append(names, strings.TrimSuffix(filepath.Base(file), ".tar"))
+	}
+	return names
+}
+
+// 与docker一样使用十进制单位，保留4位有效数字，例如1.234GB
+func humanSize(size int64) string {
+	units := []string{"B", "kB", "MB", "GB", "TB", "PB"}
+	value := float64(size)
+	i := 0
+	for value >= 1000 && i < len(units)-1 {
+		value /= 1000
+		i++
+	}
+	return fmt.Sprintf("%.4g%s", value, units[i])
+}
@@ -0,0 +1,18 @@
+package main
+
+import "testing"
+
+func TestHumanSize(t *testing.T) {
+	cases := map[int64]string{
+		0:          "0B",
+		999:        "999B",
+		1000:       "1kB",
+		1234567:    "1.235MB",
+		5000000000: "5GB",
+	}
+	for size, want := range cases {
+		if got := humanSize(size); got != want {
+			t.Errorf("humanSize(%d) = %s, want %s", size, got, want)
+		}
+	}
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/yunfeiyang1916/cloud-docker/container"
+	"github.com/yunfeiyang1916/cloud-docker/logger"
+	"github.com/yunfeiyang1916/cloud-docker/network"
+)
+
+// inspect支持的对象类型
+const (
+	inspectContainer = "container"
+	inspectNetwork   = "network"
+	inspectImage     = "image"
+	inspectVolume    = "volume"
+)
+
+// 容器的inspect结果，字段名与docker inspect保持一致，便于沿用已有的--format模板
+type containerInspect struct {
+	Id              string
+	Name            string
+	Created         string
+	Image           string
+	Pod             string `json:",omitempty"`
+	State           containerState
+	Config          containerConfig
+	HostConfig      hostConfig
+	Mounts          []mountPoint
+	GraphDriver     graphDriver
+	NetworkSettings networkSettings
+	LogPath         string
+}
+
+type containerState struct {
+	Status   string
+	Running  bool
+	Pid      int
+	ExitCode int
+}
+
+type containerConfig struct {
+	Cmd       string
+	Image     string
+	Tty       bool
+	OpenStdin bool
+	Env       []string
+	Labels    map[string]string
+}
+
+type hostConfig struct {
+	Memory       string
+	CpuShares    string
+	CpusetCpus   string
+	PortBindings []string
+	Ulimits      []container.Rlimit
+	SecurityOpt  *container.SecurityConfig
+	LogConfig    logger.Config
+	NetworkMode  string
+	Namespaces   map[string]string
+	CgroupnsMode string
+	TimeOffsets  map[string]string
+	UidMappings  []container.IDMapping
+	GidMappings  []container.IDMapping
+	Dns          []string
+}
+
+type mountPoint struct {
+	Type        string
+	Source      string
+	Destination string
+}
+
+type graphDriver struct {
+	Name string
+	Data map[string]string
+}
+
+type networkSettings struct {
+	IPAddress   string
+	IPPrefixLen int
+	Gateway     string
+	MacAddress  string
+	Ports       []string
+	Networks    map[string]*container.EndpointSettings
+}
+
+// 网络的inspect结果
+type networkInspect struct {
+	Name       string
+	Driver     string
+	Subnet     string
+	Gateway    string
+	Labels     map[string]string
+	Containers map[string]networkContainer
+}
+
+type networkContainer struct {
+	Name        string
+	EndpointID  string
+	IPv4Address string
+	MacAddress  string
+}
+
+// 镜像的inspect结果，镜像即RootUrl下的tar包，解压后的目录作为容器的只读层
+type imageInspect struct {
+	Name    string
+	Path    string
+	Size    int64
+	Created string
+	RootFS  string
+}
+
+// 数据卷的inspect结果，数据卷是通过-v挂载到容器中的宿主机目录
+type volumeInspect struct {
+	Name       string
+	Driver     string
+	Mountpoint string
+	CreatedAt  string
+	Labels     map[string]string
+	Containers []string
+}
+
+// 依次查找并输出对象，默认输出json数组，指定format时按模板逐个输出
+func inspect(refs []string, objType, format string) error {
+	var tmpl *template.Template
+	if format != "" {
+		var err error
+		if tmpl, err = parseFormat(format); err != nil {
+			return err
+		}
+	}
+	var objects []interface{}
+	var errs []string
+	for _, ref := range refs {
+		obj, err := inspectObject(ref, objType)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		objects = append(objects, obj)
+	}
+	if tmpl != nil {
+		for _, obj := range objects {
+			if err := writeFormat(os.Stdout, tmpl, obj); err != nil {
+				return err
+			}
+		}
+	} else if len(objects) > 0 {
+		buf, err := json.MarshalIndent(objects, "", "    ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(buf))
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "\n"))
+	}
+	return nil
+}
+
+// 未指定类型时依次按容器、网络、镜像、数据卷查找
+func inspectObject(ref, objType string) (interface{}, error) {
+	finders := map[string]func(string) (interface{}, error){
+		inspectContainer: inspectContainerObject,
+		inspectNetwork:   inspectNetworkObject,
+		inspectImage:     inspectImageObject,
+		inspectVolume:    inspectVolumeObject,
+	}
+	if objType != "" {
+		find, ok := finders[objType]
+		if !ok {
+			return nil, fmt.Errorf("invalid type %s, expect container, network, image or volume", objType)
+		}
+		return find(ref)
+	}
+	for _, t := range []string{inspectContainer, inspectNetwork, inspectImage, inspectVolume} {
+		if obj, err := finders[t](ref); err == nil {
+			return obj, nil
+		}
+	}
+	return nil, fmt.Errorf("no such object: %s", ref)
+}
+
+func inspectContainerObject(ref string) (interface{}, error) {
+	info, err := getContainerInfo(ref)
+	if err != nil {
+		return nil, err
+	}
+	return newContainerInspect(info), nil
+}
+
+func newContainerInspect(info *container.ContainerInfo) *containerInspect {
+	pid, _ := strconv.Atoi(info.Pid)
+	c := &containerInspect{
+		Id:      info.Id,
+		Name:    info.Name,
+		Created: info.CreatedTime,
+		Image:   info.Image,
+		Pod:     info.Pod,
+		State: containerState{
+			Status:   info.Status,
+			Running:  info.Status == container.Running,
+			Pid:      pid,
+			ExitCode: info.ExitCode,
+		},
+		Config: containerConfig{
+			Cmd:       info.Command,
+			Image:     info.Image,
+			Tty:       info.Tty,
+			OpenStdin: info.OpenStdin,
+			Env:       info.Env,
+			Labels:    info.Labels,
+		},
+		HostConfig: hostConfig{
+			PortBindings: info.PortMapping,
+			Ulimits:      info.Rlimits,
+			SecurityOpt:  info.Security,
+			LogConfig:    info.LogConfig,
+			NetworkMode:  info.Network,
+			Namespaces:   info.Namespaces,
+			CgroupnsMode: info.Cgroupns,
+			TimeOffsets:  info.TimeOffsets,
+			UidMappings:  info.UidMappings,
+			GidMappings:  info.GidMappings,
+			Dns:          info.Dns,
+		},
+		Mounts: []mountPoint{},
+		GraphDriver: graphDriver{
+			Name: info.StorageDriverName(),
+			Data: map[string]string{
+				"LowerDir":  container.ImageLayerUrl(info.Image, info.Userns()),
+				"UpperDir":  fmt.Sprintf(container.WriteLayerUrl, info.Id),
+				"MergedDir": fmt.Sprintf(container.MntUrl, info.Id),
+			},
+		},
+		NetworkSettings: networkSettings{
+			Ports:    info.PortMapping,
+			Networks: map[string]*container.EndpointSettings{},
+		},
+	}
+	if c.GraphDriver.Name != container.StorageDriverAufs {
+		c.GraphDriver.Data["WorkDir"] = fmt.Sprintf(container.WorkLayerUrl, info.Id)
+	}
+	if res := info.Resources; res != nil {
+		c.HostConfig.Memory = res.MemoryLimit
+		c.HostConfig.CpuShares = res.CpuShare
+		c.HostConfig.CpusetCpus = res.CpuSet
+	}
+	if parts := container.VolumeUrls(info.Volume); parts != nil {
+		c.Mounts = append(c.Mounts, mountPoint{Type: "volume", Source: parts[0], Destination: parts[1]})
+	}
+	if ep := info.Endpoint; ep != nil {
+		c.NetworkSettings.IPAddress = ep.IPAddress
+		c.NetworkSettings.IPPrefixLen = ep.IPPrefixLen
+		c.NetworkSettings.Gateway = ep.Gateway
+		c.NetworkSettings.MacAddress = ep.MacAddress
+		c.NetworkSettings.Networks[info.Network] = ep
+	}
+	switch info.LogConfig.Type {
+	case logger.JSONFileDriverName:
+		c.LogPath = info.LogInfo().LogDir + logger.JSONFileName
+	case logger.LocalDriverName:
+		c.LogPath = info.LogInfo().LogDir + logger.LocalFileName
+	}
+	return c
+}
+
+func inspectNetworkObject(name string) (interface{}, error) {
+	network.Init()
+	nw, err := network.GetNetwork(name)
+	if err != nil {
+		return nil, err
+	}
+	n := &networkInspect{
+		Name:       nw.Name,
+		Driver:     nw.Driver,
+		Labels:     nw.Labels,
+		Containers: map[string]networkContainer{},
+	}
+	if nw.IpRange != nil {
+		subnet := nw.IpRange.IP.Mask(nw.IpRange.Mask)
+		ones, _ := nw.IpRange.Mask.Size()
+		n.Subnet = fmt.Sprintf("%s/%d", subnet, ones)
+		n.Gateway = nw.IpRange.IP.String()
+	}
+	for _, info := range allContainers() {
+		if info.Network != name || info.Endpoint == nil {
+			continue
+		}
+		n.Containers[info.Id] = networkContainer{
+			Name:        info.Name,
+			EndpointID:  info.Endpoint.EndpointID,
+			IPv4Address: fmt.Sprintf("%s/%d", info.Endpoint.IPAddress, info.Endpoint.IPPrefixLen),
+			MacAddress:  info.Endpoint.MacAddress,
+		}
+	}
+	return n, nil
+}
+
+func inspectImageObject(name string) (interface{}, error) {
+	tarPath := container.RootUrl + "/" + name + ".tar"
+	stat, err := os.Stat(tarPath)
+	if err != nil {
+		return nil, fmt.Errorf("no such image: %s", name)
+	}
+	img := &imageInspect{
+		Name:    name,
+		Path:    tarPath,
+		Size:    stat.Size(),
+		Created: stat.ModTime().Format(time.RFC3339),
+	}
+	// 镜像在第一次被使用时才会解压
+	if rootfs := container.ImageLayerUrl(name, nil); exists(rootfs) {
+		img.RootFS = rootfs
+	}
+	return img, nil
+}
+
+// 数据卷按宿主机目录查找，需要由volume create创建或者正在被容器使用
+// 挂载方式取决于使用它的容器的存储驱动，没有被容器使用时只是宿主机上的本地目录
+func inspectVolumeObject(source string) (interface{}, error) {
+	v := &volumeInspect{Name: source, Driver: "local", Mountpoint: source}
+	for _, info := range allContainers() {
+		if parts := container.VolumeUrls(info.Volume); parts != nil && parts[0] == source {
+			v.Containers = append(v.Containers, info.Id)
+			v.Driver = container.VolumeMountType(info.StorageDriverName())
+		}
+	}
+	volumes, err := loadVolumes()
+	if err != nil {
+		return nil, err
+	}
+	meta, ok := volumes[source]
+	if !ok && len(v.Containers) == 0 {
+		return nil, fmt.Errorf("no such volume: %s", source)
+	}
+	if ok {
+		v.CreatedAt = meta.CreatedAt
+		v.Labels = meta.Labels
+	}
+	if v.Containers == nil {
+		v.Containers = []string{}
+	}
+	return v, nil
+}
+
+func exists(path string) bool {
+	ok, _ := container.PathExists(path)
+	return ok
+}
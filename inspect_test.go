@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/yunfeiyang1916/cloud-docker/cgroups/subsystems"
+	"github.com/yunfeiyang1916/cloud-docker/container"
+)
+
+func TestInspectFormat(t *testing.T) {
+	info := &container.ContainerInfo{
+		Id:        container.GenerateID(),
+		Name:      "web",
+		Pid:       "42",
+		Status:    container.Running,
+		Volume:    "/data:/var/lib/data",
+		Network:   "br0",
+		Resources: &subsystems.ResourceConfig{MemoryLimit: "100m"},
+		Endpoint:  &container.EndpointSettings{IPAddress: "10.0.0.2", IPPrefixLen: 24, MacAddress: "02:42:0a:00:00:02"},
+	}
+	cases := map[string]string{
+		"{{.NetworkSettings.IPAddress}}":                           "10.0.0.2\n",
+		"{{.State.Pid}} {{.State.Running}}":                        "42 true\n",
+		"{{.HostConfig.Memory}}":                                   "100m\n",
+		"{{(index .NetworkSettings.Networks \"br0\").MacAddress}}": "02:42:0a:00:00:02\n",
+		"{{json .Mounts}}":                                         `[{"Type":"volume","Source":"/data","Destination":"/var/lib/data"}]` + "\n",
+	}
+	for format, expected := range cases {
+		tmpl, err := parseFormat(format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		if err = writeFormat(&buf, tmpl, newContainerInspect(info)); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != expected {
+			t.Fatalf("format %s got %q, expected %q", format, buf.String(), expected)
+		}
+	}
+	if _, err := parseFormat("{{.State"); err == nil {
+		t.Fatal("expect error for invalid template")
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// 解析--label和--label-file参数，格式为 key=value，只有key时值为空
+// 标签文件每行一个标签，忽略空行和#开头的注释，命令行中的标签覆盖文件中的同名标签
+func parseLabels(labels, files []string) (map[string]string, error) {
+	result := map[string]string{}
+	for _, file := range files {
+		fileLabels, err := readLabelFile(file)
+		if err != nil {
+			return nil, err
+		}
+		labels = append(fileLabels, labels...)
+	}
+	for _, label := range labels {
+		kv := strings.SplitN(label, "=", 2)
+		if kv[0] == "" {
+			return nil, fmt.Errorf("invalid label %s, key can not be empty", label)
+		}
+		if len(kv) == 1 {
+			kv = append(kv, "")
+		}
+		result[kv[0]] = kv[1]
+	}
+	if len(result) == 0 {
+		return nil, nil
+	}
+	return result, nil
+}
+
+func readLabelFile(file string) ([]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("open label file %s error %v", file, err)
+	}
+	defer f.Close()
+	var labels []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		labels = append(labels, line)
+	}
+	return labels, scanner.Err()
+}
+
+// 标签过滤条件为 key 或 key=value
+func matchLabel(labels map[string]string, filter string) bool {
+	kv := strings.SplitN(filter, "=", 2)
+	value, ok := labels[kv[0]]
+	if !ok {
+		return false
+	}
+	return len(kv) == 1 || value == kv[1]
+}
+
+// 解析只支持标签的--filter参数，返回所有标签过滤条件
+func parseLabelFilters(filters []string) ([]string, error) {
+	var labels []string
+	for _, filter := range filters {
+		kv := strings.SplitN(filter, "=", 2)
+		if len(kv) != 2 || kv[0] != "label" || kv[1] == "" {
+			return nil, fmt.Errorf("invalid filter %s, expect label=<key> or label=<key>=<value>", filter)
+		}
+		labels = append(labels, kv[1])
+	}
+	return labels, nil
+}
+
+// 需要满足所有标签过滤条件
+func matchLabels(labels map[string]string, filters []string) bool {
+	for _, filter := range filters {
+		if !matchLabel(labels, filter) {
+			return false
+		}
+	}
+	return true
+}
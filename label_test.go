@@ -0,0 +1,42 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestParseLabels(t *testing.T) {
+	f, err := ioutil.TempFile("", "labels")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("# build metadata\nteam=infra\n\nbuild=42\nowner=bob\n")
+	f.Close()
+	labels, err := parseLabels([]string{"owner=alice", "canary"}, []string{f.Name()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{"team": "infra", "build": "42", "owner": "alice", "canary": ""}
+	if len(labels) != len(expected) {
+		t.Fatalf("got %v, expected %v", labels, expected)
+	}
+	for k, v := range expected {
+		if value, ok := labels[k]; !ok || value != v {
+			t.Fatalf("label %s got %q, expected %q", k, value, v)
+		}
+	}
+	if !matchLabels(labels, []string{"team=infra", "canary"}) || matchLabels(labels, []string{"team=web"}) {
+		t.Fatal("unexpected label match")
+	}
+	if _, err = parseLabels([]string{"=value"}, nil); err == nil {
+		t.Fatal("expect error for empty key")
+	}
+	if _, err = parseLabels(nil, []string{"/nonexistent"}); err == nil {
+		t.Fatal("expect error for missing label file")
+	}
+	if _, err = parseLabelFilters([]string{"name=web"}); err == nil {
+		t.Fatal("expect error for non-label filter")
+	}
+}
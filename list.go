@@ -1,66 +1,311 @@
 package main
 
 import (
-	"encoding/json"
 	"fmt"
 	"github.com/sirupsen/logrus"
 	"github.com/yunfeiyang1916/cloud-docker/container"
-	"io/ioutil"
+	"github.com/yunfeiyang1916/cloud-docker/store"
 	"os"
+	"sort"
+	"strings"
 	"text/tabwriter"
+	"text/template"
 )
 
-func ListContainers() {
-	dirPath := fmt.Sprintf(container.DefaultInfoLocation, "")
-	// 去除最后一个字符
-	dirPath = dirPath[:len(dirPath)-1]
-	// 读取该文件夹下的所有文件
-	files, err := ioutil.ReadDir(dirPath)
+// ps命令默认的表格列
+const defaultPsFormat = "table {{.ID}}\t{{.Names}}\t{{.Image}}\t{{.Pid}}\t{{.Status}}\t{{.Command}}\t{{.Ports}}\t{{.CreatedAt}}"
+
+// 截断展示的命令长度
+const commandTruncLength = 20
+
+// ps命令支持的过滤条件
+var psFilterKeys = map[string]bool{
+	"id":       true,
+	"name":     true,
+	"status":   true,
+	"label":    true,
+	"network":  true,
+	"ancestor": true,
+}
+
+// PsOptions ps命令的参数
+type PsOptions struct {
+	// 展示所有容器，默认只展示运行中的容器
+	All bool
+	// 只输出容器id
+	Quiet bool
+	// 不截断id和命令
+	NoTrunc bool
+	// 过滤条件，格式为 key=value
+	Filters []string
+	// 输出格式，table开头的模板按表格输出，json按行输出json
+	Format string
+}
+
+// ps输出的一行，--format模板中可以使用这些字段
+type psRow struct {
+	ID        string
+	Names     string
+	Image     string
+	Command   string
+	CreatedAt string
+	Status    string
+	Pid       string
+	Ports     string
+	Networks  string
+	Labels    string
+	labels    map[string]string
+}
+
+// Label 模板中按key取标签的值，如 {{.Label "team"}}
+func (r psRow) Label(key string) string {
+	return r.labels[key]
+}
+
+// 表格的表头
+var psHeader = psRow{
+	ID:        "CONTAINER ID",
+	Names:     "NAMES",
+	Image:     "IMAGE",
+	Command:   "COMMAND",
+	CreatedAt: "CREATED",
+	Status:    "STATUS",
+	Pid:       "PID",
+	Ports:     "PORTS",
+	Networks:  "NETWORKS",
+	Labels:    "LABELS",
+}
+
+func ListContainers(opts PsOptions) error {
+	filters, err := parsePsFilters(opts.Filters)
 	if err != nil {
-		logrus.Errorf("read dir %s error %v", dirPath, err)
-		return
+		return err
+	}
+	// 按状态过滤时不再默认只展示运行中的容器
+	if _, ok := filters["status"]; ok {
+		opts.All = true
+	}
+	ids, err := store.List()
+	if err != nil {
+		return fmt.Errorf("list containers error %v", err)
 	}
 	var infoList []*container.ContainerInfo
-	// 遍历所有文件
-	for _, file := range files {
+	for _, id := range ids {
 		// 根据容器配置文件获取对应信息
-		info, err := getContainerInfo(file.Name())
+		info, err := store.Get(id)
 		if err != nil {
-			logrus.Errorf("getContainerInfo error %s", err)
+			logrus.Errorf("get container %s error %s", container.ShortID(id), err)
+			continue
+		}
+		if !opts.All && info.Status != container.Running {
 			continue
 		}
-		infoList = append(infoList, info)
+		if matchPsFilters(info, filters) {
+			infoList = append(infoList, info)
+		}
+	}
+	// 最新创建的容器排在前面
+	sort.SliceStable(infoList, func(i, j int) bool {
+		return infoList[i].CreatedTime > infoList[j].CreatedTime
+	})
+	if opts.Quiet {
+		for _, info := range infoList {
+			fmt.Println(newPsRow(info, opts.NoTrunc).ID)
+		}
+		return nil
+	}
+	if opts.Format == "json" {
+		for _, info := range infoList {
+			buf, err := marshalJSON(newPsRow(info, opts.NoTrunc))
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(buf))
+		}
+		return nil
+	}
+	format := opts.Format
+	if format == "" {
+		format = defaultPsFormat
+	}
+	table := strings.HasPrefix(format, "table")
+	if table {
+		format = strings.TrimSpace(strings.TrimPrefix(format, "table"))
+	}
+	tmpl, err := parseFormat(format)
+	if err != nil {
+		return err
+	}
+	if !table {
+		for _, info := range infoList {
+			if err = writeFormat(os.Stdout, tmpl, newPsRow(info, opts.NoTrunc)); err != nil {
+				return err
+			}
+		}
+		return nil
 	}
 	// 使用tabwriter打印容器信息
 	w := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
-	fmt.Fprint(w, "ID\tNAME\tPID\tSTATUS\tCOMMAND\tCREATED\n")
-	for _, item := range infoList {
-		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
-			item.Id,
-			item.Name,
-			item.Pid,
-			item.Status,
-			item.Command,
-			item.CreatedTime)
+	if err = writeRows(w, tmpl, infoList, opts.NoTrunc); err != nil {
+		return err
 	}
 	// 刷新标准输出流到缓存区
-	if err = w.Flush(); err != nil {
-		logrus.Errorf("Flush error %s", err)
-		return
+	return w.Flush()
+}
+
+func writeRows(w *tabwriter.Writer, tmpl *template.Template, infoList []*container.ContainerInfo, noTrunc bool) error {
+	if err := writeFormat(w, tmpl, psHeader); err != nil {
+		return err
+	}
+	for _, info := range infoList {
+		if err := writeFormat(w, tmpl, newPsRow(info, noTrunc)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func newPsRow(info *container.ContainerInfo, noTrunc bool) psRow {
+	row := psRow{
+		ID:        info.Id,
+		Names:     info.Name,
+		Image:     info.Image,
+		Command:   info.Command,
+		CreatedAt: info.CreatedTime,
+		Status:    info.Status,
+		Pid:       info.Pid,
+		Networks:  info.Network,
+		labels:    info.Labels,
 	}
+	if !noTrunc {
+		row.ID = container.ShortID(info.Id)
+		if cmd := []rune(row.Command); len(cmd) > commandTruncLength {
+			row.Command = string(cmd[:commandTruncLength-1]) + "…"
+		}
+	}
+	var ports []string
+	for _, pm := range info.PortMapping {
+		if parts := strings.Split(pm, ":"); len(parts) == 2 {
+			ports = append(ports, fmt.Sprintf("0.0.0.0:%s->%s/tcp", parts[0], parts[1]))
+		}
+	}
+	row.Ports = strings.Join(ports, ", ")
+	var labels []string
+	for k, v := range info.Labels {
+		labels = append(labels, k+"="+v)
+	}
+	sort.Strings(labels)
+	row.Labels = strings.Join(labels, ",")
+	return row
+}
+
+// 解析--filter参数，同一个key的多个值之间是或的关系，不同key之间是与的关系，多个标签条件需要同时满足
+func parsePsFilters(args []string) (map[string][]string, error) {
+	filters := map[string][]string{}
+	for _, arg := range args {
+		kv := strings.SplitN(arg, "=", 2)
+		if len(kv) != 2 || kv[1] == "" {
+			return nil, fmt.Errorf("invalid filter %s, expect key=value", arg)
+		}
+		if !psFilterKeys[kv[0]] {
+			return nil, fmt.Errorf("invalid filter %s, unknown key %s", arg, kv[0])
+		}
+		filters[kv[0]] = append(filters[kv[0]], kv[1])
+	}
+	return filters, nil
 }
 
-func getContainerInfo(containerName string) (*container.ContainerInfo, error) {
-	configFilePath := fmt.Sprintf(container.DefaultInfoLocation, containerName) + container.ConfigName
-	content, err := ioutil.ReadFile(configFilePath)
+func matchPsFilters(info *container.ContainerInfo, filters map[string][]string) bool {
+	for key, values := range filters {
+		if key == "label" {
+			if !matchLabels(info.Labels, values) {
+				return false
+			}
+			continue
+		}
+		matched := false
+		for _, value := range values {
+			if matchPsFilter(info, key, value) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+func matchPsFilter(info *container.ContainerInfo, key, value string) bool {
+	switch key {
+	case "id":
+		return strings.HasPrefix(info.Id, value)
+	case "name":
+		// 与docker一致，名字按子串匹配
+		return strings.Contains(info.Name, value)
+	case "status":
+		return info.Status == value
+	case "label":
+		return matchLabel(info.Labels, value)
+	case "network":
+		return info.Network == value
+	case "ancestor":
+		return info.Image == value
+	}
+	return false
+}
+
+// 选出批量操作的容器，包括参数中的容器名或id，以及满足--filter条件的所有容器
+func selectContainers(refs, filterArgs []string) ([]string, error) {
+	if len(refs) == 0 && len(filterArgs) == 0 {
+		return nil, fmt.Errorf("missing container name or --filter")
+	}
+	var ids []string
+	seen := map[string]bool{}
+	for _, ref := range refs {
+		id, err := store.Resolve(ref)
+		if err != nil {
+			return nil, err
+		}
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	if len(filterArgs) == 0 {
+		return ids, nil
+	}
+	filters, err := parsePsFilters(filterArgs)
 	if err != nil {
-		logrus.Errorf("read file %s error %v", configFilePath, err)
 		return nil, err
 	}
-	var info container.ContainerInfo
-	if err = json.Unmarshal(content, &info); err != nil {
-		logrus.Errorf("json.Unmarshal error %s", err)
+	for _, info := range allContainers() {
+		if !seen[info.Id] && matchPsFilters(info, filters) {
+			seen[info.Id] = true
+			ids = append(ids, info.Id)
+		}
+	}
+	return ids, nil
+}
+
+// 所有容器的信息，读取失败的容器直接跳过
+func allContainers() []*container.ContainerInfo {
+	ids, _ := store.List()
+	var infos []*container.ContainerInfo
+	for _, id := range ids {
+		if info, err := store.Get(id); err == nil {
+			infos = append(infos, info)
+		}
+	}
+	return infos
+}
+
+// 根据完整id、容器名或唯一的id前缀获取容器信息
+func getContainerInfo(ref string) (*container.ContainerInfo, error) {
+	id, err := store.Resolve(ref)
+	if err != nil {
 		return nil, err
 	}
-	return &info, nil
+	return store.Get(id)
 }
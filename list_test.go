@@ -0,0 +1,70 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/yunfeiyang1916/cloud-docker/container"
+)
+
+func TestPsFilters(t *testing.T) {
+	info := &container.ContainerInfo{
+		Id:      container.GenerateID(),
+		Name:    "web-1",
+		Status:  container.Running,
+		Image:   "busybox",
+		Network: "br0",
+		Labels:  map[string]string{"team": "infra", "env": ""},
+	}
+	cases := map[string]bool{
+		"name=web":          true,
+		"name=db":           false,
+		"status=running":    true,
+		"status=stopped":    false,
+		"label=team":        true,
+		"label=team=infra":  true,
+		"label=team=web":    false,
+		"label=owner":       false,
+		"network=br0":       true,
+		"ancestor=busybox":  true,
+		"ancestor=ubuntu":   false,
+		"id=" + info.Id[:4]: true,
+	}
+	for filter, expected := range cases {
+		filters, err := parsePsFilters([]string{filter})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if matchPsFilters(info, filters) != expected {
+			t.Errorf("filter %s expected %v", filter, expected)
+		}
+	}
+	// 相同key之间是或，不同key之间是与
+	filters, _ := parsePsFilters([]string{"status=stopped", "status=running", "name=web"})
+	if !matchPsFilters(info, filters) {
+		t.Error("expect match for status=stopped or running and name=web")
+	}
+	filters, _ = parsePsFilters([]string{"status=running", "name=db"})
+	if matchPsFilters(info, filters) {
+		t.Error("expect no match for name=db")
+	}
+	filters, _ = parsePsFilters([]string{"label=team=infra", "label=owner"})
+	if matchPsFilters(info, filters) {
+		t.Error("expect all label filters to match")
+	}
+	for _, filter := range []string{"bogus=1", "name", "name="} {
+		if _, err := parsePsFilters([]string{filter}); err == nil {
+			t.Errorf("expect error for filter %s", filter)
+		}
+	}
+}
+
+func TestPsRowTrunc(t *testing.T) {
+	info := &container.ContainerInfo{Id: container.GenerateID(), Command: "sh -c while true; do echo hello; done", PortMapping: []string{"8080:80"}}
+	row := newPsRow(info, false)
+	if row.ID != info.Id[:12] || len([]rune(row.Command)) != commandTruncLength || row.Ports != "0.0.0.0:8080->80/tcp" {
+		t.Fatalf("unexpected row %+v", row)
+	}
+	if row = newPsRow(info, true); row.ID != info.Id || row.Command != info.Command {
+		t.Fatalf("unexpected untruncated row %+v", row)
+	}
+}
@@ -2,26 +2,74 @@ package main
 
 import (
 	"fmt"
-	"github.com/sirupsen/logrus"
-	"github.com/yunfeiyang1916/cloud-docker/container"
-	"io/ioutil"
+	"github.com/yunfeiyang1916/cloud-docker/logger"
 	"os"
+	"strconv"
+	"syscall"
+	"time"
 )
 
-func logContainer(containerName string) {
-	logFilePath := fmt.Sprintf(container.DefaultInfoLocation, containerName) + container.ContainerLogFile
-	// 打开日志文件
-	file, err := os.Open(logFilePath)
+// 读取容器的日志，日志驱动需要支持读取
+func logContainer(containerName string, cfg logger.ReadConfig, timestamps bool) error {
+	info, err := getContainerInfo(containerName)
 	if err != nil {
-		logrus.Errorf("log container open file %s error %v", logFilePath, err)
-		return
+		return fmt.Errorf("get container info %s error %v", containerName, err)
 	}
-	// 将文件的内容都读取出来
-	content, err := ioutil.ReadAll(file)
+	reader, err := logger.NewReader(info.LogConfig, info.LogInfo())
 	if err != nil {
-		logrus.Errorf("log container read file %s error %v", logFilePath, err)
-		return
+		return err
 	}
-	// 输出到控制台
-	fmt.Fprint(os.Stdout, string(content))
+	cfg.Alive = func() bool {
+		return processExists(info.Pid)
+	}
+	// 按照日志的来源分别输出到标准输出和标准错误
+	return reader.ReadLogs(cfg, func(msg *logger.Message) bool {
+		out := os.Stdout
+		if msg.Stream == logger.StreamStderr {
+			out = os.Stderr
+		}
+		if timestamps {
+			fmt.Fprint(out, msg.Time.Format(time.RFC3339Nano)+" ")
+		}
+		fmt.Fprint(out, msg.Log)
+		return true
+	})
+}
+
+// 进程是否还存在
+func processExists(pid string) bool {
+	p, err := strconv.Atoi(pid)
+	if err != nil {
+		return false
+	}
+	return syscall.Kill(p, 0) == nil
+}
+
+// 解析--since和--until，可以是RFC3339格式的时间、unix时间戳或者相对现在的时长，比如10m
+func parseLogTime(value string, now time.Time) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return now.Add(-d), nil
+	}
+	if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return t, nil
+	}
+	if secs, err := strconv.ParseFloat(value, 64); err == nil {
+		return time.Unix(0, int64(secs*float64(time.Second))), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time %s, expect RFC3339 time, unix timestamp or duration", value)
+}
+
+// 解析--tail，all表示输出全部
+func parseLogTail(value string) (int, error) {
+	if value == "" || value == "all" {
+		return -1, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid tail %s, expect a non-negative number or all", value)
+	}
+	return n, nil
 }
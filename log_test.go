@@ -0,0 +1,31 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseLogTime(t *testing.T) {
+	now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	cases := map[string]time.Time{
+		"":                     {},
+		"10m":                  now.Add(-10 * time.Minute),
+		"2020-01-01T00:00:00Z": time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		"1577836800":           time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	for value, expected := range cases {
+		got, err := parseLogTime(value, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !got.Equal(expected) {
+			t.Fatalf("parse %q got %v, expected %v", value, got, expected)
+		}
+	}
+	if _, err := parseLogTime("yesterday", now); err == nil {
+		t.Fatal("expect error for invalid time")
+	}
+	if _, err := parseLogTail("-1"); err == nil {
+		t.Fatal("expect error for negative tail")
+	}
+}
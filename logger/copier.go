@@ -0,0 +1,68 @@
+package logger
+
+import (
+	"bytes"
+	"sync"
+	"time"
+)
+
+// 一行过长时不再等待换行符，直接写成一条日志
+const maxLogLineSize = 16 * 1024
+
+// Copier 把容器的输出按行切分，每行作为一条日志交给日志驱动，多个流共用同一个驱动
+type Copier struct {
+	mu     sync.Mutex
+	driver LogDriver
+}
+
+// NewCopier 创建写入driver的日志复制器
+func NewCopier(driver LogDriver) *Copier {
+	return &Copier{driver: driver}
+}
+
+// Stream 返回标记为指定流的writer，写入的数据凑满一行后才写入日志
+func (c *Copier) Stream(stream string) *Stream {
+	return &Stream{copier: c, stream: stream}
+}
+
+func (c *Copier) log(stream string, line []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.driver.Log(&Message{Stream: stream, Time: time.Now().UTC(), Log: string(line)})
+}
+
+// Stream 日志复制器中的一个流，暂存还没有遇到换行符的部分
+type Stream struct {
+	copier *Copier
+	stream string
+	buf    []byte
+}
+
+// Write 实现io.Writer
+func (s *Stream) Write(p []byte) (int, error) {
+	s.buf = append(s.buf, p...)
+	for {
+		i := bytes.IndexByte(s.buf, '\n')
+		if i < 0 && len(s.buf) < maxLogLineSize {
+			break
+		}
+		if i < 0 || i >= maxLogLineSize {
+			i = maxLogLineSize - 1
+		}
+		if err := s.copier.log(s.stream, s.buf[:i+1]); err != nil {
+			return 0, err
+		}
+		s.buf = s.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+// Flush 容器输出结束后把最后不完整的一行也写入日志
+func (s *Stream) Flush() error {
+	if len(s.buf) == 0 {
+		return nil
+	}
+	err := s.copier.log(s.stream, s.buf)
+	s.buf = nil
+	return err
+}
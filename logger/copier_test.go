@@ -0,0 +1,43 @@
+package logger
+
+import (
+	"testing"
+)
+
+// 把日志保存在内存中的驱动
+type memoryLogger struct {
+	msgs []*Message
+}
+
+func (l *memoryLogger) Name() string { return "memory" }
+
+func (l *memoryLogger) Log(msg *Message) error {
+	l.msgs = append(l.msgs, msg)
+	return nil
+}
+
+func (l *memoryLogger) Close() error { return nil }
+
+func TestCopier(t *testing.T) {
+	driver := &memoryLogger{}
+	copier := NewCopier(driver)
+	stdout := copier.Stream(StreamStdout)
+	stderr := copier.Stream(StreamStderr)
+	stdout.Write([]byte("hello "))
+	stderr.Write([]byte("oops\n"))
+	stdout.Write([]byte("world\nbye"))
+	stdout.Flush()
+	expected := []Message{
+		{Stream: StreamStderr, Log: "oops\n"},
+		{Stream: StreamStdout, Log: "hello world\n"},
+		{Stream: StreamStdout, Log: "bye"},
+	}
+	if len(driver.msgs) != len(expected) {
+		t.Fatalf("unexpected messages %+v", driver.msgs)
+	}
+	for i, msg := range driver.msgs {
+		if msg.Stream != expected[i].Stream || msg.Log != expected[i].Log || msg.Time.IsZero() {
+			t.Fatalf("unexpected message %+v", msg)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package logger
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+)
+
+const (
+	// JSONFileDriverName json-file日志驱动，每行一条json记录
+	JSONFileDriverName = "json-file"
+	// JSONFileName json-file驱动的日志文件
+	JSONFileName = "container.log"
+)
+
+// JSONFileLogger 把日志以json格式逐行写入容器状态目录中的container.log
+type JSONFileLogger struct {
+	file *rotateFile
+}
+
+func newJSONFileLogger(cfg Config, info Info) (LogDriver, error) {
+	rc, err := fileConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	file, err := openRotateFile(info.LogDir+JSONFileName, rc)
+	if err != nil {
+		return nil, err
+	}
+	return &JSONFileLogger{file: file}, nil
+}
+
+// Name 驱动名
+func (l *JSONFileLogger) Name() string {
+	return JSONFileDriverName
+}
+
+// Log 写入一条日志
+func (l *JSONFileLogger) Log(msg *Message) error {
+	buf, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	_, err = l.file.Write(append(buf, '\n'))
+	return err
+}
+
+// Close 关闭日志文件
+func (l *JSONFileLogger) Close() error {
+	return l.file.Close()
+}
+
+// json-file格式，每条记录以换行符结束
+type jsonFormat struct{}
+
+func (jsonFormat) decode(r *bufio.Reader) (*Message, int, error) {
+	line, err := r.ReadBytes('\n')
+	if err != nil {
+		// 最后一行还没有写完
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		if len(line) == 0 {
+			err = io.EOF
+		}
+		return nil, len(line), err
+	}
+	msg := &Message{}
+	if err = json.Unmarshal(line, msg); err != nil {
+		// 不是json格式的行原样输出
+		msg = &Message{Stream: StreamStdout, Log: string(line)}
+	}
+	return msg, len(line), nil
+}
+
+// 从文件末尾往前按块查找换行符，返回最后n行的起始位置和实际找到的行数
+func (jsonFormat) tailOffset(f *os.File, n int) (int64, int, error) {
+	stat, err := f.Stat()
+	if err != nil {
+		return 0, 0, err
+	}
+	size := stat.Size()
+	if n == 0 || size == 0 {
+		return size, 0, nil
+	}
+	const blockSize = 4096
+	buf := make([]byte, blockSize)
+	count := 0
+	for end := size; end > 0; end -= blockSize {
+		start := end - blockSize
+		if start < 0 {
+			start = 0
+		}
+		if _, err = f.ReadAt(buf[:end-start], start); err != nil {
+			return 0, 0, err
+		}
+		for i := end - start - 1; i >= 0; i-- {
+			// 文件末尾的换行符是最后一行的结束，不是分隔
+			if buf[i] != '\n' || start+i == size-1 {
+				continue
+			}
+			if count++; count == n {
+				return start + i + 1, count, nil
+			}
+		}
+	}
+	// 第一行前面没有换行符
+	return 0, count + 1, nil
+}
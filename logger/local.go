@@ -0,0 +1,128 @@
+package logger
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+const (
+	// LocalDriverName local日志驱动，使用紧凑的二进制格式
+	LocalDriverName = "local"
+	// LocalFileName local驱动的日志文件
+	LocalFileName = "container-local.log"
+)
+
+// local格式的每条记录为 长度(4字节) 流(1字节) 时间(8字节纳秒) 内容 长度(4字节)
+// 长度都是大端序，指的是中间部分的字节数，末尾再写一次长度是为了能从文件末尾往前读
+const (
+	localStreamStdout byte = 1
+	localStreamStderr byte = 2
+	// 流和时间占用的字节数
+	localHeaderSize = 1 + 8
+)
+
+// LocalLogger 把日志以二进制格式写入容器状态目录，比json格式更省空间，默认按20m轮转并保留5个文件
+type LocalLogger struct {
+	file *rotateFile
+}
+
+func newLocalLogger(cfg Config, info Info) (LogDriver, error) {
+	rc, err := fileConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := cfg.Config["max-size"]; !ok {
+		rc.maxSize = 20 << 20
+	}
+	if _, ok := cfg.Config["max-file"]; !ok {
+		rc.maxFile = 5
+	}
+	file, err := openRotateFile(info.LogDir+LocalFileName, rc)
+	if err != nil {
+		return nil, err
+	}
+	return &LocalLogger{file: file}, nil
+}
+
+// Name 驱动名
+func (l *LocalLogger) Name() string {
+	return LocalDriverName
+}
+
+// Log 写入一条日志
+func (l *LocalLogger) Log(msg *Message) error {
+	size := localHeaderSize + len(msg.Log)
+	buf := make([]byte, 4+size+4)
+	binary.BigEndian.PutUint32(buf[0:4], uint32(size))
+	buf[4] = localStreamStdout
+	if msg.Stream == StreamStderr {
+		buf[4] = localStreamStderr
+	}
+	binary.BigEndian.PutUint64(buf[5:13], uint64(msg.Time.UnixNano()))
+	copy(buf[13:], msg.Log)
+	binary.BigEndian.PutUint32(buf[4+size:], uint32(size))
+	_, err := l.file.Write(buf)
+	return err
+}
+
+// Close 关闭日志文件
+func (l *LocalLogger) Close() error {
+	return l.file.Close()
+}
+
+// local二进制格式
+type localFormat struct{}
+
+func (localFormat) decode(r *bufio.Reader) (*Message, int, error) {
+	header := make([]byte, 4)
+	if n, err := io.ReadFull(r, header); err != nil {
+		return nil, n, err
+	}
+	size := int(binary.BigEndian.Uint32(header))
+	if size < localHeaderSize {
+		return nil, 4, fmt.Errorf("invalid local log record size %d", size)
+	}
+	record := make([]byte, size+4)
+	if n, err := io.ReadFull(r, record); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, 4 + n, err
+	}
+	msg := &Message{
+		Stream: StreamStdout,
+		Time:   time.Unix(0, int64(binary.BigEndian.Uint64(record[1:9]))).UTC(),
+		Log:    string(record[localHeaderSize:size]),
+	}
+	if record[0] == localStreamStderr {
+		msg.Stream = StreamStderr
+	}
+	return msg, 4 + size + 4, nil
+}
+
+// 从文件末尾开始，根据每条记录末尾的长度往前跳，返回最后n条记录的起始位置和实际找到的条数
+func (localFormat) tailOffset(f *os.File, n int) (int64, int, error) {
+	stat, err := f.Stat()
+	if err != nil {
+		return 0, 0, err
+	}
+	offset := stat.Size()
+	trailer := make([]byte, 4)
+	count := 0
+	for count < n && offset >= 8 {
+		if _, err = f.ReadAt(trailer, offset-4); err != nil {
+			return 0, 0, err
+		}
+		start := offset - 4 - int64(binary.BigEndian.Uint32(trailer)) - 4
+		if start < 0 {
+			return 0, 0, fmt.Errorf("invalid local log record at %d", offset)
+		}
+		offset = start
+		count++
+	}
+	return offset, count, nil
+}
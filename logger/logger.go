@@ -0,0 +1,181 @@
+package logger
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 日志中标记输出来源的流名称
+const (
+	StreamStdout = "stdout"
+	StreamStderr = "stderr"
+)
+
+// DefaultDriver 未指定--log-driver时使用的日志驱动
+const DefaultDriver = JSONFileDriverName
+
+// Message 容器输出的一条日志，json-file驱动直接以这个格式写入，与docker的json-file日志格式相同
+type Message struct {
+	// 来源，stdout或stderr
+	Stream string `json:"stream"`
+	// 容器输出这一行的时间
+	Time time.Time `json:"time"`
+	// 输出的内容，包含末尾的换行符
+	Log string `json:"log"`
+}
+
+// Config 容器的日志配置，由--log-driver和--log-opt指定
+type Config struct {
+	Type   string            `json:"type"`
+	Config map[string]string `json:"config,omitempty"`
+}
+
+// Info 日志驱动需要的容器信息
+type Info struct {
+	ContainerName string
+	// 容器的状态目录，文件类的日志驱动把日志写在这里
+	LogDir string
+}
+
+// LogFiles 文件类日志驱动写在容器状态目录中的日志文件，包括轮转后的文件
+func LogFiles(info Info) []string {
+	var files []string
+	for _, name := range []string{JSONFileName, LocalFileName} {
+		matches, _ := filepath.Glob(info.LogDir + name + "*")
+		files = append(files, matches...)
+	}
+	return files
+}
+
+// LogDriver 日志驱动接口
+type LogDriver interface {
+	// Name 驱动名
+	Name() string
+	// Log 写入一条日志
+	Log(msg *Message) error
+	// Close 容器输出结束后关闭
+	Close() error
+}
+
+// LogReader 支持读取日志的驱动，logs命令只能用于这些驱动
+type LogReader interface {
+	// ReadLogs 按照cfg读取日志，每条日志调用一次fn，fn返回false时停止读取
+	ReadLogs(cfg ReadConfig, fn func(*Message) bool) error
+}
+
+// ReadConfig logs命令的读取参数
+type ReadConfig struct {
+	// 持续输出新写入的日志
+	Follow bool
+	// 只输出最后几条，小于0表示输出全部
+	Tail int
+	// 只输出这个时间范围内的日志，零值表示不限制
+	Since time.Time
+	Until time.Time
+	// follow模式下判断容器是否还在运行，容器退出且日志读完后结束
+	Alive func() bool
+}
+
+// 日志驱动的构造函数、支持的--log-opt和参数校验
+type driverFactory struct {
+	new      func(cfg Config, info Info) (LogDriver, error)
+	opts     []string
+	validate func(cfg Config) error
+}
+
+var drivers = map[string]driverFactory{
+	JSONFileDriverName: {new: newJSONFileLogger, opts: fileOpts, validate: validateFileConfig},
+	LocalDriverName:    {new: newLocalLogger, opts: fileOpts, validate: validateFileConfig},
+	SyslogDriverName:   {new: newSyslogLogger, opts: syslogOpts, validate: validateSyslogConfig},
+	NoneDriverName:     {new: newNoneLogger},
+}
+
+// ParseConfig 解析--log-driver和--log-opt参数，--log-opt格式为 key=value
+func ParseConfig(driver string, opts []string) (Config, error) {
+	if driver == "" {
+		driver = DefaultDriver
+	}
+	factory, ok := drivers[driver]
+	if !ok {
+		return Config{}, fmt.Errorf("unknown log driver %s, expect one of %s", driver, strings.Join(driverNames(), ", "))
+	}
+	cfg := Config{Type: driver, Config: map[string]string{}}
+	for _, opt := range opts {
+		kv := strings.SplitN(opt, "=", 2)
+		if len(kv) != 2 {
+			return Config{}, fmt.Errorf("invalid log opt %s, expect key=value", opt)
+		}
+		valid := false
+		for _, key := range factory.opts {
+			valid = valid || key == kv[0]
+		}
+		if !valid {
+			return Config{}, fmt.Errorf("unknown log opt %s for log driver %s", kv[0], driver)
+		}
+		cfg.Config[kv[0]] = kv[1]
+	}
+	// 提前校验参数的值，避免容器启动后monitor进程才发现错误
+	if factory.validate != nil {
+		if err := factory.validate(cfg); err != nil {
+			return Config{}, err
+		}
+	}
+	return cfg, nil
+}
+
+// New 创建日志驱动
+func New(cfg Config, info Info) (LogDriver, error) {
+	if cfg.Type == "" {
+		cfg.Type = DefaultDriver
+	}
+	factory, ok := drivers[cfg.Type]
+	if !ok {
+		return nil, fmt.Errorf("unknown log driver %s", cfg.Type)
+	}
+	return factory.new(cfg, info)
+}
+
+// NewReader 创建读取日志的驱动，驱动不支持读取时返回错误
+func NewReader(cfg Config, info Info) (LogReader, error) {
+	if cfg.Type == "" {
+		cfg.Type = DefaultDriver
+	}
+	switch cfg.Type {
+	case JSONFileDriverName:
+		return newFileReader(info.LogDir+JSONFileName, jsonFormat{}), nil
+	case LocalDriverName:
+		return newFileReader(info.LogDir+LocalFileName, localFormat{}), nil
+	}
+	return nil, fmt.Errorf("log driver %s does not support reading", cfg.Type)
+}
+
+func driverNames() []string {
+	var names []string
+	for name := range drivers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// 解析带单位的大小，比如10k、20m、1g，不带单位时为字节数
+func parseSize(value string) (int64, error) {
+	units := map[byte]int64{'k': 1 << 10, 'm': 1 << 20, 'g': 1 << 30}
+	number := strings.TrimSuffix(strings.ToLower(value), "b")
+	multiple := int64(1)
+	if n := len(number); n > 0 {
+		if unit, ok := units[number[n-1]]; ok {
+			multiple = unit
+			number = number[:n-1]
+		}
+	}
+	size, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || size <= 0 {
+		return 0, fmt.Errorf("invalid size %s", value)
+	}
+	return size * multiple, nil
+}
@@ -0,0 +1,88 @@
+package logger
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestParseConfig(t *testing.T) {
+	cfg, err := ParseConfig("", []string{"max-size=10m", "max-file=3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Type != JSONFileDriverName || cfg.Config["max-size"] != "10m" {
+		t.Fatalf("unexpected config %+v", cfg)
+	}
+	rc, err := fileConfig(cfg)
+	if err != nil || rc.maxSize != 10<<20 || rc.maxFile != 3 || rc.compress {
+		t.Fatalf("unexpected rotate config %+v %v", rc, err)
+	}
+	invalid := map[string][]string{
+		"json-file": {"max-size=abc"},
+		"local":     {"max-file=3"},
+		"syslog":    {"syslog-address=http://localhost"},
+		"none":      {"max-size=1m"},
+		"journald":  nil,
+	}
+	for driver, opts := range invalid {
+		if _, err = ParseConfig(driver, opts); err == nil {
+			t.Fatalf("expect error for %s %v", driver, opts)
+		}
+	}
+}
+
+// 写入的日志轮转后，按顺序读取所有文件，tail跨越多个文件
+func TestRotateAndRead(t *testing.T) {
+	for _, driver := range []string{JSONFileDriverName, LocalDriverName} {
+		dir, err := ioutil.TempDir("", "logger")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		cfg := Config{Type: driver, Config: map[string]string{"max-size": "200", "max-file": "3", "compress": "true"}}
+		info := Info{ContainerName: "test", LogDir: dir + "/"}
+		l, err := New(cfg, info)
+		if err != nil {
+			t.Fatal(err)
+		}
+		start := time.Now()
+		for i := 0; i < 20; i++ {
+			l.Log(&Message{Stream: StreamStdout, Time: start.Add(time.Duration(i) * time.Second), Log: fmt.Sprintf("line %d\n", i)})
+		}
+		l.Close()
+		files, _ := ioutil.ReadDir(dir)
+		if len(files) != 3 {
+			t.Fatalf("%s: expect 3 log files, got %d", driver, len(files))
+		}
+		reader, err := NewReader(cfg, info)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var all []string
+		reader.ReadLogs(ReadConfig{Tail: -1}, func(msg *Message) bool {
+			all = append(all, msg.Log)
+			return true
+		})
+		if len(all) == 0 || all[len(all)-1] != "line 19\n" {
+			t.Fatalf("%s: unexpected logs %q", driver, all)
+		}
+		// 最旧的日志被删除，剩下的是连续的
+		first := 20 - len(all)
+		for i, line := range all {
+			if line != fmt.Sprintf("line %d\n", first+i) {
+				t.Fatalf("%s: unexpected logs %q", driver, all)
+			}
+		}
+		var tail []string
+		reader.ReadLogs(ReadConfig{Tail: len(all) - 1, Until: start.Add(18 * time.Second)}, func(msg *Message) bool {
+			tail = append(tail, msg.Log)
+			return true
+		})
+		if len(tail) != len(all)-2 || tail[0] != all[1] {
+			t.Fatalf("%s: unexpected tail %q", driver, tail)
+		}
+	}
+}
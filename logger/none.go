@@ -0,0 +1,26 @@
+package logger
+
+// NoneDriverName none日志驱动，丢弃容器的所有输出
+const NoneDriverName = "none"
+
+// NoneLogger 不保存日志，容器的输出只能通过attach查看
+type NoneLogger struct{}
+
+func newNoneLogger(cfg Config, info Info) (LogDriver, error) {
+	return &NoneLogger{}, nil
+}
+
+// Name 驱动名
+func (l *NoneLogger) Name() string {
+	return NoneDriverName
+}
+
+// Log 丢弃日志
+func (l *NoneLogger) Log(msg *Message) error {
+	return nil
+}
+
+// Close 关闭
+func (l *NoneLogger) Close() error {
+	return nil
+}
@@ -0,0 +1,216 @@
+package logger
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// follow模式下检查日志文件是否有新内容的间隔
+const pollInterval = 200 * time.Millisecond
+
+// 文件类日志驱动的存储格式
+type logFormat interface {
+	// 从r中解码下一条记录，返回消耗的字节数，没有数据时返回io.EOF，记录还没写完时返回io.ErrUnexpectedEOF
+	decode(r *bufio.Reader) (*Message, int, error)
+	// 文件中最后n条记录的起始位置和实际找到的条数
+	tailOffset(f *os.File, n int) (int64, int, error)
+}
+
+// 读取文件类日志驱动写入的日志，包括轮转后的文件
+type fileReader struct {
+	path   string
+	format logFormat
+}
+
+func newFileReader(path string, format logFormat) *fileReader {
+	return &fileReader{path: path, format: format}
+}
+
+// ReadLogs 先读取轮转后的旧文件，再读取当前文件，follow模式下持续读取新写入的日志
+func (r *fileReader) ReadLogs(cfg ReadConfig, fn func(*Message) bool) error {
+	file, err := os.Open(r.path)
+	if err != nil {
+		return err
+	}
+	// 日志轮转后file会指向新的文件
+	defer func() {
+		file.Close()
+	}()
+	// 从文件末尾往前找到最后几条的起始位置，不需要读取整个文件，当前文件中不够时再从轮转后的文件中补足
+	var offset int64
+	older := -1
+	if cfg.Tail >= 0 {
+		var count int
+		if offset, count, err = r.format.tailOffset(file, cfg.Tail); err != nil {
+			return err
+		}
+		older = cfg.Tail - count
+	}
+	if older != 0 {
+		more, err := r.readRotated(older, cfg, fn)
+		if err != nil || !more {
+			return err
+		}
+	}
+	if _, err = file.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	reader := bufio.NewReader(file)
+	// 轮转后的新文件，旧文件读完后再切换过去
+	var next *os.File
+	for {
+		msg, n, err := r.format.decode(reader)
+		if err == nil {
+			offset += int64(n)
+			if !emit(msg, cfg, fn) {
+				return nil
+			}
+			continue
+		}
+		if err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		// 读到文件末尾，最后一条还没写完时等写完后从这条的开头重新读
+		if !cfg.Follow {
+			return nil
+		}
+		if next != nil && err == io.EOF {
+			file.Close()
+			file, next, offset = next, nil, 0
+			reader.Reset(file)
+			continue
+		}
+		// 容器已经退出，不会再有新的日志
+		if err == io.EOF && cfg.Alive != nil && !cfg.Alive() {
+			return nil
+		}
+		time.Sleep(pollInterval)
+		if next == nil {
+			if next, err = r.checkRotated(file, offset); err != nil {
+				return err
+			}
+			if next == file {
+				// 文件被截断，从头开始读
+				next, offset = nil, 0
+			}
+		}
+		if _, err = file.Seek(offset, io.SeekStart); err != nil {
+			return err
+		}
+		reader.Reset(file)
+	}
+}
+
+// 过滤时间范围，返回false表示已经超过了until指定的时间，不需要再继续读取
+func emit(msg *Message, cfg ReadConfig, fn func(*Message) bool) bool {
+	if !cfg.Until.IsZero() && msg.Time.After(cfg.Until) {
+		return false
+	}
+	if !cfg.Since.IsZero() && msg.Time.Before(cfg.Since) {
+		return true
+	}
+	return fn(msg)
+}
+
+// 按从旧到新的顺序读取轮转后的文件，边解码边输出，不把整个文件读入内存
+// older大于0时只输出最后older条，用一个环形缓冲保留最近的记录；返回false表示不需要再继续读取
+func (r *fileReader) readRotated(older int, cfg ReadConfig, fn func(*Message) bool) (bool, error) {
+	var names []string
+	for i := 1; ; i++ {
+		// 轮转时没有压缩的文件不带.gz后缀
+		name := rotatedName(r.path, i, true)
+		if !fileExists(name) {
+			if name = rotatedName(r.path, i, false); !fileExists(name) {
+				break
+			}
+		}
+		names = append([]string{name}, names...)
+	}
+	var ring []*Message
+	var count int
+	if older > 0 {
+		ring = make([]*Message, older)
+	}
+	for _, name := range names {
+		more, err := r.decodeFile(name, func(msg *Message) bool {
+			if ring == nil {
+				return emit(msg, cfg, fn)
+			}
+			ring[count%older] = msg
+			count++
+			return true
+		})
+		if err != nil || !more {
+			return more, err
+		}
+	}
+	start := 0
+	if count > older {
+		start = count - older
+	}
+	for i := start; i < count; i++ {
+		if !emit(ring[i%older], cfg, fn) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// 依次解码一个轮转后的文件中的记录，.gz后缀的文件边解压边读
+func (r *fileReader) decodeFile(name string, fn func(*Message) bool) (bool, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		// 读取过程中文件可能被轮转删除
+		return true, nil
+	}
+	defer f.Close()
+	var src io.Reader = f
+	if strings.HasSuffix(name, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return false, err
+		}
+		defer gz.Close()
+		src = gz
+	}
+	reader := bufio.NewReader(src)
+	for {
+		msg, _, err := r.format.decode(reader)
+		if err != nil {
+			return true, nil
+		}
+		if !fn(msg) {
+			return false, nil
+		}
+	}
+}
+
+// 检查日志文件是否被轮转或截断
+// 轮转后路径指向了新文件，返回打开的新文件；被截断时文件变小，返回原来的文件；没有变化时返回nil
+func (r *fileReader) checkRotated(file *os.File, offset int64) (*os.File, error) {
+	current, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	latest, err := os.Stat(r.path)
+	if err != nil {
+		// 轮转时新文件可能还没有创建
+		return nil, nil
+	}
+	if !os.SameFile(current, latest) {
+		return os.Open(r.path)
+	}
+	if latest.Size() < offset {
+		return file, nil
+	}
+	return nil, nil
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
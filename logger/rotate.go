@@ -0,0 +1,181 @@
+package logger
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/sirupsen/logrus"
+)
+
+// 文件类日志驱动支持的--log-opt
+var fileOpts = []string{"max-size", "max-file", "compress"}
+
+// 文件类日志驱动的轮转配置
+type rotateConfig struct {
+	// 单个日志文件的最大字节数，小于等于0表示不轮转
+	maxSize int64
+	// 最多保留的日志文件个数，包括正在写入的文件
+	maxFile int
+	// 是否用gzip压缩轮转后的文件，默认不压缩
+	compress bool
+}
+
+// 解析max-size、max-file和compress参数
+func fileConfig(cfg Config) (rotateConfig, error) {
+	rc := rotateConfig{maxFile: 1}
+	var err error
+	if value, ok := cfg.Config["max-size"]; ok {
+		if rc.maxSize, err = parseSize(value); err != nil {
+			return rc, fmt.Errorf("invalid max-size %s", value)
+		}
+	}
+	if value, ok := cfg.Config["max-file"]; ok {
+		if rc.maxFile, err = strconv.Atoi(value); err != nil || rc.maxFile < 1 {
+			return rc, fmt.Errorf("invalid max-file %s, expect a positive number", value)
+		}
+		if rc.maxFile > 1 && rc.maxSize <= 0 {
+			return rc, fmt.Errorf("max-file can not be used without max-size")
+		}
+	}
+	if value, ok := cfg.Config["compress"]; ok {
+		if rc.compress, err = strconv.ParseBool(value); err != nil {
+			return rc, fmt.Errorf("invalid compress %s, expect true or false", value)
+		}
+	}
+	return rc, nil
+}
+
+func validateFileConfig(cfg Config) error {
+	_, err := fileConfig(cfg)
+	return err
+}
+
+// 轮转后的第n个文件，n越大越旧
+func rotatedName(path string, n int, compress bool) string {
+	name := path + "." + strconv.Itoa(n)
+	if compress {
+		name += ".gz"
+	}
+	return name
+}
+
+// 按大小轮转的日志文件，每次Write都是一条完整的记录，不会把一条记录拆到两个文件中
+type rotateFile struct {
+	path string
+	cfg  rotateConfig
+	f    *os.File
+	size int64
+	// 正在后台压缩的轮转文件，压缩完成后关闭
+	compressing chan struct{}
+}
+
+func openRotateFile(path string, cfg rotateConfig) (*rotateFile, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &rotateFile{path: path, cfg: cfg, f: f, size: stat.Size()}, nil
+}
+
+func (r *rotateFile) Write(p []byte) (int, error) {
+	if r.cfg.maxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.cfg.maxSize {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// Close 等待后台的压缩完成后再关闭
+func (r *rotateFile) Close() error {
+	r.waitCompress()
+	return r.f.Close()
+}
+
+func (r *rotateFile) waitCompress() {
+	if r.compressing != nil {
+		<-r.compressing
+		r.compressing = nil
+	}
+}
+
+// 轮转日志文件，依次把 .1 改名为 .2，当前文件改名为 .1，超出max-file的最旧的文件被删除
+// 只保留一个文件时直接截断当前文件
+// 压缩在后台进行，不阻塞容器输出的写入，下一次轮转前等待上一次的压缩完成
+func (r *rotateFile) rotate() error {
+	r.f.Close()
+	flags := os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	if r.cfg.maxFile > 1 {
+		r.waitCompress()
+		// 压缩失败的文件保留原来的名字，两种名字都要处理
+		for _, compressed := range []bool{true, false} {
+			os.Remove(rotatedName(r.path, r.cfg.maxFile-1, compressed))
+			for i := r.cfg.maxFile - 2; i >= 1; i-- {
+				os.Rename(rotatedName(r.path, i, compressed), rotatedName(r.path, i+1, compressed))
+			}
+		}
+		first := rotatedName(r.path, 1, false)
+		if err := os.Rename(r.path, first); err != nil {
+			return err
+		}
+		if r.cfg.compress {
+			done := make(chan struct{})
+			r.compressing = done
+			go func() {
+				defer close(done)
+				if err := compressFile(first); err != nil {
+					logrus.Errorf("compress rotated log %s error %v", first, err)
+				}
+			}()
+		}
+	} else {
+		flags |= os.O_TRUNC
+	}
+	f, err := os.OpenFile(r.path, flags, 0644)
+	if err != nil {
+		return err
+	}
+	r.f = f
+	r.size = 0
+	return nil
+}
+
+// 把文件压缩为同名的.gz文件并删除原文件
+func compressFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	tmp := path + ".gz.tmp"
+	dst, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(dst)
+	_, err = io.Copy(gz, src)
+	if closeErr := gz.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err = os.Rename(tmp, path+".gz"); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
@@ -0,0 +1,78 @@
+package logger
+
+import (
+	"fmt"
+	"log/syslog"
+	"net/url"
+)
+
+// SyslogDriverName syslog日志驱动，日志发送给本机或远程的syslog服务，不支持logs命令读取
+const SyslogDriverName = "syslog"
+
+// syslog驱动支持的--log-opt
+var syslogOpts = []string{"syslog-address", "tag"}
+
+// SyslogLogger 标准输出以info级别、标准错误以err级别发送，tag默认为容器名
+type SyslogLogger struct {
+	writer *syslog.Writer
+}
+
+// 解析syslog-address，为空时使用本机的/dev/log，也可以是 unix:///path、udp://host:port 或 tcp://host:port
+func parseSyslogAddress(address string) (string, string, error) {
+	if address == "" {
+		return "", "", nil
+	}
+	u, err := url.Parse(address)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid syslog address %s: %v", address, err)
+	}
+	switch u.Scheme {
+	case "unix", "unixgram":
+		return u.Scheme, u.Path, nil
+	case "udp", "tcp":
+		if u.Port() == "" {
+			return u.Scheme, u.Host + ":514", nil
+		}
+		return u.Scheme, u.Host, nil
+	}
+	return "", "", fmt.Errorf("invalid syslog address %s, expect unix://, udp:// or tcp://", address)
+}
+
+func validateSyslogConfig(cfg Config) error {
+	_, _, err := parseSyslogAddress(cfg.Config["syslog-address"])
+	return err
+}
+
+func newSyslogLogger(cfg Config, info Info) (LogDriver, error) {
+	network, raddr, err := parseSyslogAddress(cfg.Config["syslog-address"])
+	if err != nil {
+		return nil, err
+	}
+	tag := cfg.Config["tag"]
+	if tag == "" {
+		tag = info.ContainerName
+	}
+	writer, err := syslog.Dial(network, raddr, syslog.LOG_DAEMON|syslog.LOG_INFO, tag)
+	if err != nil {
+		return nil, fmt.Errorf("connect to syslog error %v", err)
+	}
+	return &SyslogLogger{writer: writer}, nil
+}
+
+// Name 驱动名
+func (l *SyslogLogger) Name() string {
+	return SyslogDriverName
+}
+
+// Log 发送一条日志
+func (l *SyslogLogger) Log(msg *Message) error {
+	if msg.Stream == StreamStderr {
+		return l.writer.Err(msg.Log)
+	}
+	return l.writer.Info(msg.Log)
+}
+
+// Close 断开与syslog服务的连接
+func (l *SyslogLogger) Close() error {
+	return l.writer.Close()
+}
@@ -3,7 +3,10 @@ package main
 import (
 	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
+	"github.com/yunfeiyang1916/cloud-docker/container"
+	"github.com/yunfeiyang1916/cloud-docker/network"
 	"os"
+	"path/filepath"
 )
 
 func main() {
@@ -14,20 +17,70 @@ func main() {
 			   Enjoy it, just for fun.`
 	app.Commands = []cli.Command{
 		initCommand,
+		pauseCommand,
+		monitorCommand,
 		runCommand,
 		commitCommand,
 		listCommand,
 		logCommand,
 		execCommand,
+		attachCommand,
+		inspectCommand,
+		infoCommand,
 		stopCommand,
 		removeCommand,
+		containerCommand,
+		systemCommand,
 		networkCommand,
+		volumeCommand,
+		podCommand,
+	}
+
+	app.Flags = []cli.Flag{
+		cli.StringFlag{Name: "config", Value: defaultConfigFile, Usage: "location of the config file"},
+		cli.StringFlag{Name: "data-root", Usage: "root directory of images, container layers and volumes"},
+		cli.StringFlag{Name: "exec-root", Usage: "root directory of runtime state"},
+		cli.StringFlag{Name: "log-level", Usage: "logging level: debug, info, warn or error"},
+		cli.StringFlag{Name: "log-format", Usage: "logging format: json or text"},
 	}
 
 	app.Before = func(ctx *cli.Context) error {
-		// Log as JSON instead of the default ASCII formatter.
 		logrus.SetFormatter(&logrus.JSONFormatter{})
 		logrus.SetOutput(os.Stdout)
+		configFile, err := filepath.Abs(ctx.GlobalString("config"))
+		if err != nil {
+			return err
+		}
+		cfg := &daemonConfig{}
+		// init进程和exec命令fork出的子进程在容器的namespace中，不一定能读取宿主机的配置文件，
+		// 父进程已经把生效的配置通过命令行参数传了过来
+		if !inContainerNamespace(ctx) {
+			if cfg, err = loadConfig(configFile); err != nil {
+				return err
+			}
+		}
+		// 命令行参数优先于配置文件
+		if ctx.GlobalIsSet("log-level") {
+			cfg.LogLevel = ctx.GlobalString("log-level")
+		}
+		if ctx.GlobalIsSet("log-format") {
+			cfg.LogFormat = ctx.GlobalString("log-format")
+		}
+		if err = setupLogging(cfg.LogLevel, cfg.LogFormat); err != nil {
+			return err
+		}
+		// exec命令fork出的子进程已经进入了容器的mnt namespace，看到的是容器内的文件，不需要设置宿主机上的目录
+		if os.Getenv(EnvExecPID) != "" {
+			return nil
+		}
+		execRoot, dataRoot, err := resolveRoots(cfg, ctx.GlobalString("exec-root"), ctx.GlobalString("data-root"))
+		if err != nil {
+			return err
+		}
+		container.SetRoots(execRoot, dataRoot)
+		container.SetSelfArgs(cfg.selfArgs(configFile)...)
+		network.SetRunRoot(execRoot)
+		daemonCfg = cfg
 		return nil
 	}
 
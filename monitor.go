@@ -0,0 +1,465 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/yunfeiyang1916/cloud-docker/container"
+	"github.com/yunfeiyang1916/cloud-docker/logger"
+	"github.com/yunfeiyang1916/cloud-docker/store"
+)
+
+// 每个容器一个monitor进程，持有容器的标准输入输出，run命令退出后容器的输出仍然写入日志，也可以随时attach
+type monitor struct {
+	// 容器的标准输入，tty模式下是伪终端的master端，未开启-i时为nil
+	stdin io.Writer
+	// 非tty模式下容器标准输入管道的写端，客户端的输入结束时关闭，容器进程读到EOF
+	stdinPipe *os.File
+	closeOnce sync.Once
+	pty       *os.File
+	mu        sync.Mutex
+	// 已连接的attach客户端
+	clients map[net.Conn]*attachClient
+	// 容器已经退出，不再接受新的客户端
+	closed bool
+	driver logger.LogDriver
+	logs   *logger.Copier
+	// 容器init进程在宿主机上的pid
+	initPid int
+}
+
+// 每个attach客户端最多缓存的输出帧数，写满说明客户端读得太慢，断开它而不是阻塞容器的输出
+const clientBufferFrames = 64
+
+// 容器退出后等待客户端读完缓存的输出的时间
+const clientFlushTimeout = 5 * time.Second
+
+type outputFrame struct {
+	stream byte
+	data   []byte
+}
+
+// attachClient 一个attach客户端，输出由单独的goroutine写入，慢客户端不会影响容器和其他客户端
+type attachClient struct {
+	conn net.Conn
+	out  chan outputFrame
+	// 客户端断开时关闭，写入goroutine随之退出
+	done      chan struct{}
+	closeOnce sync.Once
+	// 写入goroutine退出时关闭
+	finished chan struct{}
+}
+
+func newAttachClient(conn net.Conn) *attachClient {
+	c := &attachClient{
+		conn:     conn,
+		out:      make(chan outputFrame, clientBufferFrames),
+		done:     make(chan struct{}),
+		finished: make(chan struct{}),
+	}
+	go c.writeLoop()
+	return c
+}
+
+// 依次把缓存的输出写给客户端，out被关闭时写完剩余的输出后断开连接
+func (c *attachClient) writeLoop() {
+	defer close(c.finished)
+	defer c.conn.Close()
+	for {
+		select {
+		case f, ok := <-c.out:
+			if !ok {
+				return
+			}
+			if writeFrame(c.conn, f.stream, f.data) != nil {
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// 不阻塞地缓存一帧输出，缓存已满时返回false
+func (c *attachClient) send(f outputFrame) bool {
+	select {
+	case c.out <- f:
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *attachClient) close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.conn.Close()
+	})
+}
+
+// attach协议中的流对应的日志流名称
+var logStreams = map[byte]string{
+	streamStdout: logger.StreamStdout,
+	streamStderr: logger.StreamStderr,
+}
+
+// run命令通过monitor进程的标准输入传给它的容器配置
+type monitorSpec struct {
+	Info *container.ContainerInfo `json:"info"`
+	// 需要加入的已有namespace
+	NsPaths map[string]string `json:"nsPaths,omitempty"`
+}
+
+// monitor进程启动容器后通过ready管道返回的结果
+type monitorReply struct {
+	// 容器init进程在宿主机上的pid
+	Pid   int    `json:"pid,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// monitorProcess run命令一侧的monitor进程
+type monitorProcess struct {
+	// 容器init进程在宿主机上的pid
+	InitPid int
+	// monitor进程的标准输入，关闭后monitor才开始等待容器退出
+	control *os.File
+	// monitor进程退出时关闭，读到EOF说明容器已经退出并记录了退出码
+	ready *os.File
+}
+
+// Release run命令已经记录了容器的状态或放弃启动容器，之后由monitor等待容器退出
+func (p *monitorProcess) Release() {
+	p.control.Close()
+}
+
+// Wait 等待monitor记录容器的退出码并退出
+func (p *monitorProcess) Wait() {
+	io.Copy(ioutil.Discard, p.ready)
+	p.ready.Close()
+}
+
+// Close 不再等待monitor进程
+func (p *monitorProcess) Close() {
+	p.ready.Close()
+}
+
+// 启动容器的monitor进程，由它创建并持有容器的init进程，readPipe是init进程读取用户命令的管道
+// 容器进程是monitor的子进程，run命令退出后monitor仍然可以等待它退出并记录退出码，
+// 容器的标准输入输出也由monitor持有，输出写入日志，并可以随时attach
+func startMonitor(info *container.ContainerInfo, nsPaths map[string]string, readPipe *os.File) (*monitorProcess, error) {
+	readyRead, readyWrite, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer readyWrite.Close()
+	controlRead, controlWrite, err := os.Pipe()
+	if err != nil {
+		readyRead.Close()
+		return nil, err
+	}
+	defer controlRead.Close()
+	cmd := container.SelfCommand("monitor")
+	cmd.Stdin = controlRead
+	// 依次为init进程读取命令的管道和返回结果的管道
+	cmd.ExtraFiles = []*os.File{readPipe, readyWrite}
+	// 脱离run命令的会话，run命令退出后继续运行
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err = cmd.Start(); err != nil {
+		readyRead.Close()
+		controlWrite.Close()
+		return nil, err
+	}
+	cmd.Process.Release()
+	var reply monitorReply
+	if err = json.NewEncoder(controlWrite).Encode(&monitorSpec{Info: info, NsPaths: nsPaths}); err != nil {
+		err = fmt.Errorf("send container config to monitor error %v", err)
+	} else if err = json.NewDecoder(readyRead).Decode(&reply); err != nil {
+		err = fmt.Errorf("monitor process exited before ready: %v", err)
+	} else if reply.Error != "" {
+		err = fmt.Errorf("%s", reply.Error)
+	}
+	if err != nil {
+		controlWrite.Close()
+		readyRead.Close()
+		return nil, err
+	}
+	return &monitorProcess{InitPid: reply.Pid, control: controlWrite, ready: readyRead}, nil
+}
+
+// 内部命令monitor的执行函数，启动容器进程，容器退出并且所有输出都结束后退出
+func runMonitor() error {
+	var spec monitorSpec
+	// 标准输入先是容器的配置，之后run命令关闭它表示已经记录了容器的状态
+	decoder := json.NewDecoder(os.Stdin)
+	if err := decoder.Decode(&spec); err != nil {
+		return fmt.Errorf("read container config error %v", err)
+	}
+	info := spec.Info
+	readPipe := os.NewFile(3, "init")
+	ready := os.NewFile(4, "ready")
+	defer ready.Close()
+	// 继承来的文件描述符没有设置close-on-exec，不能泄露给容器进程
+	syscall.CloseOnExec(int(ready.Fd()))
+	reply := func(r monitorReply) {
+		if err := json.NewEncoder(ready).Encode(&r); err != nil {
+			logrus.Errorf("reply to run error %v", err)
+		}
+	}
+
+	parent, cio, err := container.NewParentProcess(info.Tty, info, info.Env, spec.NsPaths, readPipe)
+	if err != nil {
+		reply(monitorReply{Error: err.Error()})
+		return err
+	}
+	// 这里的 Start 方法是真正执行前面创建好的 command 的调用，它首先会克隆出来 namespace 隔离的进程，
+	// 然后在子进程中，调用/proc/self/exe ，也就是调用自己，发送 init 参数，调用我们写的init方法，去初始化容器的一些资源。
+	if err := parent.Start(); err != nil {
+		reply(monitorReply{Error: fmt.Sprintf("start container process error %v", err)})
+		return err
+	}
+	readPipe.Close()
+	cio.CloseChildSide()
+	m := &monitor{clients: map[net.Conn]*attachClient{}}
+	outputs := map[byte]*os.File{}
+	if cio.Pty != nil {
+		m.pty = cio.Pty.Master
+		m.stdin = m.pty
+		outputs[streamStdout] = m.pty
+	} else {
+		// 未开启-i时容器没有标准输入，attach客户端的输入直接丢弃
+		if cio.Stdin != nil {
+			m.stdin = cio.Stdin
+			m.stdinPipe = cio.Stdin
+		}
+		outputs[streamStdout] = cio.Stdout
+		outputs[streamStderr] = cio.Stderr
+	}
+	listener, err := m.setup(parent, info, spec.NsPaths)
+	if err != nil {
+		parent.Process.Kill()
+		parent.Wait()
+		reply(monitorReply{Error: err.Error()})
+		return err
+	}
+	defer m.driver.Close()
+	reply(monitorReply{Pid: m.initPid})
+
+	// run命令记录了容器的状态之后才回收容器进程，容器提前退出时也不会被之后写入的状态覆盖
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		io.Copy(ioutil.Discard, os.Stdin)
+		recordExit(info.Id, m.initPid, parent.Wait())
+	}()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			if c := m.addClient(conn); c != nil {
+				go m.serve(c)
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for stream, f := range outputs {
+		wg.Add(1)
+		go func(stream byte, f *os.File) {
+			defer wg.Done()
+			m.copyOutput(stream, f)
+		}(stream, f)
+	}
+	// 容器退出后等客户端读完剩余的输出再断开，关闭listener时会删除socket文件
+	wg.Wait()
+	listener.Close()
+	m.closeClients()
+	<-exited
+	return nil
+}
+
+// 找到容器的init进程，创建日志驱动并监听attach socket
+func (m *monitor) setup(parent *exec.Cmd, info *container.ContainerInfo, nsPaths map[string]string) (net.Listener, error) {
+	// 加入已有pid namespace或创建time namespace时，真正的init进程是parent的子进程
+	initPid, err := container.InitPid(parent, info, nsPaths)
+	if err != nil {
+		return nil, fmt.Errorf("get container init pid error %v", err)
+	}
+	m.initPid = initPid
+	// 容器的输出按行交给日志驱动，分别标记stdout和stderr
+	driver, err := logger.New(info.LogConfig, info.LogInfo())
+	if err != nil {
+		return nil, fmt.Errorf("create log driver error %v", err)
+	}
+	m.driver = driver
+	m.logs = logger.NewCopier(driver)
+	socketPath := fmt.Sprintf(container.DefaultInfoLocation, info.Id) + container.AttachSocket
+	os.Remove(socketPath)
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		driver.Close()
+		return nil, fmt.Errorf("listen %s error %v", socketPath, err)
+	}
+	return listener, nil
+}
+
+// 记录容器进程的退出码，被信号终止时与shell的约定一致，退出码为128加信号值
+// 容器已经被删除时不需要记录
+func recordExit(id string, initPid int, err error) {
+	exitCode := 0
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		status := exitErr.Sys().(syscall.WaitStatus)
+		if status.Signaled() {
+			exitCode = 128 + int(status.Signal())
+		} else {
+			exitCode = status.ExitStatus()
+		}
+	} else if err != nil {
+		logrus.Errorf("wait container %s error %v", container.ShortID(id), err)
+		exitCode = unknownExitCode
+	}
+	if err = store.Update(id, func(info *container.ContainerInfo) error {
+		if info.Status == container.Running {
+			info.Status = container.Stop
+			info.Pid = ""
+		}
+		info.ExitCode = exitCode
+		return nil
+	}); err != nil {
+		logrus.Debugf("record exit code of container %s error %v", container.ShortID(id), err)
+	}
+}
+
+// 把容器的输出写入日志，并转发给所有已连接的客户端
+// tty模式下容器进程全部退出后读取伪终端返回EIO，管道则返回EOF
+func (m *monitor) copyOutput(stream byte, f *os.File) {
+	logStream := m.logs.Stream(logStreams[stream])
+	defer logStream.Flush()
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := f.Read(buf)
+		if n > 0 {
+			if _, err := logStream.Write(buf[:n]); err != nil {
+				logrus.Errorf("write container log error %v", err)
+			}
+			// buf会被下一次读取覆盖，客户端的写入goroutine需要自己的一份
+			frame := outputFrame{stream: stream, data: append([]byte(nil), buf[:n]...)}
+			for _, c := range m.snapshotClients() {
+				if !c.send(frame) {
+					logrus.Warnf("attach client is too slow, disconnect it")
+					m.removeClient(c)
+				}
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// 记录新连接的客户端，容器已经退出时直接断开
+func (m *monitor) addClient(conn net.Conn) *attachClient {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		conn.Close()
+		return nil
+	}
+	c := newAttachClient(conn)
+	m.clients[conn] = c
+	return c
+}
+
+func (m *monitor) removeClient(c *attachClient) {
+	m.mu.Lock()
+	delete(m.clients, c.conn)
+	m.mu.Unlock()
+	c.close()
+}
+
+// 写输出时不持有锁，客户端的连接和断开不会等待输出
+func (m *monitor) snapshotClients() []*attachClient {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	clients := make([]*attachClient, 0, len(m.clients))
+	for _, c := range m.clients {
+		clients = append(clients, c)
+	}
+	return clients
+}
+
+// 所有输出都已经缓存后调用，客户端写完缓存的输出后断开，超时未读完的客户端直接断开
+func (m *monitor) closeClients() {
+	m.mu.Lock()
+	m.closed = true
+	clients := m.clients
+	m.clients = map[net.Conn]*attachClient{}
+	m.mu.Unlock()
+	deadline := time.Now().Add(clientFlushTimeout)
+	for _, c := range clients {
+		c.conn.SetWriteDeadline(deadline)
+		close(c.out)
+	}
+	for _, c := range clients {
+		<-c.finished
+		c.close()
+	}
+}
+
+// 客户端的输入结束，关闭容器标准输入管道的写端，容器进程读到EOF
+// tty模式下由用户在终端中输入EOF字符，不需要处理
+func (m *monitor) closeStdin() {
+	if m.stdinPipe == nil {
+		return
+	}
+	m.closeOnce.Do(func() {
+		if err := m.stdinPipe.Close(); err != nil {
+			logrus.Errorf("close container stdin error %v", err)
+		}
+	})
+}
+
+// 处理客户端发来的输入和窗口大小
+func (m *monitor) serve(c *attachClient) {
+	defer m.removeClient(c)
+	for {
+		stream, data, err := readFrame(c.conn)
+		if err != nil {
+			return
+		}
+		switch stream {
+		case streamStdin:
+			if m.stdin == nil {
+				continue
+			}
+			// 空的输入帧表示客户端的标准输入已经结束
+			if len(data) == 0 {
+				m.closeStdin()
+				continue
+			}
+			// 标准输入已经关闭后其他客户端的输入直接丢弃
+			if _, err = m.stdin.Write(data); errors.Is(err, os.ErrClosed) {
+				continue
+			} else if err != nil {
+				logrus.Errorf("write container stdin error %v", err)
+				return
+			}
+		case streamResize:
+			if m.pty != nil && len(data) == 4 {
+				container.SetPtySize(m.pty, binary.BigEndian.Uint16(data[0:2]), binary.BigEndian.Uint16(data[2:4]))
+			}
+		}
+	}
+}
@@ -168,16 +168,16 @@ func (d *BridgeNetworkDriver) Connect(network *Network, endpoint *Endpoint) erro
 
 	// 创建Veth接口的配置
 	la := netlink.NewLinkAttrs()
-	// 由于linux接口名的限制，名字取endpoint ID的前5位
-	la.Name = endpoint.ID[:5]
+	// 由于linux接口名的限制，名字取endpoint ID的前缀
+	la.Name = hostVethName(endpoint.ID)
 	// 通过设置Veth接口的master属性，设置这个veth的一端挂载到对应的linux网桥上
 	la.MasterIndex = br.Attrs().Index
 
 	// 创建veth对象，通过PeerName配置veth另外一端的接口名
-	// 配置veth另外一端的名字cif-{endpoint ID的前5位}
+	// 配置veth另外一端的名字cif-{endpoint ID的前缀}
 	endpoint.Device = netlink.Veth{
 		LinkAttrs: la,
-		PeerName:  "cif-" + endpoint.ID[:5],
+		PeerName:  peerVethName(endpoint.ID),
 	}
 	// 调用LinkAdd创建这个veth接口
 	// 因为上面指定了link的MasterIndex是网络对应的Linux网桥
@@ -193,5 +193,30 @@ func (d *BridgeNetworkDriver) Connect(network *Network, endpoint *Endpoint) erro
 }
 
 func (d *BridgeNetworkDriver) Disconnect(network Network, endpoint *Endpoint) error {
-	return nil
+	// 容器的网络空间销毁时veth会一起被删除，容器还在运行时需要主动删除宿主机一端
+	link, err := netlink.LinkByName(hostVethName(endpoint.ID))
+	if err != nil {
+		return nil
+	}
+	return netlink.LinkDel(link)
+}
+
+// linux接口名最长15个字节，endpoint ID以64位的容器id开头，取尽量长的前缀避免不同容器的veth重名
+const ifNameMax = 15
+
+// 宿主机一端的veth名
+func hostVethName(endpointID string) string {
+	return idPrefix(endpointID, ifNameMax)
+}
+
+// 容器一端的veth名
+func peerVethName(endpointID string) string {
+	return "cif-" + idPrefix(endpointID, ifNameMax-len("cif-"))
+}
+
+func idPrefix(id string, n int) string {
+	if len(id) > n {
+		return id[:n]
+	}
+	return id
 }
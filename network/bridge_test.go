@@ -0,0 +1,23 @@
+package network
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestVethNames(t *testing.T) {
+	// 前5位相同的两个容器不能得到同名的veth
+	a := "abcde" + strings.Repeat("0", 59) + "-net"
+	b := "abcde1" + strings.Repeat("0", 58) + "-net"
+	for _, name := range []func(string) string{hostVethName, peerVethName} {
+		if len(name(a)) > ifNameMax {
+			t.Errorf("%s is longer than %d bytes", name(a), ifNameMax)
+		}
+		if name(a) == name(b) {
+			t.Errorf("veth name %s is shared by two endpoints", name(a))
+		}
+	}
+	if peerVethName("abc") != "cif-abc" {
+		t.Errorf("unexpected peer name %s", peerVethName("abc"))
+	}
+}
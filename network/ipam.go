@@ -2,6 +2,7 @@ package network
 
 import (
 	"encoding/json"
+	"fmt"
 	log "github.com/sirupsen/logrus"
 	"net"
 	"os"
@@ -134,9 +135,37 @@ func (ipam *IPAM) Release(subnet *net.IPNet, ipaddr *net.IP) error {
 	}
 
 	ipalloc := []byte((*ipam.Subnets)[subnet.String()])
+	if c < 0 || c >= len(ipalloc) {
+		return fmt.Errorf("ip is not allocated from subnet %s", subnet)
+	}
 	ipalloc[c] = '0'
 	(*ipam.Subnets)[subnet.String()] = string(ipalloc)
 
 	ipam.dump()
 	return nil
 }
+
+// 网段中已经分配出去的地址，包括网关地址
+func (ipam *IPAM) allocated(subnet *net.IPNet) []net.IP {
+	ipam.Subnets = &map[string]string{}
+	if err := ipam.load(); err != nil {
+		log.Errorf("Error load allocation info, %v", err)
+		return nil
+	}
+	_, subnet, _ = net.ParseCIDR(subnet.String())
+	var ips []net.IP
+	for c, bit := range (*ipam.Subnets)[subnet.String()] {
+		if bit != '1' {
+			continue
+		}
+		// 与Allocate中由位图下标计算地址的方式一致
+		ip := make(net.IP, net.IPv4len)
+		copy(ip, subnet.IP.To4())
+		for t := uint(4); t > 0; t -= 1 {
+			ip[4-t] += uint8(c >> ((t - 1) * 8))
+		}
+		ip[3] += 1
+		ips = append(ips, ip)
+	}
+	return ips
+}
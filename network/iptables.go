@@ -0,0 +1,34 @@
+package network
+
+import (
+	"os/exec"
+	"strings"
+)
+
+// IptablesBackend iptables命令的路径和它使用的内核接口，nf_tables或legacy，找不到iptables时都为空
+func IptablesBackend() (string, string) {
+	iptables, err := exec.LookPath("iptables")
+	if err != nil {
+		return "", ""
+	}
+	output, err := exec.Command(iptables, "--version").Output()
+	if err != nil {
+		return iptables, ""
+	}
+	return iptables, iptablesBackend(string(output))
+}
+
+// 解析iptables --version的输出，例如iptables v1.8.7 (nf_tables)，旧版本的iptables只有legacy实现，不带括号
+func iptablesBackend(version string) string {
+	start, end := strings.Index(version, "("), strings.Index(version, ")")
+	if start < 0 || end < start {
+		return "legacy"
+	}
+	return version[start+1 : end]
+}
+
+// NftablesAvailable 宿主机是否安装了nft命令
+func NftablesAvailable() bool {
+	_, err := exec.LookPath("nft")
+	return err == nil
+}
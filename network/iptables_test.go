@@ -0,0 +1,16 @@
+package network
+
+import "testing"
+
+func TestIptablesBackend(t *testing.T) {
+	cases := map[string]string{
+		"iptables v1.8.7 (nf_tables)\n": "nf_tables",
+		"iptables v1.8.4 (legacy)\n":    "legacy",
+		"iptables v1.6.1\n":             "legacy",
+	}
+	for version, want := range cases {
+		if got := iptablesBackend(version); got != want {
+			t.Errorf("iptablesBackend(%q) = %s, want %s", version, got, want)
+		}
+	}
+}
@@ -16,6 +16,7 @@ import (
 	"path"
 	"path/filepath"
 	"runtime"
+	"sort"
 	"strings"
 	"text/tabwriter"
 )
@@ -38,6 +39,8 @@ type Endpoint struct {
 	PortMapping []string `json:"portmapping"`
 	// 网络
 	Network *Network
+	// 容器init进程在宿主机上的PID，用户态网络驱动需要它找到容器的网络空间
+	ContainerPid string `json:"-"`
 }
 
 // Network 网络
@@ -48,6 +51,8 @@ type Network struct {
 	IpRange *net.IPNet
 	// 网络驱动名
 	Driver string
+	// 网络的标签
+	Labels map[string]string `json:",omitempty"`
 }
 
 // 保存网络信息
@@ -63,20 +68,20 @@ func (nw *Network) dump(dumpPath string) error {
 	nwPath := path.Join(dumpPath, nw.Name)
 	nwFile, err := os.OpenFile(nwPath, os.O_TRUNC|os.O_WRONLY|os.O_CREATE, 0644)
 	if err != nil {
-		logrus.Errorf("error：", err)
+		logrus.Errorf("error：%v", err)
 		return err
 	}
 	defer nwFile.Close()
 
 	nwJson, err := json.Marshal(nw)
 	if err != nil {
-		logrus.Errorf("error：", err)
+		logrus.Errorf("error：%v", err)
 		return err
 	}
 
 	_, err = nwFile.Write(nwJson)
 	if err != nil {
-		logrus.Errorf("error：", err)
+		logrus.Errorf("error：%v", err)
 		return err
 	}
 	return nil
@@ -110,7 +115,7 @@ func (nw *Network) load(dumpPath string) error {
 
 	err = json.Unmarshal(nwJson[:n], nw)
 	if err != nil {
-		logrus.Errorf("Error load nw info", err)
+		logrus.Errorf("Error load nw info %v", err)
 		return err
 	}
 	return nil
@@ -130,9 +135,18 @@ type NetworkDriver interface {
 	Disconnect(network Network, endpoint *Endpoint) error
 }
 
+// SetRunRoot 设置网络状态的存放目录，rootless模式下网络状态放在当前用户的运行时目录中
+func SetRunRoot(runRoot string) {
+	defaultNetworkPath = path.Join(runRoot, "network", "network") + "/"
+	ipAllocator.SubnetAllocatorPath = path.Join(runRoot, "network", "ipam", "subnet.json")
+	slirpStatePath = path.Join(runRoot, "network", "slirp")
+}
+
 func Init() error {
 	var bridgeDriver = BridgeNetworkDriver{}
 	drivers[bridgeDriver.Name()] = &bridgeDriver
+	var slirpDriver = SlirpNetworkDriver{}
+	drivers[slirpDriver.Name()] = &slirpDriver
 
 	// 判断网络的配置目录是否存在，不存在则创建
 	if _, err := os.Stat(defaultNetworkPath); err != nil {
@@ -166,11 +180,14 @@ func Init() error {
 	return nil
 }
 
-// ListNetwork 展示网络列表
-func ListNetwork() {
+// ListNetwork 展示网络列表，match不为空时只展示满足条件的网络
+func ListNetwork(match func(nw *Network) bool) {
 	w := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
 	fmt.Fprint(w, "NAME\tIpRange\tDriver\n")
 	for _, nw := range networks {
+		if match != nil && !match(nw) {
+			continue
+		}
 		fmt.Fprintf(w, "%s\t%s\t%s\n",
 			nw.Name,
 			nw.IpRange.String(),
@@ -183,10 +200,40 @@ func ListNetwork() {
 	}
 }
 
+// Networks 所有网络，按网络名排序，需要先调用Init加载网络配置
+func Networks() []*Network {
+	var nws []*Network
+	for _, nw := range networks {
+		nws = append(nws, nw)
+	}
+	sort.Slice(nws, func(i, j int) bool { return nws[i].Name < nws[j].Name })
+	return nws
+}
+
+// GetNetwork 根据网络名获取网络，需要先调用Init加载网络配置
+func GetNetwork(name string) (*Network, error) {
+	nw, ok := networks[name]
+	if !ok {
+		return nil, fmt.Errorf("No Such Network: %s", name)
+	}
+	return nw, nil
+}
+
 // CreateNetwork 创建网络
-func CreateNetwork(driver, subnet, name string) error {
+func CreateNetwork(driver, subnet, name string, labels map[string]string) error {
+	d, ok := drivers[driver]
+	if !ok {
+		return fmt.Errorf("No Such Driver: %s", driver)
+	}
+	// slirp网络未指定网段时使用slirp4netns的默认网段
+	if subnet == "" && driver == SlirpDriverName {
+		subnet = slirpDefaultSubnet
+	}
 	// 将网段字符串转成net.IPNet对象
-	_, cidr, _ := net.ParseCIDR(subnet)
+	_, cidr, err := net.ParseCIDR(subnet)
+	if err != nil {
+		return err
+	}
 	// 通过IPAM分配网关ip,获取到的网段中第一个ip作为网关ip
 	gatewayIp, err := ipAllocator.Allocate(cidr)
 	if err != nil {
@@ -194,10 +241,11 @@ func CreateNetwork(driver, subnet, name string) error {
 	}
 	cidr.IP = gatewayIp
 	// 创建网络
-	nw, err := drivers[driver].Create(cidr.String(), name)
+	nw, err := d.Create(cidr.String(), name)
 	if err != nil {
 		return err
 	}
+	nw.Labels = labels
 	// 保存网络信息，将网络的信息保存在文件 系统中，以便查询和在网络上连接网络端点
 	return nw.dump(defaultNetworkPath)
 }
@@ -268,6 +316,8 @@ func configEndpointIpAddressAndRoute(ep *Endpoint, info *container.ContainerInfo
 	if err != nil {
 		return fmt.Errorf("fail config endpoint: %v", err)
 	}
+	// 移入容器的网络空间后网卡的MAC地址不变
+	ep.MacAddress = peerLink.Attrs().HardwareAddr
 	// 将容器的网络端点加入到容器的网络空间中,并使这个函数下面的操作都在这个网络空间中执行
 	// 执行完函数后，恢复为默认的网络空间
 	defer enterContainerNetns(&peerLink, info)()
@@ -310,6 +360,16 @@ func configEndpointIpAddressAndRoute(ep *Endpoint, info *container.ContainerInfo
 
 // 配置端口映射
 func configPortMapping(ep *Endpoint, info *container.ContainerInfo) error {
+	return iptablesPortMapping("-A", ep)
+}
+
+// 删除配置端口映射时添加的DNAT规则
+func removePortMapping(ep *Endpoint) error {
+	return iptablesPortMapping("-D", ep)
+}
+
+// action为-A时添加规则，为-D时删除规则
+func iptablesPortMapping(action string, ep *Endpoint) error {
 	// 遍历容器端口映射列表
 	for _, pm := range ep.PortMapping {
 		// 分割成宿主机的端口和容器的端口
@@ -319,8 +379,8 @@ func configPortMapping(ep *Endpoint, info *container.ContainerInfo) error {
 			continue
 		}
 		// 在iptables的PREROUTING中添加DNAT规则，将宿主机的端口请求转发到容器的地址和端口上
-		iptablesCmd := fmt.Sprintf("-t nat -A PREROUTING -p tcp -m tcp --dport %s -j DNAT --to-destination %s:%s",
-			portMapping[0], ep.IPAddress.String(), portMapping[1])
+		iptablesCmd := fmt.Sprintf("-t nat %s PREROUTING -p tcp -m tcp --dport %s -j DNAT --to-destination %s:%s",
+			action, portMapping[0], ep.IPAddress.String(), portMapping[1])
 		cmd := exec.Command("iptables", strings.Split(iptablesCmd, " ")...)
 		//err := cmd.Run()
 		output, err := cmd.Output()
@@ -339,6 +399,24 @@ func Connect(networkName string, info *container.ContainerInfo) error {
 	if !ok {
 		return fmt.Errorf("No Such Network: %s", networkName)
 	}
+	// 用户态网络驱动自己完成容器内网卡的配置和端口转发，不需要IPAM和veth
+	if network.Driver == SlirpDriverName {
+		ep := &Endpoint{
+			ID:           fmt.Sprintf("%s-%s", info.Id, networkName),
+			Network:      network,
+			PortMapping:  info.PortMapping,
+			ContainerPid: info.Pid,
+		}
+		if err := drivers[network.Driver].Connect(network, ep); err != nil {
+			return err
+		}
+		// slirp4netns的网关是网段中的第2个地址
+		gateway := make(net.IP, len(ep.IPAddress))
+		copy(gateway, ep.IPAddress)
+		gateway[len(gateway)-1] = 2
+		info.Endpoint = endpointSettings(ep, gateway)
+		return nil
+	}
 	// 分配容器IP地址
 	ip, err := ipAllocator.Allocate(network.IpRange)
 	if err != nil {
@@ -351,18 +429,64 @@ func Connect(networkName string, info *container.ContainerInfo) error {
 		Network:     network,
 		PortMapping: info.PortMapping,
 	}
-	// 调用网络驱动挂载和配置网络端点
+	// 调用网络驱动挂载和配置网络端点，失败时释放已经分配的地址，
+	// 这时还没有记录端点，删除容器时无法再找到这个地址
 	if err = drivers[network.Driver].Connect(network, ep); err != nil {
+		ipAllocator.Release(network.IpRange, &ip)
 		return err
 	}
 	// 到容器的命名空间配置容器网络设备IP地址
 	if err = configEndpointIpAddressAndRoute(ep, info); err != nil {
+		drivers[network.Driver].Disconnect(*network, ep)
+		ipAllocator.Release(network.IpRange, &ip)
 		return err
 	}
+	info.Endpoint = endpointSettings(ep, network.IpRange.IP)
 	// 配置容器到宿主机的端口映射
 	return configPortMapping(ep, info)
 }
 
+// 记录到容器信息中的端点，供inspect展示和删除容器时释放地址
+func endpointSettings(ep *Endpoint, gateway net.IP) *container.EndpointSettings {
+	ones, _ := ep.Network.IpRange.Mask.Size()
+	settings := &container.EndpointSettings{
+		EndpointID:  ep.ID,
+		IPAddress:   ep.IPAddress.String(),
+		IPPrefixLen: ones,
+		Gateway:     gateway.String(),
+	}
+	if len(ep.MacAddress) > 0 {
+		settings.MacAddress = ep.MacAddress.String()
+	}
+	return settings
+}
+
+// Disconnect 断开容器和网络的连接，依次删除网络端点、端口映射规则，最后释放容器的IP地址
 func Disconnect(networkName string, info *container.ContainerInfo) error {
-	return nil
+	network, ok := networks[networkName]
+	if !ok {
+		return fmt.Errorf("No Such Network: %s", networkName)
+	}
+	ep := &Endpoint{
+		ID:           fmt.Sprintf("%s-%s", info.Id, networkName),
+		Network:      network,
+		PortMapping:  info.PortMapping,
+		ContainerPid: info.Pid,
+	}
+	if err := drivers[network.Driver].Disconnect(*network, ep); err != nil {
+		return err
+	}
+	// 用户态网络驱动的端口转发和地址都随slirp4netns进程一起释放
+	// 没有记录端点的容器是在记录端点之前创建的，无法知道它的地址
+	if network.Driver == SlirpDriverName || info.Endpoint == nil {
+		return nil
+	}
+	ep.IPAddress = net.ParseIP(info.Endpoint.IPAddress).To4()
+	if ep.IPAddress == nil {
+		return nil
+	}
+	if err := removePortMapping(ep); err != nil {
+		return err
+	}
+	return ipAllocator.Release(network.IpRange, &ep.IPAddress)
 }
@@ -0,0 +1,220 @@
+//go:build linux
+// +build linux
+
+package network
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os/exec"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/vishvananda/netlink"
+)
+
+// Orphan 没有被任何容器或pod使用的网络资源
+type Orphan struct {
+	// 资源类型：veth、ip、iptables或slirp
+	Kind string
+	// 资源名
+	Name string
+	// 释放这个资源
+	Remove func() error
+}
+
+// Owners 状态目录中记录的容器和pod，它们的网络资源由rm命令释放，不能当作残留资源清理
+type Owners struct {
+	// 容器和pod的id
+	IDs []string
+	// 端点的ip地址
+	IPs []string
+	// 有容器或pod连接但没有记录端点的网络，无法判断其中哪些地址还在使用，跳过地址和端口映射的清理
+	Unknown map[string]bool
+}
+
+func (o *Owners) ownsID(prefix string) bool {
+	for _, id := range o.IDs {
+		if strings.HasPrefix(id, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (o *Owners) ownsIP(ip net.IP) bool {
+	for _, owned := range o.IPs {
+		if ip.Equal(net.ParseIP(owned)) {
+			return true
+		}
+	}
+	return false
+}
+
+// FindOrphans 找出不属于owners的veth设备、IPAM中分配的地址、DNAT规则和slirp4netns进程，需要先调用Init加载网络配置
+func FindOrphans(owners *Owners) []Orphan {
+	var orphans []Orphan
+	orphans = append(orphans, orphanVeths(owners)...)
+	orphans = append(orphans, orphanIPs(owners)...)
+	orphans = append(orphans, orphanDNATRules(owners)...)
+	orphans = append(orphans, orphanSlirps(owners)...)
+	return orphans
+}
+
+// 可以清理地址和端口映射的bridge网络
+func bridgeNetworks(owners *Owners) []*Network {
+	var nws []*Network
+	for _, nw := range networks {
+		if nw.Driver == SlirpDriverName || nw.IpRange == nil || owners.Unknown[nw.Name] {
+			continue
+		}
+		nws = append(nws, nw)
+	}
+	sort.Slice(nws, func(i, j int) bool { return nws[i].Name < nws[j].Name })
+	return nws
+}
+
+// 挂在网桥上的veth以端点id的前缀命名，容器的网络空间销毁后宿主机一端正常会一起被删除
+func orphanVeths(owners *Owners) []Orphan {
+	bridges := map[int]bool{}
+	for _, nw := range networks {
+		if nw.Driver == SlirpDriverName {
+			continue
+		}
+		if br, err := netlink.LinkByName(nw.Name); err == nil {
+			bridges[br.Attrs().Index] = true
+		}
+	}
+	links, err := netlink.LinkList()
+	if err != nil {
+		return nil
+	}
+	var orphans []Orphan
+	for _, link := range links {
+		if link.Type() != "veth" || !bridges[link.Attrs().MasterIndex] || owners.ownsID(link.Attrs().Name) {
+			continue
+		}
+		link := link
+		orphans = append(orphans, Orphan{
+			Kind:   "veth",
+			Name:   link.Attrs().Name,
+			Remove: func() error { return netlink.LinkDel(link) },
+		})
+	}
+	return orphans
+}
+
+// IPAM中已分配但没有端点使用的地址，网关地址随网络一起释放
+func orphanIPs(owners *Owners) []Orphan {
+	var orphans []Orphan
+	for _, nw := range bridgeNetworks(owners) {
+		for _, ip := range ipAllocator.allocated(nw.IpRange) {
+			if ip.Equal(nw.IpRange.IP) || owners.ownsIP(ip) {
+				continue
+			}
+			nw, ip := nw, ip
+			orphans = append(orphans, Orphan{
+				Kind: "ip",
+				Name: fmt.Sprintf("%s/%s", nw.Name, ip),
+				Remove: func() error {
+					// Release会修改传入的地址
+					releaseIP := make(net.IP, len(ip))
+					copy(releaseIP, ip)
+					return ipAllocator.Release(nw.IpRange, &releaseIP)
+				},
+			})
+		}
+	}
+	return orphans
+}
+
+// 目的地址在bridge网络网段中、但不属于任何端点的DNAT规则
+func orphanDNATRules(owners *Owners) []Orphan {
+	nws := bridgeNetworks(owners)
+	if len(nws) == 0 {
+		return nil
+	}
+	output, err := exec.Command("iptables", "-t", "nat", "-S", "PREROUTING").Output()
+	if err != nil {
+		return nil
+	}
+	var orphans []Orphan
+	for _, rule := range strings.Split(string(output), "\n") {
+		ip := dnatDestination(rule)
+		if ip == nil || owners.ownsIP(ip) {
+			continue
+		}
+		for _, nw := range nws {
+			if !nw.IpRange.Contains(ip) {
+				continue
+			}
+			// iptables -S输出的规则以-A开头，换成-D即为删除这条规则的参数
+			args := append([]string{"-t", "nat", "-D"}, strings.Fields(rule)[1:]...)
+			orphans = append(orphans, Orphan{
+				Kind: "iptables",
+				Name: rule,
+				Remove: func() error {
+					if output, err := exec.Command("iptables", args...).CombinedOutput(); err != nil {
+						return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(output)))
+					}
+					return nil
+				},
+			})
+			break
+		}
+	}
+	return orphans
+}
+
+// 解析iptables -S输出的DNAT规则的目的地址，不是DNAT规则时返回nil
+func dnatDestination(rule string) net.IP {
+	fields := strings.Fields(rule)
+	if len(fields) < 2 || fields[0] != "-A" {
+		return nil
+	}
+	isDNAT := false
+	for i := 0; i < len(fields)-1; i++ {
+		switch fields[i] {
+		case "-j":
+			isDNAT = fields[i+1] == "DNAT"
+		case "--to-destination":
+			if !isDNAT {
+				return nil
+			}
+			host := fields[i+1]
+			if h, _, err := net.SplitHostPort(host); err == nil {
+				host = h
+			}
+			return net.ParseIP(host)
+		}
+	}
+	return nil
+}
+
+// 端点已经不存在的slirp4netns进程，pid文件以端点id命名，端点id的格式为 容器id-网络名
+func orphanSlirps(owners *Owners) []Orphan {
+	files, err := ioutil.ReadDir(slirpStatePath)
+	if err != nil {
+		return nil
+	}
+	var orphans []Orphan
+	for _, file := range files {
+		if !strings.HasSuffix(file.Name(), ".pid") {
+			continue
+		}
+		endpointID := strings.TrimSuffix(file.Name(), ".pid")
+		if owners.ownsID(strings.SplitN(endpointID, "-", 2)[0]) {
+			continue
+		}
+		orphans = append(orphans, Orphan{
+			Kind: "slirp",
+			Name: path.Join(slirpStatePath, file.Name()),
+			Remove: func() error {
+				return (&SlirpNetworkDriver{}).Disconnect(Network{}, &Endpoint{ID: endpointID})
+			},
+		})
+	}
+	return orphans
+}
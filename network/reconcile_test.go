@@ -0,0 +1,23 @@
+package network
+
+import "testing"
+
+func TestDnatDestination(t *testing.T) {
+	cases := map[string]string{
+		"-A PREROUTING -p tcp -m tcp --dport 80 -j DNAT --to-destination 192.168.10.2:8080": "192.168.10.2",
+		"-A PREROUTING -j DNAT --to-destination 10.0.0.5":                                   "10.0.0.5",
+		"-A POSTROUTING -s 192.168.10.0/24 ! -o br0 -j MASQUERADE":                          "",
+		"-P PREROUTING ACCEPT": "",
+		"":                     "",
+	}
+	for rule, want := range cases {
+		ip := dnatDestination(rule)
+		got := ""
+		if ip != nil {
+			got = ip.String()
+		}
+		if got != want {
+			t.Errorf("dnatDestination(%q) = %q, want %q", rule, got, want)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+//go:build linux
+// +build linux
+
+package network
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// SlirpDriverName 用户态网络驱动名
+	SlirpDriverName = "slirp"
+	// slirp网络的默认网段，与slirp4netns的默认配置一致
+	slirpDefaultSubnet = "10.0.2.0/24"
+	// slirp4netns在容器内创建的tap设备名
+	slirpTapName = "tap0"
+)
+
+// slirp4netns进程的pid文件和api socket存放目录
+var slirpStatePath = "/var/run/cloud-docker/network/slirp"
+
+// SlirpNetworkDriver 基于slirp4netns的用户态网络驱动
+// 不需要创建网桥、veth和iptables规则，因此非特权用户也可以使用
+type SlirpNetworkDriver struct {
+}
+
+func (d *SlirpNetworkDriver) Name() string {
+	return SlirpDriverName
+}
+
+// Create 创建网络，slirp网络只是记录网段，实际的网络设备在连接容器时才由slirp4netns创建
+func (d *SlirpNetworkDriver) Create(subnet string, name string) (*Network, error) {
+	ip, ipRange, err := net.ParseCIDR(subnet)
+	if err != nil {
+		return nil, err
+	}
+	ipRange.IP = ip
+	return &Network{
+		Name:    name,
+		IpRange: ipRange,
+		Driver:  d.Name(),
+	}, nil
+}
+
+// Delete 删除网络，slirp网络没有宿主机上的设备需要清理
+func (d *SlirpNetworkDriver) Delete(network Network) error {
+	return nil
+}
+
+// Connect 为容器启动一个slirp4netns进程，由它在容器的网络空间中创建tap设备并配置地址、路由和DNS
+func (d *SlirpNetworkDriver) Connect(network *Network, endpoint *Endpoint) error {
+	if err := os.MkdirAll(slirpStatePath, 0755); err != nil {
+		return err
+	}
+	apiSocket := path.Join(slirpStatePath, endpoint.ID+".sock")
+	// slirp4netns完成配置后会向ready-fd写入一个字节
+	readyR, readyW, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer readyR.Close()
+	subnet := &net.IPNet{IP: network.IpRange.IP.Mask(network.IpRange.Mask), Mask: network.IpRange.Mask}
+	cmd := exec.Command("slirp4netns", "--configure", "--mtu=65520", "--disable-host-loopback",
+		"--cidr", subnet.String(), "--api-socket", apiSocket, "--ready-fd=3",
+		endpoint.ContainerPid, slirpTapName)
+	cmd.ExtraFiles = []*os.File{readyW}
+	// slirp4netns需要在cloud-docker命令退出后继续运行，所以放到新的会话中
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err = cmd.Start(); err != nil {
+		readyW.Close()
+		return fmt.Errorf("start slirp4netns error: %v", err)
+	}
+	readyW.Close()
+	if _, err = readyR.Read(make([]byte, 1)); err != nil {
+		return fmt.Errorf("slirp4netns for %s not ready: %v", endpoint.ID, err)
+	}
+	pidFile := path.Join(slirpStatePath, endpoint.ID+".pid")
+	if err = ioutil.WriteFile(pidFile, []byte(strconv.Itoa(cmd.Process.Pid)), 0644); err != nil {
+		log.Errorf("write slirp4netns pid file %s error %v", pidFile, err)
+	}
+	cmd.Process.Release()
+
+	// slirp4netns固定把网段中的第100个地址分配给容器
+	endpoint.IPAddress = make(net.IP, len(subnet.IP.To4()))
+	copy(endpoint.IPAddress, subnet.IP.To4())
+	endpoint.IPAddress[3] += 100
+
+	// 通过api socket添加端口转发，相当于bridge网络中的DNAT规则
+	for _, pm := range endpoint.PortMapping {
+		portMapping := strings.Split(pm, ":")
+		if len(portMapping) != 2 {
+			log.Errorf("port mapping format error, %v", pm)
+			continue
+		}
+		if err = addHostFwd(apiSocket, portMapping[0], portMapping[1]); err != nil {
+			log.Errorf("add port mapping %s error %v", pm, err)
+		}
+	}
+	return nil
+}
+
+// Disconnect 停止容器对应的slirp4netns进程
+func (d *SlirpNetworkDriver) Disconnect(network Network, endpoint *Endpoint) error {
+	pidFile := path.Join(slirpStatePath, endpoint.ID+".pid")
+	content, err := ioutil.ReadFile(pidFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if pid, err := strconv.Atoi(string(content)); err == nil {
+		// 进程已经退出时忽略错误
+		syscall.Kill(pid, syscall.SIGTERM)
+	}
+	os.Remove(path.Join(slirpStatePath, endpoint.ID+".sock"))
+	return os.Remove(pidFile)
+}
+
+// 调用slirp4netns的add_hostfwd接口添加一条tcp端口转发
+func addHostFwd(apiSocket, hostPort, guestPort string) error {
+	hp, err := strconv.Atoi(hostPort)
+	if err != nil {
+		return err
+	}
+	gp, err := strconv.Atoi(guestPort)
+	if err != nil {
+		return err
+	}
+	conn, err := net.Dial("unix", apiSocket)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	req := map[string]interface{}{
+		"execute": "add_hostfwd",
+		"arguments": map[string]interface{}{
+			"proto":      "tcp",
+			"host_addr":  "0.0.0.0",
+			"host_port":  hp,
+			"guest_port": gp,
+		},
+	}
+	if err = json.NewEncoder(conn).Encode(req); err != nil {
+		return err
+	}
+	// 关闭写端，slirp4netns读到EOF后才会处理请求
+	conn.(*net.UnixConn).CloseWrite()
+	var resp map[string]interface{}
+	if err = json.NewDecoder(conn).Decode(&resp); err != nil {
+		return err
+	}
+	if e, ok := resp["error"]; ok {
+		return fmt.Errorf("%v", e)
+	}
+	return nil
+}
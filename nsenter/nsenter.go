@@ -1,54 +1,283 @@
 package nsenter
 
 /*
+#define _GNU_SOURCE
 #include <errno.h>
 #include <sched.h>
+#include <signal.h>
 #include <stdio.h>
 #include <stdlib.h>
 #include <string.h>
 #include <fcntl.h>
+#include <unistd.h>
+#include <sys/prctl.h>
+#include <sys/resource.h>
+#include <sys/stat.h>
+#include <sys/types.h>
+#include <sys/wait.h>
+
+#ifndef CLONE_NEWTIME
+#define CLONE_NEWTIME 0x00000080
+#endif
+
+// 写入进程的LSM属性，在下一次execve时生效
+static int write_attr(const char *path, const char *value) {
+	int fd = open(path, O_WRONLY);
+	if (fd == -1) {
+		return -1;
+	}
+	int res = write(fd, value, strlen(value));
+	close(fd);
+	return res == -1 ? -1 : 0;
+}
+
+// 与init进程保持一致的资源限制，格式为 资源编号=soft:hard,...
+static void apply_rlimits(void) {
+	char *rlimits = getenv("cloud_docker_rlimits");
+	if (!rlimits) {
+		return;
+	}
+	char *p = rlimits;
+	while (*p) {
+		int resource = (int)strtol(p, &p, 10);
+		struct rlimit limit;
+		limit.rlim_cur = strtoull(p + 1, &p, 10);
+		limit.rlim_max = strtoull(p + 1, &p, 10);
+		if (setrlimit(resource, &limit) == -1) {
+			fprintf(stderr, "setrlimit %d failed: %s\n", resource, strerror(errno));
+		}
+		if (*p == ',') {
+			p++;
+		}
+	}
+	// 资源限制已经生效，不能再传给容器内执行的命令
+	unsetenv("cloud_docker_rlimits");
+}
+
+// 与init进程保持一致的安全配置，由cloud_docker_*环境变量传入
+// 需要在进入容器的mnt namespace之前设置，此时/proc还是宿主机的proc，可以找到当前进程
+static void apply_security(void) {
+	char *profile = getenv("cloud_docker_apparmor");
+	if (profile) {
+		char value[1024];
+		snprintf(value, sizeof(value), "exec %s", profile);
+		if (write_attr("/proc/self/attr/apparmor/exec", value) == -1 &&
+			write_attr("/proc/self/attr/exec", value) == -1) {
+			fprintf(stderr, "set apparmor profile %s failed: %s\n", profile, strerror(errno));
+		}
+	}
+	char *label = getenv("cloud_docker_selinux_label");
+	if (label && write_attr("/proc/self/attr/exec", label) == -1) {
+		fprintf(stderr, "set selinux label %s failed: %s\n", label, strerror(errno));
+	}
+	char *no_new_privs = getenv("cloud_docker_no_new_privs");
+	if (no_new_privs && strcmp(no_new_privs, "1") == 0) {
+		if (prctl(PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0) == -1) {
+			fprintf(stderr, "set no_new_privs failed: %s\n", strerror(errno));
+		}
+	}
+	unsetenv("cloud_docker_apparmor");
+	unsetenv("cloud_docker_selinux_label");
+	unsetenv("cloud_docker_no_new_privs");
+}
+
+// exec进入的进程加入容器init进程所在的cgroup，受到同样的资源限制，之后fork出的子进程也在这些cgroup中
+// 需要在进入user和mnt namespace之前完成，此时还能以宿主机上的身份访问宿主机的cgroup文件系统
+static void join_cgroups(void) {
+	char *procs = getenv("cloud_docker_cgroup_procs");
+	if (!procs) {
+		return;
+	}
+	char pid[32];
+	snprintf(pid, sizeof(pid), "%d", getpid());
+	// 每行一个cgroup.procs文件
+	char *items = strdup(procs);
+	char *saveptr = NULL;
+	char *item = strtok_r(items, "\n", &saveptr);
+	while (item) {
+		if (write_attr(item, pid) == -1) {
+			fprintf(stderr, "join cgroup %s failed: %s\n", item, strerror(errno));
+			exit(1);
+		}
+		item = strtok_r(NULL, "\n", &saveptr);
+	}
+	free(items);
+	unsetenv("cloud_docker_cgroup_procs");
+}
+
+// 判断是否已经在这个namespace中，与宿主机共享的namespace不需要进入
+// 对自己所在的user namespace调用setns会失败，进入容器的user namespace后也没有权限再进入宿主机拥有的namespace
+static int same_namespace(const char *path, const char *type) {
+	char self[64];
+	snprintf(self, sizeof(self), "/proc/self/ns/%s", type);
+	struct stat st1, st2;
+	if (stat(path, &st1) == -1 || stat(self, &st2) == -1) {
+		return 0;
+	}
+	return st1.st_dev == st2.st_dev && st1.st_ino == st2.st_ino;
+}
+
+// fork出的子进程，父进程收到的信号转发给它
+static pid_t forward_pid = 0;
+
+static void forward_signal(int sig) {
+	if (forward_pid > 0) {
+		kill(forward_pid, sig);
+	}
+}
+
+// setns进入pid namespace只对之后创建的子进程生效，因此需要再fork一次，由子进程继续执行
+// 父进程等待子进程退出，并把子进程的退出码作为自己的退出码，收到的信号都转发给子进程
+// 新建time namespace时父进程是容器pid namespace中的1号进程，容器内的孤儿进程会交给它，需要一直回收
+static void fork_and_wait(void) {
+	pid_t child = fork();
+	if (child == -1) {
+		fprintf(stderr, "fork failed: %s\n", strerror(errno));
+		exit(1);
+	}
+	if (child == 0) {
+		return;
+	}
+	forward_pid = child;
+	int signals[] = { SIGHUP, SIGINT, SIGQUIT, SIGTERM, SIGUSR1, SIGUSR2, SIGWINCH };
+	struct sigaction sa;
+	memset(&sa, 0, sizeof(sa));
+	sa.sa_handler = forward_signal;
+	sigemptyset(&sa.sa_mask);
+	for (int i = 0; i < (int)(sizeof(signals) / sizeof(signals[0])); i++) {
+		sigaction(signals[i], &sa, NULL);
+	}
+	int status;
+	for (;;) {
+		pid_t pid = waitpid(-1, &status, 0);
+		if (pid == -1) {
+			if (errno == EINTR) {
+				continue;
+			}
+			exit(1);
+		}
+		// 其他退出的进程只需要回收，避免成为僵尸进程
+		if (pid == child) {
+			break;
+		}
+	}
+	if (WIFEXITED(status)) {
+		exit(WEXITSTATUS(status));
+	}
+	exit(128 + WTERMSIG(status));
+}
+
+// run命令创建容器时，init进程加入其他容器共享的namespace，格式为 类型:路径,...
+// 必须在Go运行时启动之前调用，此时进程还是单线程的，返回是否加入了pid namespace
+static int join_namespaces(const char *join_ns) {
+	int join_pid = 0;
+	// strtok_r会修改字符串，不能直接修改环境变量
+	char *items = strdup(join_ns);
+	char *saveptr = NULL;
+	char *item = strtok_r(items, ",", &saveptr);
+	while (item) {
+		char *sep = strchr(item, ':');
+		if (!sep) {
+			fprintf(stderr, "invalid namespace %s\n", item);
+			exit(1);
+		}
+		*sep = '\0';
+		int fd = open(sep + 1, O_RDONLY);
+		if (fd == -1 || setns(fd, 0) == -1) {
+			fprintf(stderr, "join %s namespace %s failed: %s\n", item, sep + 1, strerror(errno));
+			exit(1);
+		}
+		close(fd);
+		if (strcmp(item, "pid") == 0) {
+			join_pid = 1;
+		}
+		item = strtok_r(NULL, ",", &saveptr);
+	}
+	free(items);
+	return join_pid;
+}
+
+// 创建time namespace并设置时钟偏移，偏移只能在namespace中还没有进程时设置
+// 和pid namespace一样，当前进程不会进入新的time namespace，之后fork出的子进程才会
+static void new_time_namespace(const char *offsets) {
+	if (unshare(CLONE_NEWTIME) == -1) {
+		fprintf(stderr, "unshare time namespace failed: %s\n", strerror(errno));
+		exit(1);
+	}
+	if (write_attr("/proc/self/timens_offsets", offsets) == -1) {
+		fprintf(stderr, "set time offsets failed: %s\n", strerror(errno));
+		exit(1);
+	}
+}
 
 // __attribute__((constructor)) 类似构造函数，这个包一旦被引用，这个函数会自动执行。也就是会在程序一启动的时候运行
 __attribute__((constructor)) void enter_namespace(void) {
-	char *cloud_docker_pid;
-	// 从环境变量中获取需要进入的PID
-    cloud_docker_pid=getenv("cloud_docker_pid");
-    if(cloud_docker_pid){
-		fprintf(stdout, "got cloud_docker_pid=%s\n", cloud_docker_pid);
-	}else{
-		fprintf(stdout, "missing cloud_docker_pid env skip nsenter");
-		// 未设置pid环境变量说明不是执行exec命令，返回
+	// run命令创建容器时，init进程需要先加入共享的namespace，再创建time namespace
+	char *join_ns = getenv("cloud_docker_join_ns");
+	char *time_offset = getenv("cloud_docker_time_offset");
+	if (join_ns || time_offset) {
+		int need_fork = 0;
+		if (join_ns) {
+			need_fork = join_namespaces(join_ns);
+		}
+		if (time_offset) {
+			new_time_namespace(time_offset);
+			need_fork = 1;
+		}
+		// pid和time namespace都只对子进程生效，只需要fork一次
+		if (need_fork) {
+			fork_and_wait();
+		}
 		return;
 	}
-	char *cloud_docker_cmd;
-	cloud_docker_cmd = getenv("cloud_docker_cmd");
-	if (cloud_docker_cmd) {
-		fprintf(stdout, "got cloud_docker_cmd=%s\n", cloud_docker_cmd);
-	} else {
-		fprintf(stdout, "missing cloud_docker_cmd env skip nsenter");
+	// 从环境变量中获取需要进入的PID，未设置说明不是执行exec命令，直接返回
+	char *cloud_docker_pid = getenv("cloud_docker_pid");
+	if (!cloud_docker_pid) {
 		return;
 	}
+	apply_rlimits();
+	apply_security();
+	join_cgroups();
 	int i;
 	char nspath[1024];
-	// 需要进入的五种Namespace
-	char *namespaces[] = { "ipc", "uts", "net", "pid", "mnt" };
-	for (i=0; i<5; i++) {
+	// 需要进入的Namespace，user要最先进入，之后才有权限进入它所拥有的其他namespace
+	// cgroup和time要在mnt之前进入，进入mnt后就找不到宿主机上的/proc了
+	char *namespaces[] = { "user", "ipc", "uts", "net", "pid", "cgroup", "time", "mnt" };
+	int fds[8];
+	// 先打开所有namespace文件，进入容器的user namespace后就不一定有权限再打开宿主机/proc中的文件
+	for (i=0; i<8; i++) {
 		// 拼接对应路径，比如/proc/pid/ns/ipc
-		sprintf(nspath, "/proc/%s/ns/%s", cloud_docker_pid, namespaces[i]);
-		int fd = open(nspath, O_RDONLY);
+		snprintf(nspath, sizeof(nspath), "/proc/%s/ns/%s", cloud_docker_pid, namespaces[i]);
+		fds[i] = -1;
+		if (same_namespace(nspath, namespaces[i])) {
+			continue;
+		}
+		fds[i] = open(nspath, O_RDONLY);
+		// 旧内核没有time namespace
+		if (fds[i] == -1 && errno != ENOENT) {
+			fprintf(stderr, "open %s namespace failed: %s\n", namespaces[i], strerror(errno));
+			exit(1);
+		}
+	}
+	for (i=0; i<8; i++) {
+		if (fds[i] == -1) {
+			continue;
+		}
 		// 这里才真正调用setns系统调用进入对应的Namespace
-		if (setns(fd, 0) == -1) {
+		if (setns(fds[i], 0) == -1) {
 			fprintf(stderr, "setns on %s namespace failed: %s\n", namespaces[i], strerror(errno));
-		} else {
-			fprintf(stdout, "setns on %s namespace succeeded\n", namespaces[i]);
+			exit(1);
+		}
+		close(fds[i]);
+		// 进入user namespace后切换为容器内的root，否则宿主机上的root在容器内是未映射的用户
+		if (i == 0 && (setresgid(0, 0, 0) == -1 || setresuid(0, 0, 0) == -1)) {
+			fprintf(stderr, "switch to root in user namespace failed: %s\n", strerror(errno));
+			exit(1);
 		}
-		close(fd);
 	}
-	// 在进入的Namespace中执行指定的命令
-	int res = system(cloud_docker_cmd);
-	// 退出
-	exit(0);
-	return;
+	// 进入pid namespace后需要fork一次，子进程返回后由Go运行时读取exec请求并直接execve用户命令
+	// 父进程等待子进程退出，并把命令的退出码传回给exec命令
+	fork_and_wait();
 }
 */
 import "C"
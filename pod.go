@@ -0,0 +1,231 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/yunfeiyang1916/cloud-docker/container"
+	"github.com/yunfeiyang1916/cloud-docker/network"
+	"github.com/yunfeiyang1916/cloud-docker/store"
+)
+
+// 创建pod，此时只记录pod信息，infra进程在pod start或第一个容器加入时启动
+func createPod(podName, nw string, portmapping []string) error {
+	// pod名会作为状态目录名
+	if err := container.ValidateName(podName); err != nil {
+		return err
+	}
+	pod := &container.PodInfo{
+		Id:          container.GenerateID(),
+		Name:        podName,
+		Status:      container.Created,
+		Network:     nw,
+		PortMapping: portmapping,
+		CreatedTime: time.Now().Format("2006-01-02 15:04:05"),
+	}
+	return store.CreatePod(pod)
+}
+
+// 启动pod的infra进程，由它持有pod内容器共享的namespace，并把pod连接到网络
+func startPod(podName string) error {
+	return store.UpdatePod(podName, func(pod *container.PodInfo) error {
+		if pod.Status == container.Running {
+			return fmt.Errorf("pod %s is already running", podName)
+		}
+		return startInfra(pod)
+	})
+}
+
+// 启动容器时如果pod还没有运行则先启动它，持有pod的锁检查和启动，同时加入的容器只会启动一个infra进程
+func ensurePodRunning(podName string) error {
+	return store.UpdatePod(podName, func(pod *container.PodInfo) error {
+		if pod.Status == container.Running {
+			return nil
+		}
+		return startInfra(pod)
+	})
+}
+
+// 启动infra进程并连接网络，调用方需要持有pod的锁
+func startInfra(pod *container.PodInfo) error {
+	// 非特权用户的容器各自拥有user namespace，无法加入其他user namespace下的网络空间
+	if container.IsRootless() {
+		return fmt.Errorf("pod is not supported in rootless mode")
+	}
+	// infra进程执行pause命令，只创建pod共享的namespace，不需要pid和mnt隔离
+	cmd := container.SelfCommand("pause")
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Cloneflags: syscall.CLONE_NEWNET | syscall.CLONE_NEWIPC | syscall.CLONE_NEWUTS,
+		// infra进程需要在命令退出后继续运行
+		Setsid: true,
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("start pod infra process error %v", err)
+	}
+	infraPid := cmd.Process.Pid
+	cmd.Process.Release()
+
+	if pod.Network != "" {
+		network.Init()
+		info := podEndpointInfo(pod)
+		info.Pid = strconv.Itoa(infraPid)
+		if err := network.Connect(pod.Network, info); err != nil {
+			syscall.Kill(infraPid, syscall.SIGTERM)
+			return fmt.Errorf("connect pod %s to network %s error %v", pod.Name, pod.Network, err)
+		}
+		pod.Endpoint = info.Endpoint
+	}
+	pod.InfraPid = strconv.Itoa(infraPid)
+	pod.Status = container.Running
+	return nil
+}
+
+// 停止pod内的所有容器，再停止infra进程并释放网络
+func stopPod(podName string) error {
+	return store.UpdatePod(podName, func(pod *container.PodInfo) error {
+		for _, id := range pod.Containers {
+			info, err := getContainerInfo(id)
+			if err != nil || info.Status != container.Running {
+				continue
+			}
+			stopContainer(id)
+		}
+		if pod.Status != container.Running {
+			return nil
+		}
+		if pod.Network != "" {
+			network.Init()
+			if err := network.Disconnect(pod.Network, podEndpointInfo(pod)); err != nil {
+				logrus.Errorf("disconnect pod %s from network %s error %v", podName, pod.Network, err)
+			}
+			pod.Endpoint = nil
+		}
+		if pid, err := strconv.Atoi(pod.InfraPid); err == nil {
+			if err = syscall.Kill(pid, syscall.SIGTERM); err != nil {
+				logrus.Errorf("stop pod %s infra process error %v", podName, err)
+			}
+		}
+		pod.Status = container.Stop
+		pod.InfraPid = ""
+		return nil
+	})
+}
+
+// 删除pod和pod内的所有容器，force为true时先停止pod
+// 删除容器时会更新pod的容器列表，所以先逐个删除容器，最后在持有锁的情况下删除pod
+func removePod(podName string, force bool) error {
+	pod, err := store.GetPod(podName)
+	if err != nil {
+		return err
+	}
+	if pod.Status == container.Running {
+		if !force {
+			return fmt.Errorf("couldn't remove running pod %s, stop it first or use -f", podName)
+		}
+		if err = stopPod(podName); err != nil {
+			return err
+		}
+	}
+	for _, id := range pod.Containers {
+		if err = removeContainer(id, RemoveOptions{Force: true}); err != nil {
+			logrus.Errorf("remove container %s error %v", container.ShortID(id), err)
+		}
+	}
+	return store.DeletePod(podName, func(pod *container.PodInfo) error {
+		// 期间pod可能被重新启动或加入了新的容器
+		if pod.Status == container.Running {
+			return fmt.Errorf("couldn't remove running pod %s, stop it first or use -f", podName)
+		}
+		if len(pod.Containers) > 0 {
+			return fmt.Errorf("couldn't remove pod %s, it still has container %s", podName, container.ShortID(pod.Containers[0]))
+		}
+		return nil
+	})
+}
+
+// 展示pod列表
+func listPods() {
+	w := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
+	fmt.Fprint(w, "ID\tNAME\tSTATUS\tINFRA PID\tNETWORK\tCONTAINERS\tCREATED\n")
+	for _, pod := range allPods() {
+		var containers []string
+		for _, id := range pod.Containers {
+			containers = append(containers, container.ShortID(id))
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			container.ShortID(pod.Id),
+			pod.Name,
+			pod.Status,
+			pod.InfraPid,
+			pod.Network,
+			strings.Join(containers, ","),
+			pod.CreatedTime)
+	}
+	if err := w.Flush(); err != nil {
+		logrus.Errorf("Flush error %s", err)
+	}
+}
+
+// 把容器加入pod的容器列表
+func addPodContainer(podName, containerID string) error {
+	return store.UpdatePod(podName, func(pod *container.PodInfo) error {
+		pod.Containers = append(pod.Containers, containerID)
+		return nil
+	})
+}
+
+// 删除容器时把它从所属pod的容器列表中移除
+func removePodContainer(podName, containerID string) error {
+	return store.UpdatePod(podName, func(pod *container.PodInfo) error {
+		var containers []string
+		for _, id := range pod.Containers {
+			if id != containerID {
+				containers = append(containers, id)
+			}
+		}
+		pod.Containers = containers
+		return nil
+	})
+}
+
+// pod的网络端点属于infra进程，用pod的Id和infra进程的pid连接网络
+func podEndpointInfo(pod *container.PodInfo) *container.ContainerInfo {
+	return &container.ContainerInfo{
+		Id:          pod.Id,
+		Pid:         pod.InfraPid,
+		Name:        pod.Name,
+		PortMapping: pod.PortMapping,
+		Endpoint:    pod.Endpoint,
+	}
+}
+
+// pod infra进程的主体，只是持有namespace，直到收到退出信号
+func runPause() error {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGTERM, syscall.SIGINT)
+	<-sigs
+	return nil
+}
+
+// 所有pod的信息，读取失败的pod会被跳过
+func allPods() []*container.PodInfo {
+	names, err := store.ListPods()
+	if err != nil {
+		logrus.Errorf("list pods error %v", err)
+		return nil
+	}
+	var pods []*container.PodInfo
+	for _, name := range names {
+		if pod, err := store.GetPod(name); err == nil {
+			pods = append(pods, pod)
+		}
+	}
+	return pods
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/yunfeiyang1916/cloud-docker/container"
+	"github.com/yunfeiyang1916/cloud-docker/store"
+)
+
+// 把运行时状态目录和数据目录指向临时目录，返回的函数恢复原来的设置
+func setupRoots(t *testing.T) (string, func()) {
+	root, err := ioutil.TempDir("", "cloud-docker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	execRoot, dataRoot := container.ExecRoot, container.DataRoot
+	container.SetRoots(filepath.Join(root, "exec"), filepath.Join(root, "data"))
+	return root, func() {
+		container.SetRoots(execRoot, dataRoot)
+		os.RemoveAll(root)
+	}
+}
+
+func TestCreatePod(t *testing.T) {
+	root, cleanup := setupRoots(t)
+	defer cleanup()
+	for _, name := range []string{"../../x", "a/b", ""} {
+		if err := createPod(name, "", nil); err == nil {
+			t.Errorf("expect error for pod name %q", name)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(root, "x")); !os.IsNotExist(err) {
+		t.Fatalf("invalid pod name should not create files outside the pods dir, got %v", err)
+	}
+	if err := createPod("web", "", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := createPod("web", "", nil); err == nil {
+		t.Fatal("expect error for duplicate pod")
+	}
+	pod, err := store.GetPod("web")
+	if err != nil || pod.Status != container.Created {
+		t.Fatalf("unexpected pod %+v %v", pod, err)
+	}
+}
+
+func TestPodContainers(t *testing.T) {
+	_, cleanup := setupRoots(t)
+	defer cleanup()
+	if err := createPod("web", "", nil); err != nil {
+		t.Fatal(err)
+	}
+	// 同时加入的容器都要记录下来
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := addPodContainer("web", fmt.Sprintf("%064d", i)); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	pod, err := store.GetPod("web")
+	if err != nil || len(pod.Containers) != 10 {
+		t.Fatalf("expect 10 containers, got %+v %v", pod, err)
+	}
+	if err = removePodContainer("web", fmt.Sprintf("%064d", 3)); err != nil {
+		t.Fatal(err)
+	}
+	pod, _ = store.GetPod("web")
+	for _, id := range pod.Containers {
+		if id == fmt.Sprintf("%064d", 3) {
+			t.Fatalf("container should be removed from pod, got %v", pod.Containers)
+		}
+	}
+	if len(pod.Containers) != 9 {
+		t.Fatalf("expect 9 containers, got %v", pod.Containers)
+	}
+	if err = addPodContainer("db", fmt.Sprintf("%064d", 1)); err == nil {
+		t.Fatal("expect error for missing pod")
+	}
+}
+
+func TestResolvePodNamespaces(t *testing.T) {
+	_, cleanup := setupRoots(t)
+	defer cleanup()
+	if err := createPod("web", "", nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := resolveNamespaces(map[string]string{"net": "pod:web"}); err == nil {
+		t.Fatal("expect error for pod that is not running")
+	}
+	// 用当前进程代替infra进程
+	pid := fmt.Sprint(os.Getpid())
+	if err := store.UpdatePod("web", func(pod *container.PodInfo) error {
+		pod.Status = container.Running
+		pod.InfraPid = pid
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	nsPaths, err := resolveNamespaces(map[string]string{"net": "pod:web", "ipc": "pod:web"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, nsType := range []string{"net", "ipc"} {
+		if nsPaths[nsType] != container.NamespacePath(pid, nsType) {
+			t.Errorf("unexpected %s namespace path %s", nsType, nsPaths[nsType])
+		}
+	}
+	if _, err = resolveNamespaces(map[string]string{"net": "pod:db"}); err == nil {
+		t.Fatal("expect error for missing pod")
+	}
+}
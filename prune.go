@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/yunfeiyang1916/cloud-docker/container"
+)
+
+// 依次删除多个容器，某个容器删除失败不影响其他容器
+func removeContainers(refs []string, opts RemoveOptions) error {
+	failed := 0
+	for _, ref := range refs {
+		if err := removeContainer(ref, opts); err != nil {
+			logrus.Errorf("remove container %s error %v", ref, err)
+			failed++
+			continue
+		}
+		fmt.Println(ref)
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed to remove %d of %d containers", failed, len(refs))
+	}
+	return nil
+}
+
+// prune命令的过滤条件
+type pruneFilters struct {
+	// 只删除在这个时间之前创建的容器，为零值时不限制
+	until  time.Time
+	labels []string
+}
+
+// 解析prune的--filter参数，支持until=<时间>和label=<key>[=<value>]
+func parsePruneFilters(args []string, now time.Time) (*pruneFilters, error) {
+	filters := &pruneFilters{}
+	for _, arg := range args {
+		kv := strings.SplitN(arg, "=", 2)
+		if len(kv) != 2 || kv[1] == "" {
+			return nil, fmt.Errorf("invalid filter %s, expect key=value", arg)
+		}
+		switch kv[0] {
+		case "until":
+			until, err := parseLogTime(kv[1], now)
+			if err != nil {
+				return nil, err
+			}
+			filters.until = until
+		case "label":
+			filters.labels = append(filters.labels, kv[1])
+		default:
+			return nil, fmt.Errorf("invalid filter %s, expect until or label", arg)
+		}
+	}
+	return filters, nil
+}
+
+func (f *pruneFilters) match(info *container.ContainerInfo) bool {
+	if info.Status != container.Stop || !matchLabels(info.Labels, f.labels) {
+		return false
+	}
+	if f.until.IsZero() {
+		return true
+	}
+	created, err := time.ParseInLocation("2006-01-02 15:04:05", info.CreatedTime, time.Local)
+	return err == nil && created.Before(f.until)
+}
+
+// 删除所有已停止的容器，释放它们的网络、cgroup、文件系统和匿名数据卷
+func pruneContainers(filterArgs []string, force bool) error {
+	filters, err := parsePruneFilters(filterArgs, time.Now())
+	if err != nil {
+		return err
+	}
+	if !force && !confirm("WARNING! This will remove all stopped containers.") {
+		return nil
+	}
+	deleted := removeStoppedContainers(filters)
+	if len(deleted) > 0 {
+		fmt.Println("Deleted Containers:")
+		fmt.Println(strings.Join(deleted, "\n"))
+	}
+	fmt.Printf("Total reclaimed containers: %d\n", len(deleted))
+	return nil
+}
+
+// 删除满足条件的已停止容器，返回删除的容器id
+func removeStoppedContainers(filters *pruneFilters) []string {
+	var deleted []string
+	for _, info := range allContainers() {
+		if !filters.match(info) {
+			continue
+		}
+		// 匿名数据卷只属于这个容器，容器删除后不会再被用到
+		if err := removeContainer(info.Id, RemoveOptions{Volumes: true}); err != nil {
+			logrus.Errorf("remove container %s error %v", container.ShortID(info.Id), err)
+			continue
+		}
+		deleted = append(deleted, info.Id)
+	}
+	return deleted
+}
+
+// 在终端提示用户确认，输入y或yes时继续
+func confirm(warning string) bool {
+	fmt.Printf("%s\nAre you sure you want to continue? [y/N] ", warning)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
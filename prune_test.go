@@ -0,0 +1,36 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/yunfeiyang1916/cloud-docker/container"
+)
+
+func TestPruneFilters(t *testing.T) {
+	now := time.Date(2020, 1, 2, 0, 0, 0, 0, time.Local)
+	filters, err := parsePruneFilters([]string{"until=24h", "label=team=web"}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := &container.ContainerInfo{Status: container.Stop, CreatedTime: "2019-12-31 00:00:00", Labels: map[string]string{"team": "web"}}
+	if !filters.match(old) {
+		t.Error("expect old stopped container to match")
+	}
+	recent := *old
+	recent.CreatedTime = "2020-01-01 12:00:00"
+	running := *old
+	running.Status = container.Running
+	other := *old
+	other.Labels = map[string]string{"team": "db"}
+	for _, info := range []*container.ContainerInfo{&recent, &running, &other} {
+		if filters.match(info) {
+			t.Errorf("unexpected match %+v", info)
+		}
+	}
+	for _, arg := range []string{"status=exited", "until=", "until=yesterday"} {
+		if _, err = parsePruneFilters([]string{arg}, now); err == nil {
+			t.Errorf("expect error for filter %s", arg)
+		}
+	}
+}
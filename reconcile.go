@@ -0,0 +1,362 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/yunfeiyang1916/cloud-docker/cgroups"
+	"github.com/yunfeiyang1916/cloud-docker/container"
+	"github.com/yunfeiyang1916/cloud-docker/network"
+	"github.com/yunfeiyang1916/cloud-docker/store"
+)
+
+// 创建时间在这之内的残留资源可能属于正在执行的run命令，暂不处理
+const reconcileGracePeriod = time.Minute
+
+// 容器进程已经不存在，无法得知它的退出码
+const unknownExitCode = 255
+
+// 一项需要修复或清理的残留状态
+type reconcileAction struct {
+	// 资源类型
+	Kind string
+	// 资源名
+	Resource string
+	// 要执行的操作
+	Action string
+	fix    func() error
+}
+
+// 对比状态目录中的记录和实际存活的进程，修复状态并清理run命令中途被杀或宿主机重启后留下的资源
+// dryRun为true时只输出要执行的操作
+func reconcile(dryRun bool) error {
+	actions := findReconcileActions(time.Now())
+	w := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
+	if dryRun {
+		fmt.Fprint(w, "TYPE\tRESOURCE\tACTION\n")
+	} else {
+		fmt.Fprint(w, "TYPE\tRESOURCE\tACTION\tRESULT\n")
+	}
+	failed := 0
+	for _, action := range actions {
+		if dryRun {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", action.Kind, action.Resource, action.Action)
+			continue
+		}
+		result := "done"
+		if err := action.fix(); err != nil {
+			result = err.Error()
+			failed++
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", action.Kind, action.Resource, action.Action, result)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed to reconcile %d of %d resources", failed, len(actions))
+	}
+	return nil
+}
+
+// 依次检查容器状态、名字索引、pod、容器文件系统、cgroup和网络资源
+func findReconcileActions(now time.Time) []reconcileAction {
+	ids, _ := store.List()
+	// 有完整记录的容器，它们的资源由rm命令释放，读取失败的记录也不清理它的资源
+	recorded := map[string]bool{}
+	var infos []*container.ContainerInfo
+	var actions []reconcileAction
+	for _, id := range ids {
+		// 只有状态目录没有config.json说明run命令在记录容器信息之前退出了
+		// 读取容器信息时会在目录中创建锁文件，需要在这之前判断目录的修改时间
+		dir := fmt.Sprintf(container.DefaultInfoLocation, id)
+		if _, err := os.Stat(path.Join(dir, container.ConfigName)); os.IsNotExist(err) {
+			if isStale(dir, now) {
+				actions = append(actions, reconcileAction{
+					Kind:     "container",
+					Resource: id,
+					Action:   "remove incomplete state",
+					fix:      func() error { return os.RemoveAll(dir) },
+				})
+			}
+			continue
+		}
+		recorded[id] = true
+		info, err := store.Get(id)
+		if err != nil {
+			logrus.Errorf("get container %s error %v", container.ShortID(id), err)
+			continue
+		}
+		infos = append(infos, info)
+		if info.Status == container.Running && !processExists(info.Pid) {
+			actions = append(actions, markContainerStopped(info))
+		}
+	}
+	actions = append(actions, staleNameActions(recorded, now)...)
+	pods := allPods()
+	for _, pod := range pods {
+		if pod.Status == container.Running && !processExists(pod.InfraPid) {
+			actions = append(actions, markPodStopped(pod))
+		}
+	}
+	actions = append(actions, orphanLayerActions(recorded, now)...)
+	actions = append(actions, orphanCgroupActions(recorded)...)
+	return append(actions, orphanNetworkActions(infos, pods)...)
+}
+
+// 创建时间早于宽限期的文件或目录
+func isStale(file string, now time.Time) bool {
+	stat, err := os.Stat(file)
+	return err == nil && now.Sub(stat.ModTime()) > reconcileGracePeriod
+}
+
+// 状态还是运行中但进程已经退出的容器标记为停止，保留它的资源等待rm释放
+func markContainerStopped(info *container.ContainerInfo) reconcileAction {
+	return reconcileAction{
+		Kind:     "container",
+		Resource: info.Id,
+		Action:   fmt.Sprintf("mark stopped, pid %s is gone", info.Pid),
+		fix: func() error {
+			return store.Update(info.Id, func(info *container.ContainerInfo) error {
+				// 持有锁之后再确认一次，期间可能已经被stop或重新启动
+				if info.Status != container.Running || processExists(info.Pid) {
+					return nil
+				}
+				info.Status = container.Stop
+				info.Pid = ""
+				info.ExitCode = unknownExitCode
+				return nil
+			})
+		},
+	}
+}
+
+// infra进程已经退出的pod，释放它的网络端点并标记为停止
+func markPodStopped(pod *container.PodInfo) reconcileAction {
+	return reconcileAction{
+		Kind:     "pod",
+		Resource: pod.Name,
+		Action:   fmt.Sprintf("mark stopped, infra pid %s is gone", pod.InfraPid),
+		fix: func() error {
+			return store.UpdatePod(pod.Name, func(pod *container.PodInfo) error {
+				// 持有锁之后再确认一次，期间可能已经被stop或重新启动
+				if pod.Status != container.Running || processExists(pod.InfraPid) {
+					return nil
+				}
+				if pod.Network != "" && pod.Endpoint != nil {
+					network.Init()
+					if err := network.Disconnect(pod.Network, podEndpointInfo(pod)); err != nil {
+						return err
+					}
+					pod.Endpoint = nil
+				}
+				pod.Status = container.Stop
+				pod.InfraPid = ""
+				return nil
+			})
+		},
+	}
+}
+
+// 指向不存在的容器的名字索引
+func staleNameActions(recorded map[string]bool, now time.Time) []reconcileAction {
+	names, _ := store.Names()
+	var actions []reconcileAction
+	for name, id := range names {
+		if recorded[id] || !isStale(container.NameIndexLocation(name), now) {
+			continue
+		}
+		name := name
+		actions = append(actions, reconcileAction{
+			Kind:     "name",
+			Resource: name,
+			Action:   fmt.Sprintf("release, container %s does not exist", container.ShortID(id)),
+			fix:      func() error { return store.ReleaseName(name) },
+		})
+	}
+	sort.Slice(actions, func(i, j int) bool { return actions[i].Resource < actions[j].Resource })
+	return actions
+}
+
+// 不属于任何容器的挂载点、容器层和工作目录，这些目录都以容器id命名，镜像层不受影响
+func orphanLayerActions(recorded map[string]bool, now time.Time) []reconcileAction {
+	var actions []reconcileAction
+	for _, layerUrl := range []string{container.MntUrl, container.WriteLayerUrl, container.WorkLayerUrl} {
+		parent := path.Dir(layerUrl)
+		files, err := ioutil.ReadDir(parent)
+		if err != nil {
+			continue
+		}
+		for _, file := range files {
+			dir := path.Join(parent, file.Name())
+			if !file.IsDir() || !container.IsValidID(file.Name()) || recorded[file.Name()] || !isStale(dir, now) {
+				continue
+			}
+			actions = append(actions, reconcileAction{
+				Kind:     "layer",
+				Resource: dir,
+				Action:   "unmount and remove",
+				fix:      func() error { return removeMountedDir(dir) },
+			})
+		}
+	}
+	return actions
+}
+
+// 先卸载dir下的所有挂载，全部卸载成功后才删除目录
+// 挂载着数据卷时直接删除会删掉数据卷中的文件
+func removeMountedDir(dir string) error {
+	mounts, err := mountsUnder(dir)
+	if err != nil {
+		return err
+	}
+	for _, mnt := range mounts {
+		// 残留的目录不属于任何容器，按挂载的文件系统类型选择卸载方式
+		driver := container.StorageDriverOverlay
+		if strings.HasPrefix(mnt.fsType, "fuse") {
+			driver = container.StorageDriverFuseOverlay
+		}
+		if output, err := container.UnmountCommand(driver, mnt.path).CombinedOutput(); err != nil {
+			return fmt.Errorf("umount %s error %v: %s", mnt.path, err, strings.TrimSpace(string(output)))
+		}
+	}
+	return os.RemoveAll(dir)
+}
+
+// 一个挂载点和它的文件系统类型
+type mountEntry struct {
+	path   string
+	fsType string
+}
+
+// dir本身及其下的挂载点，嵌套的挂载点排在前面，需要先卸载
+func mountsUnder(dir string) ([]mountEntry, error) {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var mounts []mountEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// 格式为 id parent major:minor root mountpoint options ... - fstype source super_options
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 {
+			continue
+		}
+		if fields[4] != dir && !strings.HasPrefix(fields[4], dir+"/") {
+			continue
+		}
+		mnt := mountEntry{path: fields[4]}
+		for i := 5; i < len(fields)-1; i++ {
+			if fields[i] == "-" {
+				mnt.fsType = fields[i+1]
+				break
+			}
+		}
+		mounts = append(mounts, mnt)
+	}
+	sort.Slice(mounts, func(i, j int) bool { return len(mounts[i].path) > len(mounts[j].path) })
+	return mounts, scanner.Err()
+}
+
+// dir下有挂载点，或者dir及其子目录被其他挂载引用，比如作为overlay的lowerdir，读取挂载信息失败时也认为在使用
+func usedByMount(dir string) bool {
+	content, err := ioutil.ReadFile("/proc/self/mountinfo")
+	if err != nil {
+		return true
+	}
+	under := func(path string) bool { return path == dir || strings.HasPrefix(path, dir+"/") }
+	for _, line := range strings.Split(string(content), "\n") {
+		// 格式为 id parent major:minor root mountpoint options ... - fstype source super_options
+		fields := strings.Fields(line)
+		if len(fields) < 5 {
+			continue
+		}
+		if under(fields[4]) {
+			return true
+		}
+		for _, field := range fields[5:] {
+			for _, token := range strings.FieldsFunc(field, func(r rune) bool { return r == ',' || r == ':' || r == '=' }) {
+				if under(token) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// 不属于任何容器的cgroup，cgroup在记录容器信息之后才创建，不需要宽限期
+func orphanCgroupActions(recorded map[string]bool) []reconcileAction {
+	prefix := cgroupName("")
+	var actions []reconcileAction
+	for _, name := range cgroups.List(prefix) {
+		id := strings.TrimPrefix(name, prefix)
+		if !container.IsValidID(id) || recorded[id] {
+			continue
+		}
+		name := name
+		actions = append(actions, reconcileAction{
+			Kind:     "cgroup",
+			Resource: name,
+			Action:   "remove",
+			fix:      func() error { return cgroups.NewCgroupManager(name).Destroy() },
+		})
+	}
+	return actions
+}
+
+// 不属于任何容器或pod的veth、地址、DNAT规则和slirp4netns进程
+func orphanNetworkActions(infos []*container.ContainerInfo, pods []*container.PodInfo) []reconcileAction {
+	owners := &network.Owners{Unknown: map[string]bool{}}
+	for _, info := range infos {
+		owners.IDs = append(owners.IDs, info.Id)
+		if info.Network == "" {
+			continue
+		}
+		// 刚连接网络还没有保存端点的容器，或者旧版本创建的容器
+		if info.Endpoint == nil {
+			owners.Unknown[info.Network] = true
+			continue
+		}
+		owners.IPs = append(owners.IPs, info.Endpoint.IPAddress)
+	}
+	for _, pod := range pods {
+		owners.IDs = append(owners.IDs, pod.Id)
+		if pod.Network == "" || pod.Status != container.Running {
+			continue
+		}
+		if pod.Endpoint == nil {
+			owners.Unknown[pod.Network] = true
+			continue
+		}
+		owners.IPs = append(owners.IPs, pod.Endpoint.IPAddress)
+	}
+	if err := network.Init(); err != nil {
+		logrus.Errorf("load networks error %v", err)
+		return nil
+	}
+	var actions []reconcileAction
+	for _, orphan := range network.FindOrphans(owners) {
+		action := "remove"
+		if orphan.Kind == "ip" {
+			action = "release"
+		}
+		actions = append(actions, reconcileAction{
+			Kind:     orphan.Kind,
+			Resource: orphan.Name,
+			Action:   action,
+			fix:      orphan.Remove,
+		})
+	}
+	return actions
+}
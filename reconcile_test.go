@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/yunfeiyang1916/cloud-docker/container"
+	"github.com/yunfeiyang1916/cloud-docker/store"
+)
+
+func TestIsStale(t *testing.T) {
+	root, cleanup := setupRoots(t)
+	defer cleanup()
+	now := time.Now()
+	if isStale(root, now) {
+		t.Error("just created dir should not be stale")
+	}
+	if !isStale(root, now.Add(2*reconcileGracePeriod)) {
+		t.Error("dir older than the grace period should be stale")
+	}
+	if isStale(filepath.Join(root, "missing"), now.Add(2*reconcileGracePeriod)) {
+		t.Error("missing file should not be stale")
+	}
+}
+
+func TestFindReconcileActions(t *testing.T) {
+	_, cleanup := setupRoots(t)
+	defer cleanup()
+	recorded := fmt.Sprintf("%064d", 1)
+	orphan := fmt.Sprintf("%064d", 2)
+	incomplete := fmt.Sprintf("%064d", 3)
+	if err := store.Create(&container.ContainerInfo{Id: recorded, Name: "web", Status: container.Stop}); err != nil {
+		t.Fatal(err)
+	}
+	for name, id := range map[string]string{"web": recorded, "ghost": orphan} {
+		if err := store.ReserveName(name, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// run命令在记录容器信息之前退出时只留下状态目录
+	dirs := []string{
+		fmt.Sprintf(container.DefaultInfoLocation, incomplete),
+		fmt.Sprintf(container.MntUrl, recorded),
+		fmt.Sprintf(container.MntUrl, orphan),
+		fmt.Sprintf(container.WriteLayerUrl, orphan),
+		fmt.Sprintf(container.MntUrl, "not-a-container"),
+	}
+	for _, dir := range dirs {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 宽限期内的资源可能属于正在启动的容器，不做处理
+	if actions := stateActions(findReconcileActions(time.Now())); len(actions) != 0 {
+		t.Fatalf("expect no actions within the grace period, got %v", actions)
+	}
+
+	actions := stateActions(findReconcileActions(time.Now().Add(2 * reconcileGracePeriod)))
+	var got []string
+	for _, action := range actions {
+		got = append(got, action.Kind+" "+action.Resource)
+	}
+	sort.Strings(got)
+	expected := []string{
+		"container " + incomplete,
+		"layer " + fmt.Sprintf(container.MntUrl, orphan),
+		"layer " + fmt.Sprintf(container.WriteLayerUrl, orphan),
+		"name ghost",
+	}
+	if fmt.Sprint(got) != fmt.Sprint(expected) {
+		t.Fatalf("unexpected actions\n got %v\nwant %v", got, expected)
+	}
+	for _, action := range actions {
+		if err := action.fix(); err != nil {
+			t.Fatalf("fix %s %s error %v", action.Kind, action.Resource, err)
+		}
+	}
+	for _, dir := range dirs[:3] {
+		_, err := os.Stat(dir)
+		if exists := err == nil; exists != (dir == dirs[1]) {
+			t.Errorf("unexpected state of %s after reconcile: %v", dir, err)
+		}
+	}
+	names, _ := store.Names()
+	if len(names) != 1 || names["web"] != recorded {
+		t.Errorf("only the stale name should be released, got %v", names)
+	}
+}
+
+// 只保留容器状态、名字索引和容器层的操作，cgroup和网络资源取决于宿主机
+func stateActions(actions []reconcileAction) []reconcileAction {
+	var filtered []reconcileAction
+	for _, action := range actions {
+		switch action.Kind {
+		case "container", "name", "layer":
+			filtered = append(filtered, action)
+		}
+	}
+	return filtered
+}
@@ -1,74 +1,223 @@
 package main
 
 import (
-	"encoding/json"
 	"fmt"
 	"github.com/yunfeiyang1916/cloud-docker/network"
-	"math/rand"
+	"net"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/sirupsen/logrus"
-	"github.com/yunfeiyang1916/cloud-docker/cgroups/subsystems"
+	"github.com/yunfeiyang1916/cloud-docker/cgroups"
 	"github.com/yunfeiyang1916/cloud-docker/container"
+	"github.com/yunfeiyang1916/cloud-docker/store"
 )
 
-// Run 执行run命令
-func Run(tty bool, cmdArray []string, res *subsystems.ResourceConfig, containerName, volume, imageName string, envSlice []string, nw string, portmapping []string) {
-	containerID := randStringBytes(10)
-	if containerName == "" {
-		containerName = containerID
-	}
-	parent, writePipe := container.NewParentProcess(tty, containerName, volume, imageName, envSlice)
-	if parent == nil {
-		logrus.Errorf("New parent process error")
+// Run 执行run命令，containerInfo中是命令行参数解析出的容器配置，id、名字和运行时的状态在这里补全
+func Run(containerInfo *container.ContainerInfo, cmdArray []string, detachKeys []byte) {
+	containerID := container.GenerateID()
+	if containerInfo.Name == "" {
+		containerInfo.Name = container.ShortID(containerID)
+	}
+	containerName := containerInfo.Name
+	// 容器名必须唯一，先占用名字，启动失败时再释放
+	if err := store.ReserveName(containerName, containerID); err != nil {
+		logrus.Errorf("%v", err)
+		return
+	}
+	recorded := false
+	defer func() {
+		if !recorded {
+			store.ReleaseName(containerName)
+		}
+	}()
+	containerInfo.Id = containerID
+	containerInfo.Command = strings.Join(cmdArray, " ")
+	volume, pod, tty, nw := containerInfo.Volume, containerInfo.Pod, containerInfo.Tty, containerInfo.Network
+	// -v只给出容器内目录时创建匿名数据卷，rm -v时一起删除
+	if volume != "" && !strings.Contains(volume, ":") {
+		hostDir := fmt.Sprintf(container.VolumesUrl, containerID)
+		if err := os.MkdirAll(filepath.Dir(hostDir), 0777); err != nil {
+			logrus.Errorf("create volume dir error %v", err)
+			return
+		}
+		volume = hostDir + ":" + volume
+		containerInfo.Volume = volume
+		containerInfo.AnonymousVolume = true
+	}
+	// pod还没有启动时先启动它的infra进程
+	if pod != "" {
+		if err := ensurePodRunning(pod); err != nil {
+			logrus.Errorf("start pod %s error %v", pod, err)
+			return
+		}
+	}
+	// 找到需要加入的其他容器的namespace
+	nsPaths, err := resolveNamespaces(containerInfo.Namespaces)
+	if err != nil {
+		logrus.Errorf("resolve namespaces error %v", err)
 		return
 	}
-	// 这里的 Start 方法是真正执行前面创建好的 command 的调用，它首先会克隆出来 namespace 隔离的进程，
-	// 然后在子进程中，调用/proc/self/exe ，也就是调用自己，发送 init 参数，调用我们写的init方法，去初始化容器的一些资源。
-	if err := parent.Start(); err != nil {
-		logrus.Errorf("parent.Run() error,err=%s", err)
+	// init进程从这个管道读取用户命令，容器的资源都设置好之后再发送
+	readPipe, writePipe, err := container.NewPipe()
+	if err != nil {
+		logrus.Errorf("New pipe error %v", err)
 		return
 	}
+	// 容器进程由monitor进程启动，run命令退出后monitor继续等待容器退出并记录退出码
+	mon, err := startMonitor(containerInfo, nsPaths, readPipe)
+	readPipe.Close()
+	if err != nil {
+		logrus.Errorf("start container error %v", err)
+		writePipe.Close()
+		// monitor可能已经创建了容器的文件系统
+		abortRun(containerInfo, 0, false)
+		return
+	}
+	initPid := mon.InitPid
+	// 之后的步骤失败时结束容器进程并释放已经创建的资源
+	started := false
+	defer func() {
+		if !started {
+			writePipe.Close()
+			mon.Close()
+			abortRun(containerInfo, initPid, recorded)
+		}
+	}()
 	// 记录容器信息
-	containerName, err := recordContainerInfo(parent.Process.Pid, cmdArray, containerName, containerID, volume)
+	err = recordContainerInfo(initPid, containerInfo)
+	// 容器的状态已经记录下来，容器退出后由monitor更新
+	mon.Release()
 	if err != nil {
 		logrus.Errorf("record container info error %s", err)
 		return
 	}
-	// 使用cloud-docker-cgroup 作为cgroup名称
+	recorded = true
+	if pod != "" {
+		if err := addPodContainer(pod, containerID); err != nil {
+			logrus.Errorf("add container %s to pod %s error %v", containerName, pod, err)
+		}
+	}
+	// 每个容器使用单独的cgroup，rootless模式下位于systemd委派给当前用户的cgroup v2子树中
 	// 创建cgroup manager,并通过调用set和apply设置资源限制并限制在容器生效
-	//cgroupManager := cgroups.NewCgroupManager("cloud-docker-cgroup")
-	//defer cgroupManager.Destroy()
-	//// 设置资源限制
-	//cgroupManager.Set(res)
-	//// 将容器进程加入到各个subsystem挂载对应的cgroup中
-	//cgroupManager.Apply(parent.Process.Pid)
+	cgroupManager := cgroups.NewCgroupManager(cgroupName(containerID))
+	// 设置资源限制
+	cgroupManager.Set(containerInfo.Resources)
+	// 将容器进程加入到各个subsystem挂载对应的cgroup中
+	cgroupManager.Apply(initPid)
 
 	if nw != "" {
 		network.Init()
-		containerInfo := &container.ContainerInfo{
-			Id:          containerID,
-			Pid:         strconv.Itoa(parent.Process.Pid),
-			Name:        containerName,
-			PortMapping: portmapping,
-		}
 		if err := network.Connect(nw, containerInfo); err != nil {
 			logrus.Errorf("Error Connect Network %v", err)
 			return
 		}
+		// 保存网络驱动分配的地址
+		if err := store.Update(containerID, func(info *container.ContainerInfo) error {
+			info.Endpoint = containerInfo.Endpoint
+			return nil
+		}); err != nil {
+			logrus.Errorf("record container endpoint error %v", err)
+		}
 	}
 
+	// 交互式运行时先连接上容器，再让容器开始执行命令，避免丢失最开始的输出
+	var conn net.Conn
+	if tty {
+		if conn, err = dialContainer(containerID); err != nil {
+			logrus.Errorf("attach container error %v", err)
+			return
+		}
+	}
 	// 对容器设置完限制后，初始化容器
 	sendInitCommand(cmdArray, writePipe)
-	if tty {
-		// 如果是交互式的，父进程需要等待子进程结束
-		parent.Wait()
-		deleteContainerInfo(containerName)
-		container.DeleteWorkSpace(volume, containerName)
+	started = true
+	if !tty {
+		// 后台运行时输出完整id，之后的命令可以用它、它的唯一前缀或容器名引用容器
+		mon.Close()
+		fmt.Println(containerID)
+		return
 	}
+	// 如果是交互式的，父进程连接容器的终端并等待容器结束，detach后容器继续在后台运行
+	if err = attachStreams(conn, true, detachKeys); err == errDetached {
+		mon.Close()
+		logrus.Infof("detached from container %s", containerName)
+		return
+	} else if err != nil {
+		logrus.Errorf("attach container error %v", err)
+	}
+	// 等待monitor记录容器的退出码
+	mon.Wait()
+	// 容器退出后释放它的所有资源，之后也不会再有rm -v，匿名数据卷一起删除
+	if err = store.Delete(containerID, func(info *container.ContainerInfo) error {
+		releaseContainer(info, true)
+		return nil
+	}); err != nil {
+		logrus.Errorf("remove container %s error %v", containerName, err)
+	}
+}
+
+// 容器启动失败时结束已经创建的容器进程，释放容器的文件系统、cgroup、网络等资源，
+// 已经记录的状态一并删除，之后不会留下状态为running的容器
+func abortRun(info *container.ContainerInfo, initPid int, recorded bool) {
+	if initPid > 0 {
+		syscall.Kill(initPid, syscall.SIGKILL)
+		waitProcessExit(strconv.Itoa(initPid), killTimeout)
+	}
+	if recorded {
+		if err := store.Delete(info.Id, func(info *container.ContainerInfo) error {
+			releaseContainer(info, true)
+			return nil
+		}); err != nil {
+			logrus.Errorf("remove container %s error %v", info.Name, err)
+		}
+		return
+	}
+	releaseContainer(info, true)
+	os.RemoveAll(fmt.Sprintf(container.DefaultInfoLocation, info.Id))
+}
+
+// 把container:<name>形式的namespace参数解析为对应容器init进程的namespace文件
+func resolveNamespaces(namespaces map[string]string) (map[string]string, error) {
+	nsPaths := map[string]string{}
+	for nsType, mode := range namespaces {
+		if err := container.ParseNamespaceMode(nsType, mode); err != nil {
+			return nil, err
+		}
+		switch {
+		case strings.HasPrefix(mode, container.NamespaceContainerPrefix):
+			name := strings.TrimPrefix(mode, container.NamespaceContainerPrefix)
+			info, err := getContainerInfo(name)
+			if err != nil {
+				return nil, err
+			}
+			if info.Status != container.Running {
+				return nil, fmt.Errorf("container %s is not running", name)
+			}
+			nsPaths[nsType] = container.NamespacePath(info.Pid, nsType)
+		case strings.HasPrefix(mode, container.NamespacePodPrefix):
+			name := strings.TrimPrefix(mode, container.NamespacePodPrefix)
+			pod, err := store.GetPod(name)
+			if err != nil {
+				return nil, err
+			}
+			if pod.Status != container.Running {
+				return nil, fmt.Errorf("pod %s is not running", name)
+			}
+			nsPaths[nsType] = container.NamespacePath(pod.InfraPid, nsType)
+		}
+		// 共享宿主机的namespace不需要加入
+	}
+	return nsPaths, nil
+}
+
+// 容器对应的cgroup名称
+func cgroupName(containerID string) string {
+	return "cloud-docker-" + containerID
 }
 
 // 通过匿名管道向初始化进程发送命令
@@ -80,57 +229,9 @@ func sendInitCommand(cmdArray []string, writePipe *os.File) {
 }
 
 // 记录容器信息
-func recordContainerInfo(containerPID int, cmdArray []string, containerName, id, volume string) (string, error) {
-	now := time.Now().Format("2006-01-02 15:04:05")
-	command := strings.Join(cmdArray, "")
-	info := &container.ContainerInfo{
-		Pid:         strconv.Itoa(containerPID),
-		Id:          id,
-		Name:        containerName,
-		Command:     command,
-		CreatedTime: now,
-		Status:      container.Running,
-		Volume:      volume,
-	}
-	buf, err := json.Marshal(info)
-	if err != nil {
-		logrus.Errorf("json.Marshal error,%s", err)
-		return "", err
-	}
-	dirPath := fmt.Sprintf(container.DefaultInfoLocation, containerName)
-	if err = os.MkdirAll(dirPath, 0622); err != nil {
-		logrus.Errorf("MkdirAll %s error %s", dirPath, err)
-		return "", err
-	}
-	fileName := dirPath + "/" + container.ConfigName
-	// 创建配置文件
-	file, err := os.Create(fileName)
-	if err != nil {
-		logrus.Errorf("Create file %s error %s", fileName, err)
-		return "", err
-	}
-	defer file.Close()
-	if _, err = file.Write(buf); err != nil {
-		logrus.Errorf("file write error %s", err)
-		return "", err
-	}
-	return containerName, nil
-}
-
-func deleteContainerInfo(containerName string) {
-	dirPath := fmt.Sprintf(container.DefaultInfoLocation, containerName)
-	if err := os.RemoveAll(dirPath); err != nil {
-		logrus.Errorf("remove all dir %s error %s", dirPath, err)
-	}
-}
-
-// 生成随机id
-func randStringBytes(n int) string {
-	letterBytes := "1234567890"
-	rand.Seed(time.Now().UnixNano())
-	b := make([]byte, n)
-	for i := range b {
-		b[i] = letterBytes[rand.Intn(len(letterBytes))]
-	}
-	return string(b)
+func recordContainerInfo(containerPID int, info *container.ContainerInfo) error {
+	info.Pid = strconv.Itoa(containerPID)
+	info.CreatedTime = time.Now().Format("2006-01-02 15:04:05")
+	info.Status = container.Running
+	return store.Create(info)
 }
@@ -1,63 +1,128 @@
 package main
 
 import (
-	"encoding/json"
 	"fmt"
 	"github.com/sirupsen/logrus"
+	"github.com/yunfeiyang1916/cloud-docker/cgroups"
 	"github.com/yunfeiyang1916/cloud-docker/container"
-	"io/ioutil"
+	"github.com/yunfeiyang1916/cloud-docker/network"
+	"github.com/yunfeiyang1916/cloud-docker/store"
 	"os"
 	"strconv"
 	"syscall"
+	"time"
 )
 
+// stop和强制删除时等待容器进程退出的最长时间
+const killTimeout = 5 * time.Second
+
+// RemoveOptions rm命令的参数
+type RemoveOptions struct {
+	// 先停止运行中的容器再删除
+	Force bool
+	// 同时删除容器的匿名数据卷
+	Volumes bool
+}
+
 func stopContainer(containerName string) {
-	info, err := getContainerInfo(containerName)
+	id, err := store.Resolve(containerName)
 	if err != nil {
-		logrus.Errorf("Get container info by name %s error %v", containerName, err)
+		logrus.Errorf("Get container info %s error %v", containerName, err)
 		return
 	}
+	// 持有容器的锁发送信号并修改状态，避免与同时执行的rm等命令交错
+	err = store.Update(id, func(info *container.ContainerInfo) error {
+		pid, err := strconv.Atoi(info.Pid)
+		if err != nil {
+			return fmt.Errorf("conver pid from string to int error %v", err)
+		}
+		// 系统调用kill可以发送信号给进程，通过传递syscall.SIGTERM信号，kill掉容器住进程
+		if err = syscall.Kill(pid, syscall.SIGTERM); err != nil {
+			return err
+		}
+		info.ExitCode = 128 + int(syscall.SIGTERM)
+		// 容器内的1号进程没有处理SIGTERM时不会退出，超时后强制结束
+		if !waitProcessExit(info.Pid, killTimeout) {
+			syscall.Kill(pid, syscall.SIGKILL)
+			waitProcessExit(info.Pid, killTimeout)
+			info.ExitCode = 128 + int(syscall.SIGKILL)
+		}
+		// 至此，容器进程已经被kill，所以下面需要修改容器的状态,PID可以置为空
+		// monitor进程回收容器进程后会记录真正的退出码
+		info.Status = container.Stop
+		info.Pid = ""
+		return nil
+	})
+	if err != nil {
+		logrus.Errorf("Stop container %s error %v", containerName, err)
+	}
+}
+
+func removeContainer(containerName string, opts RemoveOptions) error {
+	id, err := store.Resolve(containerName)
+	if err != nil {
+		return err
+	}
+	// 在持有锁的情况下释放容器的资源，之后将所有信息包括子目录和名字索引都移除
+	return store.Delete(id, func(info *container.ContainerInfo) error {
+		// 默认只删除处于停止状态的容器
+		if info.Status == container.Running {
+			if !opts.Force {
+				return fmt.Errorf("couldn't remove running container %s, stop it first or use -f", containerName)
+			}
+			killContainer(info)
+		}
+		releaseContainer(info, opts.Volumes)
+		return nil
+	})
+}
+
+// 向容器进程发送SIGKILL并等待它退出，之后才能卸载容器的文件系统
+func killContainer(info *container.ContainerInfo) {
 	pid, err := strconv.Atoi(info.Pid)
 	if err != nil {
-		logrus.Errorf("Conver pid from string to int error %v", err)
 		return
 	}
-	// 系统调用kill可以发送信号给进程，通过传递syscall.SIGTERM信号，kill掉容器住进程
-	if err = syscall.Kill(pid, syscall.SIGTERM); err != nil {
-		logrus.Errorf("Stop container %s error %v", containerName, err)
+	if err = syscall.Kill(pid, syscall.SIGKILL); err != nil {
 		return
 	}
-	// 至此，容器进程已经被kill，所以下面需要修改容器的状态,PID可以置为空
+	waitProcessExit(info.Pid, killTimeout)
 	info.Status = container.Stop
 	info.Pid = ""
-	buf, err := json.Marshal(info)
-	if err != nil {
-		logrus.Errorf("Json marshal %s error %v", containerName, err)
-		return
-	}
-	filePath := fmt.Sprintf(container.DefaultInfoLocation, containerName) + container.ConfigName
-	// 重新写入新的数据覆盖原来的信息
-	if err = ioutil.WriteFile(filePath, buf, 0622); err != nil {
-		logrus.Errorf("Write file %s error %v", filePath, err)
+	info.ExitCode = 128 + int(syscall.SIGKILL)
+}
+
+// 等待进程退出，超时返回false
+func waitProcessExit(pid string, timeout time.Duration) bool {
+	for deadline := time.Now().Add(timeout); processExists(pid); time.Sleep(20 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			return false
+		}
 	}
+	return true
 }
 
-func removeContainer(containerName string) {
-	info, err := getContainerInfo(containerName)
-	if err != nil {
-		logrus.Errorf("Get container info by name %s error %v", containerName, err)
-		return
+// 按照与创建时相反的顺序释放容器占用的资源：
+// 网络端点、端口映射和IP地址，cgroup，所属pod中的记录，容器的文件系统，最后是匿名数据卷
+func releaseContainer(info *container.ContainerInfo, removeVolumes bool) {
+	if info.Network != "" {
+		network.Init()
+		if err := network.Disconnect(info.Network, info); err != nil {
+			logrus.Errorf("disconnect network %s error %v", info.Network, err)
+		}
 	}
-	// 只删除处于停止状态的容器
-	if info.Status != container.Stop {
-		logrus.Errorf("Couldn't remove running container")
-		return
+	cgroups.NewCgroupManager(cgroupName(info.Id)).Destroy()
+	if info.Pod != "" {
+		if err := removePodContainer(info.Pod, info.Id); err != nil {
+			logrus.Errorf("remove container %s from pod %s error %v", info.Name, info.Pod, err)
+		}
 	}
-	dirPath := fmt.Sprintf(container.DefaultInfoLocation, containerName)
-	// 将所有信息包括子目录都移除
-	if err = os.RemoveAll(dirPath); err != nil {
-		logrus.Errorf("remove file %s error %v", dirPath, err)
-		return
+	container.DeleteWorkSpace(info.Volume, info.Id, info.StorageDriverName())
+	if removeVolumes && info.AnonymousVolume {
+		if parts := container.VolumeUrls(info.Volume); parts != nil {
+			if err := os.RemoveAll(parts[0]); err != nil {
+				logrus.Errorf("remove volume %s error %v", parts[0], err)
+			}
+		}
 	}
-	container.DeleteWorkSpace(info.Volume, containerName)
 }
@@ -0,0 +1,57 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/yunfeiyang1916/cloud-docker/container"
+	"github.com/yunfeiyang1916/cloud-docker/logger"
+)
+
+// Version 当前状态文件的格式版本，修改ContainerInfo中已有字段的含义时需要增加版本并添加迁移
+const Version = 1
+
+// 第i个迁移把版本i的状态升级到版本i+1，没有version字段的是加入版本之前写入的状态，即版本0
+// 只有以64位十六进制id命名的状态目录会被加载，更早的以容器名命名状态目录、使用10位id的容器不会迁移，
+// 升级之前需要用旧版本删除这些容器
+var migrations = []func(state map[string]interface{}){
+	migrateV0,
+}
+
+// 版本0的状态文件没有记录日志驱动和cgroup namespace，
+// 当时日志固定写为json-file，容器也与宿主机共享cgroup namespace
+func migrateV0(state map[string]interface{}) {
+	if config, ok := state["logConfig"].(map[string]interface{}); !ok || config["type"] == "" || config["type"] == nil {
+		state["logConfig"] = map[string]interface{}{"type": logger.JSONFileDriverName}
+	}
+	if _, ok := state["cgroupns"]; !ok {
+		state["cgroupns"] = container.NamespaceHost
+	}
+}
+
+// 解析状态文件，旧版本的状态先依次迁移到当前版本，迁移结果在下一次写入时保存
+func decode(content []byte) (*container.ContainerInfo, error) {
+	var state map[string]interface{}
+	if err := json.Unmarshal(content, &state); err != nil {
+		return nil, err
+	}
+	version := 0
+	if v, ok := state["version"].(float64); ok {
+		version = int(v)
+	}
+	if version > Version {
+		return nil, fmt.Errorf("state version %d is newer than supported version %d", version, Version)
+	}
+	for ; version < Version; version++ {
+		migrations[version](state)
+	}
+	buf, err := json.Marshal(state)
+	if err != nil {
+		return nil, err
+	}
+	var info container.ContainerInfo
+	if err = json.Unmarshal(buf, &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
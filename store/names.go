@@ -0,0 +1,93 @@
+package store
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/yunfeiyang1916/cloud-docker/container"
+)
+
+// ReserveName 在名字索引中记录容器名对应的id，使用O_EXCL创建索引文件，同时运行的两个run命令不会拿到同一个名字
+func ReserveName(name, id string) error {
+	if err := container.ValidateName(name); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(containerDir(container.NamesDir), 0622); err != nil {
+		return err
+	}
+	indexPath := container.NameIndexLocation(name)
+	file, err := os.OpenFile(indexPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0622)
+	if err != nil {
+		if os.IsExist(err) {
+			owner, _ := ioutil.ReadFile(indexPath)
+			return fmt.Errorf("container name %s is already in use by container %s", name, container.ShortID(string(owner)))
+		}
+		return err
+	}
+	defer file.Close()
+	_, err = file.WriteString(id)
+	return err
+}
+
+// ReleaseName 删除容器名索引，之后这个名字可以再次使用
+func ReleaseName(name string) error {
+	if err := os.Remove(container.NameIndexLocation(name)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Names 名字索引中的所有容器名，key是容器名，value是容器id
+func Names() (map[string]string, error) {
+	files, err := ioutil.ReadDir(containerDir(container.NamesDir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	names := map[string]string{}
+	for _, file := range files {
+		if id, err := ioutil.ReadFile(container.NameIndexLocation(file.Name())); err == nil {
+			names[file.Name()] = string(id)
+		}
+	}
+	return names, nil
+}
+
+// Resolve 按完整id、容器名、唯一的id前缀的顺序查找容器id
+func Resolve(ref string) (string, error) {
+	if ref == "" {
+		return "", fmt.Errorf("empty container name or id")
+	}
+	if container.IsValidID(ref) {
+		if _, err := os.Stat(containerDir(ref)); err == nil {
+			return ref, nil
+		}
+	}
+	if container.ValidateName(ref) == nil {
+		if id, err := ioutil.ReadFile(container.NameIndexLocation(ref)); err == nil {
+			return string(id), nil
+		}
+	}
+	ids, err := List()
+	if err != nil {
+		return "", err
+	}
+	match := ""
+	for _, id := range ids {
+		if !strings.HasPrefix(id, ref) {
+			continue
+		}
+		if match != "" {
+			return "", fmt.Errorf("multiple containers match id prefix %s", ref)
+		}
+		match = id
+	}
+	if match == "" {
+		return "", fmt.Errorf("no such container: %s", ref)
+	}
+	return match, nil
+}
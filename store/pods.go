@@ -0,0 +1,149 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"syscall"
+
+	"github.com/yunfeiyang1916/cloud-docker/container"
+)
+
+// pod的状态和容器一样保存在带锁文件的状态目录中，写入时先写临时文件再rename
+
+// pod名会作为目录名，校验后才能拼接路径
+func podDir(name string) (string, error) {
+	if err := container.ValidateName(name); err != nil {
+		return "", err
+	}
+	return container.PodInfoLocation(name), nil
+}
+
+func lockPod(name string, how int) (*os.File, error) {
+	dir, err := podDir(name)
+	if err != nil {
+		return nil, err
+	}
+	f, err := lockDir(dir, how)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("no such pod: %s", name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("lock pod %s error %v", name, err)
+	}
+	return f, nil
+}
+
+// 调用方需要持有锁
+func readPod(name string) (*container.PodInfo, error) {
+	content, err := ioutil.ReadFile(container.PodInfoLocation(name) + container.ConfigName)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("no such pod: %s", name)
+		}
+		return nil, err
+	}
+	var pod container.PodInfo
+	if err = json.Unmarshal(content, &pod); err != nil {
+		return nil, err
+	}
+	return &pod, nil
+}
+
+// 调用方需要持有锁
+func writePod(pod *container.PodInfo) error {
+	buf, err := json.Marshal(pod)
+	if err != nil {
+		return err
+	}
+	return writeFile(container.PodInfoLocation(pod.Name), buf)
+}
+
+// CreatePod 保存新pod的状态，同名的pod已经存在时返回错误
+// 用os.Mkdir创建状态目录，同时创建同名pod时只有一个能成功
+func CreatePod(pod *container.PodInfo) error {
+	dir, err := podDir(pod.Name)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(fmt.Sprintf(container.DefaultInfoLocation, container.PodsDir), 0755); err != nil {
+		return err
+	}
+	if err = os.Mkdir(dir, 0755); err != nil {
+		if os.IsExist(err) {
+			return fmt.Errorf("pod %s already exists", pod.Name)
+		}
+		return err
+	}
+	f, err := lockPod(pod.Name, syscall.LOCK_EX)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return writePod(pod)
+}
+
+// GetPod 读取pod的状态
+func GetPod(name string) (*container.PodInfo, error) {
+	f, err := lockPod(name, syscall.LOCK_SH)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return readPod(name)
+}
+
+// UpdatePod 在持有锁的情况下读取pod状态并交给fn修改，fn返回nil时写回
+func UpdatePod(name string, fn func(pod *container.PodInfo) error) error {
+	f, err := lockPod(name, syscall.LOCK_EX)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	pod, err := readPod(name)
+	if err != nil {
+		return err
+	}
+	if err = fn(pod); err != nil {
+		return err
+	}
+	return writePod(pod)
+}
+
+// DeletePod 在持有锁的情况下交给fn检查pod是否可以删除，fn返回nil时删除状态目录
+func DeletePod(name string, fn func(pod *container.PodInfo) error) error {
+	f, err := lockPod(name, syscall.LOCK_EX)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	pod, err := readPod(name)
+	if err != nil {
+		return err
+	}
+	if fn != nil {
+		if err = fn(pod); err != nil {
+			return err
+		}
+	}
+	return os.RemoveAll(container.PodInfoLocation(name))
+}
+
+// ListPods 所有pod的名字
+func ListPods() ([]string, error) {
+	files, err := ioutil.ReadDir(fmt.Sprintf(container.DefaultInfoLocation, container.PodsDir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var names []string
+	for _, file := range files {
+		if file.IsDir() && container.ValidateName(file.Name()) == nil {
+			names = append(names, file.Name())
+		}
+	}
+	return names, nil
+}
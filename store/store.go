@@ -0,0 +1,174 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/yunfeiyang1916/cloud-docker/container"
+)
+
+// LockName 容器状态目录下的锁文件，读写config.json之前需要对它加flock
+const LockName = "state.lock"
+
+// 写入状态文件时带上格式版本，读取时按版本迁移
+type record struct {
+	Version int `json:"version"`
+	*container.ContainerInfo
+}
+
+func containerDir(id string) string {
+	return fmt.Sprintf(container.DefaultInfoLocation, id)
+}
+
+// 对容器的锁文件加锁，how为syscall.LOCK_SH或syscall.LOCK_EX，关闭返回的文件即释放锁
+// 状态目录不存在说明容器已经被删除，不会重新创建
+func lock(id string, how int) (*os.File, error) {
+	f, err := lockDir(containerDir(id), how)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("no such container: %s", id)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("lock container %s error %v", id, err)
+	}
+	return f, nil
+}
+
+// 对状态目录dir下的锁文件加锁，容器和pod共用
+func lockDir(dir string, how int) (*os.File, error) {
+	f, err := os.OpenFile(filepath.Join(dir, LockName), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err = syscall.Flock(int(f.Fd()), how); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// 调用方需要持有锁
+func read(id string) (*container.ContainerInfo, error) {
+	content, err := ioutil.ReadFile(containerDir(id) + container.ConfigName)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("no such container: %s", id)
+		}
+		return nil, err
+	}
+	return decode(content)
+}
+
+// 调用方需要持有锁
+func write(info *container.ContainerInfo) error {
+	buf, err := json.Marshal(record{Version: Version, ContainerInfo: info})
+	if err != nil {
+		return err
+	}
+	return writeFile(containerDir(info.Id), buf)
+}
+
+// 先写临时文件再rename覆盖，进程在写入过程中退出也不会留下不完整的状态文件
+func writeFile(dir string, buf []byte) error {
+	tmp, err := ioutil.TempFile(dir, container.ConfigName+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(buf); err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(dir, container.ConfigName))
+}
+
+// Create 保存新容器的状态，状态目录不存在时创建
+func Create(info *container.ContainerInfo) error {
+	if err := os.MkdirAll(containerDir(info.Id), 0622); err != nil {
+		return err
+	}
+	f, err := lock(info.Id, syscall.LOCK_EX)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return write(info)
+}
+
+// Get 读取容器的状态
+func Get(id string) (*container.ContainerInfo, error) {
+	f, err := lock(id, syscall.LOCK_SH)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return read(id)
+}
+
+// Update 在持有锁的情况下读取容器状态并交给fn修改，fn返回nil时写回
+// 对容器进程的操作也应该放在fn中，避免和其他命令同时修改同一个容器
+func Update(id string, fn func(info *container.ContainerInfo) error) error {
+	f, err := lock(id, syscall.LOCK_EX)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := read(id)
+	if err != nil {
+		return err
+	}
+	if err = fn(info); err != nil {
+		return err
+	}
+	return write(info)
+}
+
+// Delete 在持有锁的情况下交给fn释放容器的资源，fn返回nil时删除状态目录和名字索引
+// 等待同一把锁的其他命令拿到锁后会发现状态文件已经不存在
+func Delete(id string, fn func(info *container.ContainerInfo) error) error {
+	f, err := lock(id, syscall.LOCK_EX)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := read(id)
+	if err != nil {
+		return err
+	}
+	if fn != nil {
+		if err = fn(info); err != nil {
+			return err
+		}
+	}
+	if err = os.RemoveAll(containerDir(id)); err != nil {
+		return err
+	}
+	ReleaseName(info.Name)
+	return nil
+}
+
+// List 所有容器的id，状态目录下还有pod、网络和名字索引等目录，只取以完整id命名的目录
+func List() ([]string, error) {
+	files, err := ioutil.ReadDir(containerDir(""))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var ids []string
+	for _, file := range files {
+		if file.IsDir() && container.IsValidID(file.Name()) {
+			ids = append(ids, file.Name())
+		}
+	}
+	return ids, nil
+}
@@ -0,0 +1,139 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/yunfeiyang1916/cloud-docker/container"
+)
+
+func setupRoot(t *testing.T) func() {
+	root, err := ioutil.TempDir("", "cloud-docker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	location := container.DefaultInfoLocation
+	container.DefaultInfoLocation = root + "/%s/"
+	return func() {
+		container.DefaultInfoLocation = location
+		os.RemoveAll(root)
+	}
+}
+
+func TestResolve(t *testing.T) {
+	defer setupRoot(t)()
+	web := "abc" + fmt.Sprintf("%061d", 1)
+	db := "abd" + fmt.Sprintf("%061d", 2)
+	for _, id := range []string{web, db} {
+		if err := Create(&container.ContainerInfo{Id: id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ReserveName("web", web); err != nil {
+		t.Fatal(err)
+	}
+	if err := ReserveName("web", db); err == nil {
+		t.Fatal("expect error for duplicate name")
+	}
+	cases := map[string]string{web: web, "web": web, "abd": db, "abc0": web}
+	for ref, expected := range cases {
+		id, err := Resolve(ref)
+		if err != nil {
+			t.Fatalf("resolve %s error %v", ref, err)
+		}
+		if id != expected {
+			t.Fatalf("resolve %s got %s, expected %s", ref, id, expected)
+		}
+	}
+	for _, ref := range []string{"ab", "xyz", ""} {
+		if _, err := Resolve(ref); err == nil {
+			t.Fatalf("expect error for %q", ref)
+		}
+	}
+	ReleaseName("web")
+	if _, err := Resolve("web"); err == nil {
+		t.Fatal("expect error after name released")
+	}
+}
+
+func TestUpdateAndDelete(t *testing.T) {
+	defer setupRoot(t)()
+	id := container.GenerateID()
+	if err := ReserveName("web", id); err != nil {
+		t.Fatal(err)
+	}
+	if err := Create(&container.ContainerInfo{Id: id, Name: "web", Pid: "0"}); err != nil {
+		t.Fatal(err)
+	}
+	// 并发更新不会丢失修改
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := Update(id, func(info *container.ContainerInfo) error {
+				var n int
+				fmt.Sscanf(info.Pid, "%d", &n)
+				info.Pid = fmt.Sprint(n + 1)
+				return nil
+			})
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	info, err := Get(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Pid != "20" {
+		t.Fatalf("pid got %s, expected 20", info.Pid)
+	}
+	if err = Delete(id, func(info *container.ContainerInfo) error { return fmt.Errorf("busy") }); err == nil {
+		t.Fatal("expect delete to be vetoed")
+	}
+	if err = Delete(id, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Get(id); err == nil {
+		t.Fatal("expect error for deleted container")
+	}
+	if _, err = Resolve("web"); err == nil {
+		t.Fatal("expect name released after delete")
+	}
+}
+
+func TestMigrateV0(t *testing.T) {
+	defer setupRoot(t)()
+	id := container.GenerateID()
+	dir := fmt.Sprintf(container.DefaultInfoLocation, id)
+	os.MkdirAll(dir, 0755)
+	legacy := fmt.Sprintf(`{"id":"%s","name":"old","pid":"1","status":"running"}`, id)
+	if err := ioutil.WriteFile(filepath.Join(dir, container.ConfigName), []byte(legacy), 0644); err != nil {
+		t.Fatal(err)
+	}
+	info, err := Get(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.LogConfig.Type != "json-file" || info.Cgroupns != container.NamespaceHost {
+		t.Fatalf("unexpected migrated state %+v", info)
+	}
+	if err = Update(id, func(*container.ContainerInfo) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	content, _ := ioutil.ReadFile(filepath.Join(dir, container.ConfigName))
+	if _, err = decode(content); err != nil {
+		t.Fatal(err)
+	}
+	if !json.Valid(content) || !strings.Contains(string(content), `"version":1`) {
+		t.Fatalf("state not saved with version: %s", content)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/yunfeiyang1916/cloud-docker/container"
+)
+
+// 命令退出后继续读取伪终端中剩余输出的最长时间
+const ptyDrainTimeout = 200 * time.Millisecond
+
+// 把当前终端连接到容器的伪终端上，interactive为false时不转发标准输入
+// 返回的函数在exec的进程退出后调用，转发剩余的输出并恢复终端设置
+func proxyTerminal(pty *container.Pty, interactive bool) func() {
+	restore := func() {}
+	sigCh := make(chan os.Signal, 1)
+	if container.IsTerminal(os.Stdin) {
+		// raw模式下按键原样交给容器内的进程处理，包括ctrl-c等控制字符
+		if r, err := container.SetRawTerminal(os.Stdin); err != nil {
+			logrus.Warnf("set raw terminal error %v", err)
+		} else {
+			restore = r
+		}
+		// 先同步一次窗口大小，之后每次收到SIGWINCH再同步
+		if err := container.ResizePty(pty.Master, os.Stdin); err != nil {
+			logrus.Warnf("resize pty error %v", err)
+		}
+		signal.Notify(sigCh, syscall.SIGWINCH)
+		go func() {
+			for range sigCh {
+				container.ResizePty(pty.Master, os.Stdin)
+			}
+		}()
+	}
+	if interactive {
+		go io.Copy(pty.Master, os.Stdin)
+	}
+	done := make(chan struct{})
+	go func() {
+		// 容器内持有slave端的进程全部退出后，读取master端返回EIO，转发结束
+		io.Copy(os.Stdout, pty.Master)
+		close(done)
+	}()
+	return func() {
+		// 容器内的后台进程可能仍然持有slave端，master端不会返回EIO，
+		// 命令退出后只把已经产生的输出转发完，不再等待其他进程
+		if err := pty.Master.SetReadDeadline(time.Now().Add(ptyDrainTimeout)); err == nil {
+			<-done
+		}
+		signal.Stop(sigCh)
+		close(sigCh)
+		pty.Master.Close()
+		restore()
+	}
+}
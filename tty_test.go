@@ -0,0 +1,35 @@
+package main
+
+import (
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/yunfeiyang1916/cloud-docker/container"
+)
+
+func TestProxyTerminalStopsWhenCommandExits(t *testing.T) {
+	pty, err := container.NewPty()
+	if err != nil {
+		t.Skipf("allocate pty error %v", err)
+	}
+	// 后台进程忽略SIGHUP，在会话首进程退出后继续持有slave端
+	cmd := exec.Command("sh", "-c", "trap '' HUP; sleep 5 & echo hi")
+	pty.SetCtty(cmd)
+	if err = cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	pty.CloseSlave()
+	stop := proxyTerminal(pty, false)
+	cmd.Wait()
+	stopped := make(chan struct{})
+	go func() {
+		stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(2 * time.Second):
+		t.Fatal("proxy did not stop after the command exited")
+	}
+}
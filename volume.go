@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/yunfeiyang1916/cloud-docker/container"
+)
+
+// 数据卷元数据的文件名，放在数据目录中，和数据卷一样在重启后保留
+const (
+	volumesFile = "volumes.json"
+	volumesLock = "volumes.lock"
+)
+
+// 数据卷即通过-v挂载到容器中的宿主机目录，volume create为它记录标签等元数据
+type volumeInfo struct {
+	// 宿主机目录
+	Name      string            `json:"name"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	CreatedAt string            `json:"createdAt"`
+}
+
+// 对数据卷元数据加锁，how为syscall.LOCK_SH或syscall.LOCK_EX，关闭返回的文件即释放锁
+func lockVolumes(how int) (*os.File, error) {
+	if err := os.MkdirAll(container.RootUrl, 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(filepath.Join(container.RootUrl, volumesLock), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err = syscall.Flock(int(f.Fd()), how); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("lock volumes error %v", err)
+	}
+	return f, nil
+}
+
+// 读取所有数据卷的元数据，key为宿主机目录
+func loadVolumes() (map[string]*volumeInfo, error) {
+	lock, err := lockVolumes(syscall.LOCK_SH)
+	if err != nil {
+		return nil, err
+	}
+	defer lock.Close()
+	return readVolumes()
+}
+
+// 调用方需要持有锁
+func readVolumes() (map[string]*volumeInfo, error) {
+	volumes := map[string]*volumeInfo{}
+	content, err := ioutil.ReadFile(filepath.Join(container.RootUrl, volumesFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return volumes, nil
+		}
+		return nil, err
+	}
+	if err = json.Unmarshal(content, &volumes); err != nil {
+		return nil, err
+	}
+	return volumes, nil
+}
+
+// 在排他锁内读取、修改并保存数据卷的元数据，fn返回错误时不保存
+func updateVolumes(fn func(volumes map[string]*volumeInfo) error) error {
+	lock, err := lockVolumes(syscall.LOCK_EX)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+	volumes, err := readVolumes()
+	if err != nil {
+		return err
+	}
+	if err = fn(volumes); err != nil {
+		return err
+	}
+	buf, err := json.Marshal(volumes)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(container.RootUrl, volumesFile)
+	tmp := path + ".tmp"
+	if err = ioutil.WriteFile(tmp, buf, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// 创建数据卷目录并记录元数据，目录已存在时只更新标签
+func createVolume(source string, labels map[string]string) error {
+	source, err := filepath.Abs(source)
+	if err != nil {
+		return err
+	}
+	err = updateVolumes(func(volumes map[string]*volumeInfo) error {
+		if err := os.MkdirAll(source, 0777); err != nil {
+			return err
+		}
+		v, ok := volumes[source]
+		if !ok {
+			v = &volumeInfo{Name: source, CreatedAt: time.Now().Format(time.RFC3339)}
+			volumes[source] = v
+		}
+		v.Labels = labels
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Println(source)
+	return nil
+}
+
+// 删除数据卷的元数据，宿主机目录中的数据保留，和create一样接受相对路径
+func removeVolume(source string) error {
+	source, err := filepath.Abs(source)
+	if err != nil {
+		return err
+	}
+	return updateVolumes(func(volumes map[string]*volumeInfo) error {
+		if _, ok := volumes[source]; !ok {
+			return fmt.Errorf("no such volume: %s", source)
+		}
+		if users := volumeContainers(source); len(users) > 0 {
+			return fmt.Errorf("volume %s is in use by container %s", source, container.ShortID(users[0]))
+		}
+		delete(volumes, source)
+		return nil
+	})
+}
+
+// 使用数据卷的容器
+func volumeContainers(source string) []string {
+	var ids []string
+	for _, info := range allContainers() {
+		if parts := container.VolumeUrls(info.Volume); parts != nil && parts[0] == source {
+			ids = append(ids, info.Id)
+		}
+	}
+	return ids
+}
+
+// 展示记录过元数据或正在被容器使用的数据卷
+func listVolumes(labelFilters []string) error {
+	volumes, err := loadVolumes()
+	if err != nil {
+		return err
+	}
+	for _, info := range allContainers() {
+		if parts := container.VolumeUrls(info.Volume); parts != nil && volumes[parts[0]] == nil {
+			volumes[parts[0]] = &volumeInfo{Name: parts[0]}
+		}
+	}
+	var names []string
+	for name, v := range volumes {
+		if matchLabels(v.Labels, labelFilters) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	w := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
+	fmt.Fprint(w, "VOLUME NAME\tCONTAINERS\tCREATED\n")
+	for _, name := range names {
+		fmt.Fprintf(w, "%s\t%d\t%s\n", name, len(volumeContainers(name)), volumes[name].CreatedAt)
+	}
+	if err = w.Flush(); err != nil {
+		logrus.Errorf("Flush error %v", err)
+	}
+	return nil
+}